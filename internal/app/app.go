@@ -16,6 +16,7 @@ import (
 	"github.com/skillptm/Bolt/internal/config"
 	"github.com/skillptm/Bolt/internal/logger"
 	"github.com/skillptm/Bolt/internal/modules"
+	"github.com/skillptm/Bolt/internal/modules/search/cache"
 )
 
 // App holds all the main data and functions relevant to the front- and backend.
@@ -38,7 +39,7 @@ func NewApp(lg *logger.Logger, images embed.FS, icon embed.FS) (*App, error) {
 
 	lg.ErrorLogPath, lg.HistoryLogPath = conf.Paths["error.log"], conf.Paths["history.log"]
 
-	sh, err := modules.NewSearchHandler(conf)
+	sh, err := modules.NewSearchHandler(conf, lg)
 	if err != nil {
 		return nil, fmt.Errorf("NewApp: couldn't create SearchHandler:\n--> %w", err)
 	}
@@ -130,6 +131,21 @@ func (a *App) openOnHotKey() {
 	}
 }
 
+// FindDuplicates returns the groups of files with the same content to the frontend. An empty scope compares the files of all scopes and empty extensions the files with any extension
+func (a *App) FindDuplicates(scope string, extensions []string) []cache.DuplicateGroup {
+	scopeNames := []string{}
+	if scope != "" {
+		scopeNames = append(scopeNames, scope)
+	}
+
+	return a.SearchHandler.Duplicates(scopeNames, extensions)
+}
+
+// GetIndexStats returns the statistics of the last update of every scope to the frontend
+func (a *App) GetIndexStats() []cache.IndexStats {
+	return a.SearchHandler.IndexStats()
+}
+
 // HideWindow is a wrapper around runtime.WindowHide that ensures our cache data doesn't unnecessarily stay in memory
 func (a *App) HideWindow() {
 	runtime.WindowHide(a.CTX)
@@ -160,6 +176,28 @@ func (a *App) LogEventTS(event string, message string) {
 func (a *App) OpenFileExplorer(filePath string) {
 	var cmd *exec.Cmd
 
+	// files on unplugged volumes can't be shown, until the volume is plugged in again
+	if volumeName, _, ok := cache.SplitOfflinePath(filePath); ok {
+		a.lg.Error("OpenFileExplorer: couldn't open %s, as the volume %s isn't plugged in", filePath, volumeName)
+		return
+	}
+
+	// files in the trash aren't at their path anymore, so the one the trash keeps is selected instead
+	if _, _, ok := cache.SplitTrashPath(filePath); ok {
+		entry, err := a.SearchHandler.FindTrash(filePath)
+		if err != nil {
+			a.lg.Error("OpenFileExplorer: couldn't open %s:\n--> %s", filePath, err.Error())
+			return
+		}
+
+		filePath = entry.TrashedPath
+	}
+
+	// the members of archives only exist inside of them, so their archive is selected instead
+	if archivePath, _, ok := cache.SplitArchivePath(filePath); ok {
+		filePath = archivePath
+	}
+
 	if _, err := exec.LookPath("dolphin"); err == nil {
 		cmd = exec.Command("dolphin", "--select", filePath)
 	} else if _, err := exec.LookPath("nautilus"); err == nil {
@@ -178,6 +216,33 @@ func (a *App) OpenFileExplorer(filePath string) {
 	}
 }
 
+// OpenArchiveMember extracts a file, that's inside of an archive, into a temporary folder and opens it with its default application
+func (a *App) OpenArchiveMember(filePath string) error {
+	extractedPath, err := cache.ExtractArchiveMember(filePath)
+	if err != nil {
+		a.lg.Error("%s", err.Error())
+		return err
+	}
+
+	err = exec.Command("xdg-open", extractedPath).Start()
+	if err != nil {
+		err = fmt.Errorf("OpenArchiveMember: couldn't open %s:\n--> %w", extractedPath, err)
+		a.lg.Error("%s", err.Error())
+	}
+
+	return err
+}
+
+// RestoreTrash moves a file from the trash back to the path it was deleted from. It takes the path the /trash search lists for it
+func (a *App) RestoreTrash(trashPath string) error {
+	err := a.SearchHandler.RestoreTrash(trashPath)
+	if err != nil {
+		a.lg.Error("%s", err.Error())
+	}
+
+	return err
+}
+
 // ShowWindow is a wrapper around runtime.WindowShow that ensures we load our cache data into memory
 func (a *App) ShowWindow() {
 	a.SearchHandler.ImportCache()
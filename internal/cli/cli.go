@@ -0,0 +1,273 @@
+// Package cli handles the subcommands Bolt can be run with from a terminal, instead of opening the app
+package cli
+
+import (
+	"cmp"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/skillptm/Bolt/internal/config"
+	"github.com/skillptm/Bolt/internal/modules/search/cache"
+)
+
+const (
+	topExtensions int = 10 // how many extensions the stats subcommand lists per scope
+
+	usage string = `usage: bolt <subcommand>
+
+subcommands:
+  stats [--json]  shows the statistics of the last update of every scope
+  duplicates [--scope <name>] [--ext <extensions>] [--json]
+                  lists the files with the same content, the ones that waste the most space first.
+                  --scope can be given more than once, the extensions are separated by a ','
+`
+)
+
+// subcommands are the first arguments Run handles
+var subcommands = []string{"stats", "duplicates", "help", "-h", "--help"}
+
+// IsSubcommand checks, if arg is one of the subcommands. Anything else, like the %U a launcher passes along, isn't meant for us
+func IsSubcommand(arg string) bool {
+	return slices.Contains(subcommands, arg)
+}
+
+// Run runs the subcommand in args (without the program name) and returns the exit code
+func Run(args []string, icon embed.FS) int {
+	switch args[0] {
+	case "stats":
+		return stats(args[1:], icon, os.Stdout)
+	case "duplicates":
+		return duplicates(args[1:], icon, os.Stdout)
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown subcommand %s\n\n%s", args[0], usage)
+		return 2
+	}
+}
+
+// stats prints the IndexStats of all scopes, which the running app stores on every update
+func stats(args []string, icon embed.FS, output io.Writer) int {
+	asJSON := false
+
+	for _, arg := range args {
+		if arg != "--json" {
+			fmt.Fprintf(os.Stderr, "unknown argument %s\n\n%s", arg, usage)
+			return 2
+		}
+
+		asJSON = true
+	}
+
+	conf, err := config.NewConfig(icon)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "stats: couldn't load config:\n--> %s\n", err.Error())
+		return 1
+	}
+
+	allStats := cache.LoadIndexStats(conf)
+
+	if asJSON {
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "	")
+
+		err = encoder.Encode(allStats)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "stats: couldn't encode stats:\n--> %s\n", err.Error())
+			return 1
+		}
+
+		return 0
+	}
+
+	for index, scopeStats := range allStats {
+		if index > 0 {
+			fmt.Fprintln(output)
+		}
+
+		printStats(output, scopeStats)
+	}
+
+	return 0
+}
+
+// duplicates prints the groups of files with the same content in the indexes, that the running app stores on every update
+func duplicates(args []string, icon embed.FS, output io.Writer) int {
+	asJSON := false
+	extensions := []string{}
+	scopeNames := []string{}
+
+	for index := 0; index < len(args); index++ {
+		switch {
+		case args[index] == "--json":
+			asJSON = true
+		case (args[index] == "--scope" || args[index] == "--ext") && index+1 < len(args):
+			if args[index] == "--scope" {
+				scopeNames = append(scopeNames, args[index+1])
+			} else {
+				extensions = append(extensions, strings.Split(args[index+1], ",")...)
+			}
+
+			index++
+		default:
+			fmt.Fprintf(os.Stderr, "unknown argument %s\n\n%s", args[index], usage)
+			return 2
+		}
+	}
+
+	conf, err := config.NewConfig(icon)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "duplicates: couldn't load config:\n--> %s\n", err.Error())
+		return 1
+	}
+
+	for _, name := range scopeNames {
+		if !slices.ContainsFunc(conf.Scopes, func(scope config.Scope) bool { return scope.Name == name }) {
+			fmt.Fprintf(os.Stderr, "duplicates: unknown scope %s\n", name)
+			return 2
+		}
+	}
+
+	groups, err := cache.LoadDuplicates(conf, scopeNames, extensions)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "duplicates: couldn't find duplicates, start Bolt to index the scopes:\n--> %s\n", err.Error())
+		return 1
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "	")
+
+		err = encoder.Encode(groups)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "duplicates: couldn't encode duplicates:\n--> %s\n", err.Error())
+			return 1
+		}
+
+		return 0
+	}
+
+	wasted := int64(0)
+
+	for index, group := range groups {
+		if index > 0 {
+			fmt.Fprintln(output)
+		}
+
+		fmt.Fprintf(output, "%d files of %s\n", len(group.Paths), formatBytes(group.Size))
+
+		for _, filePath := range group.Paths {
+			fmt.Fprintf(output, "  %s\n", filePath)
+		}
+
+		wasted += group.Size * int64(len(group.Paths)-1)
+	}
+
+	if len(groups) > 0 {
+		fmt.Fprintln(output)
+	}
+
+	fmt.Fprintf(output, "%d groups of duplicates, %s could be freed\n", len(groups), formatBytes(wasted))
+
+	return 0
+}
+
+// printStats prints the IndexStats of a single scope in a human readable form
+func printStats(output io.Writer, scopeStats cache.IndexStats) {
+	fmt.Fprintf(output, "%s\n", scopeStats.Scope)
+
+	if scopeStats.LastUpdate.IsZero() {
+		fmt.Fprintf(output, "  never updated, start Bolt to index it\n")
+		return
+	}
+
+	watcher := "off, updated on a timer"
+	if scopeStats.Watched {
+		watcher = "live"
+	}
+
+	fmt.Fprintf(output, "  last crawl:   %s (took %s)\n", scopeStats.LastUpdate.Local().Format(time.DateTime), time.Duration(scopeStats.CrawlMilliseconds)*time.Millisecond)
+
+	// the last change is only worth showing, if the watcher changed something since the crawl
+	if scopeStats.LastChange.After(scopeStats.LastUpdate) {
+		fmt.Fprintf(output, "  last change:  %s\n", scopeStats.LastChange.Local().Format(time.DateTime))
+	}
+
+	fmt.Fprintf(output, "  watcher:      %s\n", watcher)
+	fmt.Fprintf(output, "  files:        %d\n", scopeStats.Files)
+	fmt.Fprintf(output, "  folders:      %d\n", scopeStats.Folders)
+	fmt.Fprintf(output, "  cache size:   %s\n", formatBytes(scopeStats.CacheSize))
+
+	extensions := make([]string, 0, len(scopeStats.Extensions))
+	for extension := range scopeStats.Extensions {
+		extensions = append(extensions, extension)
+	}
+
+	slices.SortFunc(extensions, func(a string, b string) int {
+		return cmp.Or(cmp.Compare(scopeStats.Extensions[b], scopeStats.Extensions[a]), cmp.Compare(a, b))
+	})
+
+	if len(extensions) > 0 {
+		fmt.Fprintf(output, "  extensions:  ")
+
+		for _, extension := range extensions[:min(len(extensions), topExtensions)] {
+			name := extension
+			if name == "" {
+				name = "(none)"
+			}
+
+			fmt.Fprintf(output, " %s %d", name, scopeStats.Extensions[extension])
+		}
+
+		fmt.Fprintln(output)
+	}
+
+	fmt.Fprintf(output, "  unreadable:   %d folders\n", scopeStats.UnreadableCount)
+
+	for _, dirPath := range scopeStats.UnreadableDirs {
+		fmt.Fprintf(output, "    %s\n", dirPath)
+	}
+
+	if scopeStats.UnreadableCount > len(scopeStats.UnreadableDirs) {
+		fmt.Fprintf(output, "    and %d more\n", scopeStats.UnreadableCount-len(scopeStats.UnreadableDirs))
+	}
+
+	if scopeStats.TruncatedCount == 0 {
+		return
+	}
+
+	fmt.Fprintf(output, "  truncated:    %d folders\n", scopeStats.TruncatedCount)
+
+	for _, truncated := range scopeStats.TruncatedDirs {
+		fmt.Fprintf(output, "    %s (%s)\n", truncated.Dir, truncated.Reason)
+	}
+
+	if scopeStats.TruncatedCount > len(scopeStats.TruncatedDirs) {
+		fmt.Fprintf(output, "    and %d more\n", scopeStats.TruncatedCount-len(scopeStats.TruncatedDirs))
+	}
+}
+
+// formatBytes formats a size in bytes with a binary unit
+func formatBytes(size int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	value := float64(size)
+	unit := 0
+
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+
+	if unit == 0 {
+		return fmt.Sprintf("%d %s", size, units[unit])
+	}
+
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
@@ -5,31 +5,133 @@ import (
 	"embed"
 	"fmt"
 	"math"
+	"path/filepath"
 	"runtime"
+	"strings"
 
 	"github.com/skillptm/Bolt/internal/util"
 )
 
+// the Storage a Scope can keep its index in
+const (
+	StorageMemory string = "memory"
+	StorageSQLite string = "sqlite"
+)
+
 // Config is made to structure and order the data for the config.json
 type Config struct {
-	MaxCPUThreadPercentage      float64  `json:"MaxCPUThreadPercentage"`
-	ShortcutEnd                 string   `json:"ShortcutEnd"`
-	DefaultDirsCacheUpdateTime  int      `json:"DefaultDirsCacheUpdateTime"`
-	ExtendedDirsCacheUpdateTime int      `json:"ExtendedDirsCacheUpdateTime"`
-	DefaultDirs                 []string `json:"DefaultDirs"`
-	ExtendedDirs                []string `json:"ExtendedDirs"`
-	ExcludeFromDefaultDirs      Rules    `json:"ExcludeFromDefaultDirs"`
-	ExcludeDirs                 Rules    `json:"ExcludeDirs"`
+	MaxCPUThreadPercentage float64          `json:"MaxCPUThreadPercentage"`
+	ShortcutEnd            string           `json:"ShortcutEnd"`
+	VerifyResults          int              `json:"VerifyResults"`
+	TrigramIndex           bool             `json:"TrigramIndex"`
+	IgnoreDiacritics       bool             `json:"IgnoreDiacritics"`
+	Scopes                 []Scope          `json:"Scopes"`
+	ExcludeDirs            Rules            `json:"ExcludeDirs"`
+	FilesystemTypes        *FilesystemTypes `json:"FilesystemTypes"`
+	IgnoreFiles            []string         `json:"IgnoreFiles"`
+	ContentIndex           ContentIndex     `json:"ContentIndex"`
+	TagIndex               TagIndex         `json:"TagIndex"`
+	ArchiveIndex           ArchiveIndex     `json:"ArchiveIndex"`
+	RemovableMedia         RemovableMedia   `json:"RemovableMedia"`
+	Throttle               *Throttle        `json:"Throttle"`
+
+	// these are from before Scopes existed, they're only read to convert them into the "default" and "extended" scope
+	DefaultDirsCacheUpdateTime  int      `json:"DefaultDirsCacheUpdateTime,omitempty"`
+	ExtendedDirsCacheUpdateTime int      `json:"ExtendedDirsCacheUpdateTime,omitempty"`
+	DefaultDirs                 []string `json:"DefaultDirs,omitempty"`
+	ExtendedDirs                []string `json:"ExtendedDirs,omitempty"`
+	ExcludeFromDefaultDirs      *Rules   `json:"ExcludeFromDefaultDirs,omitempty"`
 
 	MaxCPUThreads int               `json:"-"`
 	Paths         map[string]string `json:"-"`
 }
 
-// Rules is made to structure and order the data for the config.json
+// Rules is made to structure and order the data for the config.json. Name, Path, Regex and Glob apply to folders, while Files applies to the files inside of them
 type Rules struct {
-	Name  []string `json:"Name"`
-	Path  []string `json:"Path"`
-	Regex []string `json:"Regex"`
+	Name  []string  `json:"Name"`
+	Path  []string  `json:"Path"`
+	Regex []string  `json:"Regex"`
+	Glob  []string  `json:"Glob"` // globs with a '/' are matched against the whole path, the others only against the folder name
+	Files FileRules `json:"Files"`
+}
+
+// FileRules is made to structure and order the data for the config.json. A file that matches any of the rules isn't indexed
+type FileRules struct {
+	Extension []string `json:"Extension"`
+	Name      []string `json:"Name"`    // globs, that are matched against the whole file name
+	MinSize   int64    `json:"MinSize"` // in bytes, 0 turns it off
+	MaxSize   int64    `json:"MaxSize"` // in bytes, 0 turns it off
+}
+
+/*
+FilesystemTypes is made to structure and order the data for the config.json. The types are matched against the ones in /proc/self/mountinfo and may use globs like "fuse.*".
+
+Mounts with a type in Skip are never crawled, the ones in OnDemand are only crawled on a forced update. If Index isn't empty, only the types in it are crawled.
+The mount a base dir of a Scope is on is never skipped, as a root filesystem can have any type, like overlay in a container.
+*/
+type FilesystemTypes struct {
+	Index    []string `json:"Index"`
+	Skip     []string `json:"Skip"`
+	OnDemand []string `json:"OnDemand"`
+}
+
+// ContentIndex is made to structure and order the data for the config.json. When it's enabled, the contents of the text files in Dirs, with one of the Extensions and at most MaxSize bytes, are indexed, so they can be searched with c:
+type ContentIndex struct {
+	Enabled    bool     `json:"Enabled"`
+	Dirs       []string `json:"Dirs"`
+	Extensions []string `json:"Extensions"`
+	MaxSize    int64    `json:"MaxSize"` // in bytes
+}
+
+// TagIndex is made to structure and order the data for the config.json. When it's enabled, the tags embedded in the photos, music and documents in Dirs (like the camera of a JPEG or the artist of an MP3) are indexed, so they can be searched with flags like artist:radiohead
+type TagIndex struct {
+	Enabled bool     `json:"Enabled"`
+	Dirs    []string `json:"Dirs"`
+}
+
+// ArchiveIndex is made to structure and order the data for the config.json. When it's enabled, the members of the .zip, .jar, .tar, .tar.gz and .tgz files of at most MaxSize bytes are indexed as virtual paths like /path/backup.zip!/docs/report.pdf, so they can be found like any other file
+type ArchiveIndex struct {
+	Enabled bool  `json:"Enabled"`
+	MaxSize int64 `json:"MaxSize"` // in bytes
+}
+
+// RemovableMedia is made to structure and order the data for the config.json. When it's enabled, the drives mounted below one of the Dirs get their own index, which is kept by the UUID of their filesystem.
+// Their files can still be found while they're unplugged, then they show up as "offline (<volume name>)/<path on the drive>" and the index is updated once the drive is plugged in again
+type RemovableMedia struct {
+	Enabled bool     `json:"Enabled"`
+	Dirs    []string `json:"Dirs"`
+}
+
+// Throttle is made to structure and order the data for the config.json. It keeps the updates Bolt runs in the background from getting in the way of everything else, the ones you force run at full speed
+type Throttle struct {
+	IdleIO               bool    `json:"IdleIO"`               // the crawl only gets disk time, when no other process wants it
+	Nice                 int     `json:"Nice"`                 // the niceness of the threads crawling, from 0 to 19
+	MaxReadDirsPerSecond int     `json:"MaxReadDirsPerSecond"` // how many folders are read per second, 0 turns it off
+	MaxLoadAverage       float64 `json:"MaxLoadAverage"`       // the crawl pauses while the 1 minute load average is above it, 0 turns it off
+}
+
+/*
+Scope is made to structure and order the data for the config.json. Every Scope has its own index and cache file.
+
+Dirs are the base dirs of the Scope, folders that are base dirs of another Scope are left to that one.
+Folders that match ExcludeDirs aren't indexed by this Scope, but if PassExcludedTo names another Scope they become base dirs of that one instead.
+Default Scopes are searched without any flags, the others only with /e (all Scopes) or /s:<name>.
+StayOnFilesystem keeps the crawl from descending into other mounts below the Dirs, like find -xdev.
+MaxDepth, MaxDirEntries and MaxEntries limit how much of the Dirs is indexed, the folders they cut off show up in the stats. 0 turns them off.
+Storage is where the index is kept: "memory" (the default) loads all of it, "sqlite" keeps it in a database on the disk and only loads the files a search matches, which suits very large Scopes.
+*/
+type Scope struct {
+	Name             string   `json:"Name"`
+	Default          bool     `json:"Default"`
+	UpdateTime       int      `json:"UpdateTime"` // in seconds
+	Dirs             []string `json:"Dirs"`
+	ExcludeDirs      Rules    `json:"ExcludeDirs"`
+	PassExcludedTo   string   `json:"PassExcludedTo"`
+	StayOnFilesystem bool     `json:"StayOnFilesystem"`
+	MaxDepth         int      `json:"MaxDepth"`      // how many levels of folders below each of the Dirs are read, deeper folders are still indexed, but not their contents
+	MaxDirEntries    int      `json:"MaxDirEntries"` // how many entries of a single folder are indexed
+	MaxEntries       int      `json:"MaxEntries"`    // how many entries the whole Scope may index
+	Storage          string   `json:"Storage"`
 }
 
 // NewConfig is the constructor for Config, it imports the data from the config.json
@@ -41,7 +143,7 @@ func NewConfig(icon embed.FS) (*Config, error) {
 		return nil, fmt.Errorf("NewConfig: couldn't setup folders:\n--> %w", err)
 	}
 
-	newConfig.Paths["default_cache.json"], newConfig.Paths["extended_cache.json"] = files[0], files[1]
+	newConfig.Paths["cache"] = filepath.Dir(files[0])
 	newConfig.Paths["config.json"] = files[2]
 	newConfig.Paths["error.log"], newConfig.Paths["history.log"] = files[4], files[5]
 
@@ -52,5 +154,111 @@ func NewConfig(icon embed.FS) (*Config, error) {
 
 	newConfig.MaxCPUThreads = int(math.Ceil(float64(runtime.NumCPU()) * newConfig.MaxCPUThreadPercentage))
 
+	// configs from before FilesystemTypes existed would otherwise crawl /proc, /sys and the like
+	if newConfig.FilesystemTypes == nil {
+		newConfig.FilesystemTypes = defaultFilesystemTypes()
+	}
+
+	// configs from before Throttle existed get the same low impact background updates as new ones
+	if newConfig.Throttle == nil {
+		newConfig.Throttle = defaultThrottle()
+	}
+
+	if len(newConfig.Scopes) == 0 {
+		newConfig.Scopes = newConfig.legacyScopes()
+	}
+
+	err = newConfig.validateScopes()
+	if err != nil {
+		return nil, fmt.Errorf("NewConfig: invalid scopes:\n--> %w", err)
+	}
+
+	if newConfig.ContentIndex.Enabled && newConfig.ContentIndex.MaxSize <= 0 {
+		return nil, fmt.Errorf("NewConfig: the MaxSize of the ContentIndex has to be larger than 0, but is %d", newConfig.ContentIndex.MaxSize)
+	}
+
+	if newConfig.ArchiveIndex.Enabled && newConfig.ArchiveIndex.MaxSize <= 0 {
+		return nil, fmt.Errorf("NewConfig: the MaxSize of the ArchiveIndex has to be larger than 0, but is %d", newConfig.ArchiveIndex.MaxSize)
+	}
+
+	err = newConfig.Throttle.validate()
+	if err != nil {
+		return nil, fmt.Errorf("NewConfig: invalid Throttle:\n--> %w", err)
+	}
+
 	return &newConfig, nil
 }
+
+// validate makes sure the Throttle only has values we can apply
+func (t *Throttle) validate() error {
+	if t.Nice < 0 || t.Nice > 19 {
+		return fmt.Errorf("validate: Nice has to be between 0 and 19, but is %d", t.Nice)
+	}
+
+	if t.MaxReadDirsPerSecond < 0 || t.MaxLoadAverage < 0 {
+		return fmt.Errorf("validate: MaxReadDirsPerSecond and MaxLoadAverage can't be negative")
+	}
+
+	return nil
+}
+
+// legacyScopes converts the DefaultDirs and ExtendedDirs of a config from before Scopes existed into the "default" and "extended" scope, which keep using the same cache files
+func (c *Config) legacyScopes() []Scope {
+	excludeFromDefaultDirs := Rules{}
+	if c.ExcludeFromDefaultDirs != nil {
+		excludeFromDefaultDirs = *c.ExcludeFromDefaultDirs
+	}
+
+	return []Scope{
+		{
+			Name:           "default",
+			Default:        true,
+			UpdateTime:     c.DefaultDirsCacheUpdateTime,
+			Dirs:           c.DefaultDirs,
+			ExcludeDirs:    excludeFromDefaultDirs,
+			PassExcludedTo: "extended",
+		},
+		{
+			Name:       "extended",
+			UpdateTime: c.ExtendedDirsCacheUpdateTime,
+			Dirs:       c.ExtendedDirs,
+		},
+	}
+}
+
+// validateScopes makes sure every Scope has a unique name, that can be used in its cache file name, and only passes to Scopes that exist
+func (c *Config) validateScopes() error {
+	names := make(map[string]bool)
+
+	for _, scope := range c.Scopes {
+		if scope.Name == "" || strings.ContainsAny(scope.Name, "/, ") {
+			return fmt.Errorf("validateScopes: scope name \"%s\" can't be empty or contain '/', ',' or ' '", scope.Name)
+		}
+
+		if names[scope.Name] {
+			return fmt.Errorf("validateScopes: scope name \"%s\" is used more than once", scope.Name)
+		}
+
+		if scope.UpdateTime <= 0 {
+			return fmt.Errorf("validateScopes: scope \"%s\" needs an UpdateTime above 0", scope.Name)
+		}
+
+		if scope.MaxDepth < 0 || scope.MaxDirEntries < 0 || scope.MaxEntries < 0 {
+			return fmt.Errorf("validateScopes: scope \"%s\" can't have a negative MaxDepth, MaxDirEntries or MaxEntries", scope.Name)
+		}
+
+		if scope.Storage != "" && scope.Storage != StorageMemory && scope.Storage != StorageSQLite {
+			return fmt.Errorf("validateScopes: scope \"%s\" has unknown Storage \"%s\", it has to be \"%s\" or \"%s\"", scope.Name, scope.Storage, StorageMemory, StorageSQLite)
+		}
+
+		names[scope.Name] = true
+	}
+
+	for _, scope := range c.Scopes {
+		if scope.PassExcludedTo != "" && (!names[scope.PassExcludedTo] || scope.PassExcludedTo == scope.Name) {
+			return fmt.Errorf("validateScopes: scope \"%s\" passes to unknown scope \"%s\"", scope.Name, scope.PassExcludedTo)
+		}
+	}
+
+	return nil
+}
@@ -37,8 +37,8 @@ func setup(icon embed.FS) ([]string, error) {
 	}
 
 	files := []string{
-		fmt.Sprintf("%s/bolt/default_cache.json", cacheDir),
-		fmt.Sprintf("%s/bolt/extended_cache.json", cacheDir),
+		fmt.Sprintf("%s/bolt/default_cache.bin", cacheDir),
+		fmt.Sprintf("%s/bolt/extended_cache.bin", cacheDir),
 		fmt.Sprintf("%s/bolt/config.json", configDir),
 		fmt.Sprintf("%s/.local/share/bolt/bolt.png", homeDir),
 		fmt.Sprintf("%s/.local/share/bolt/error.log", homeDir),
@@ -70,7 +70,7 @@ func validateFolders(dirsToCheck []string) error {
 	return nil
 }
 
-// validateFiles checks, if config.json and search_cache.json exist
+// validateFiles checks, if config.json, bolt.desktop and bolt.png exist
 func validateFiles(filesToCheck []string, icon embed.FS) error {
 	for _, file := range filesToCheck {
 		if _, err := os.Stat(file); !os.IsNotExist(err) {
@@ -97,21 +97,45 @@ func resetConfig(configPath string) error {
 	}
 
 	defaultConfig := Config{
-		MaxCPUThreadPercentage:      0.25, // percentage of threads that may be used, always rounding the threads up
-		ShortcutEnd:                 "space",
-		DefaultDirsCacheUpdateTime:  120,  // in seconds
-		ExtendedDirsCacheUpdateTime: 1800, // in seconds
-		DefaultDirs: []string{
-			fmt.Sprintf("%s/", homedir),
-		},
-		ExtendedDirs: []string{
-			"/",
-		},
-		ExcludeFromDefaultDirs: Rules{
-			Name: []string{},
-			Path: []string{},
-			Regex: []string{
-				fmt.Sprintf(`^%s/\.[^/]+/?$`, homedir),
+		MaxCPUThreadPercentage: 0.25, // percentage of threads that may be used, always rounding the threads up
+		ShortcutEnd:            "space",
+		VerifyResults:          30,   // how many of the top results are checked to still exist, 0 turns it off
+		TrigramIndex:           true, // speeds up searches with 3 or more characters, at the cost of memory while Bolt is open
+		IgnoreDiacritics:       true, // lets "resume" find "résumé"
+		Scopes: []Scope{
+			{
+				Name:       "default",
+				Default:    true,
+				UpdateTime: 120, // in seconds
+				Dirs: []string{
+					fmt.Sprintf("%s/", homedir),
+				},
+				ExcludeDirs: Rules{
+					Name: []string{},
+					Path: []string{},
+					Regex: []string{
+						fmt.Sprintf(`^%s/\.[^/]+/?$`, homedir),
+					},
+					Glob:  []string{},
+					Files: emptyFileRules(),
+				},
+				PassExcludedTo: "extended",
+				Storage:        StorageMemory,
+			},
+			{
+				Name:       "extended",
+				UpdateTime: 1800, // in seconds
+				Dirs: []string{
+					"/",
+				},
+				ExcludeDirs: Rules{
+					Name:  []string{},
+					Path:  []string{},
+					Regex: []string{},
+					Glob:  []string{},
+					Files: emptyFileRules(),
+				},
+				Storage: StorageMemory,
 			},
 		},
 		ExcludeDirs: Rules{
@@ -122,6 +146,79 @@ func resetConfig(configPath string) error {
 			},
 			Path:  []string{},
 			Regex: []string{},
+			Glob:  []string{},
+			Files: FileRules{
+				Extension: []string{
+					".o",
+					".obj",
+					".pyc",
+					".pyo",
+					".swo",
+					".swp",
+				},
+				Name: []string{
+					"*~",
+				},
+				MinSize: 0,
+				MaxSize: 0,
+			},
+		},
+		FilesystemTypes: defaultFilesystemTypes(),
+		Throttle:        defaultThrottle(),
+		IgnoreFiles: []string{ // files with gitignore patterns, that are honored in the folder they're in and below it
+			".gitignore",
+			".ignore",
+			".boltignore",
+		},
+		ContentIndex: ContentIndex{ // lets you search the text inside of files with c:, at the cost of reading them on every update they changed in
+			Enabled: false,
+			Dirs: []string{
+				fmt.Sprintf("%s/", homedir),
+			},
+			Extensions: []string{
+				".txt",
+				".md",
+				".org",
+				".rst",
+				".tex",
+				".csv",
+				".conf",
+				".cfg",
+				".ini",
+				".toml",
+				".yaml",
+				".yml",
+				".json",
+				".xml",
+				".sh",
+				".py",
+				".go",
+				".js",
+				".ts",
+				".c",
+				".h",
+				".rs",
+				".java",
+			},
+			MaxSize: 1048576, // in bytes
+		},
+		TagIndex: TagIndex{ // only the parts of the files with the tags in them are read, and only again once the files change
+			Enabled: true,
+			Dirs: []string{
+				fmt.Sprintf("%s/", homedir),
+			},
+		},
+		ArchiveIndex: ArchiveIndex{ // a .tar.gz has to be decompressed completely to list it, which happens again on every update it changed in
+			Enabled: false,
+			MaxSize: 104857600, // in bytes
+		},
+		RemovableMedia: RemovableMedia{ // the drives mounted below these are searched with the scopes they're in, even while they're unplugged
+			Enabled: true,
+			Dirs: []string{
+				"/media/",
+				"/run/media/",
+				"/mnt/",
+			},
 		},
 	}
 
@@ -184,3 +281,63 @@ func resetIcon(iconPath string, icon embed.FS) error {
 
 	return nil
 }
+
+// defaultThrottle returns the Throttle for a new config. The crawl gets out of the way of other processes, but isn't slowed down while the system is idle
+func defaultThrottle() *Throttle {
+	return &Throttle{
+		IdleIO:               true,
+		Nice:                 10,
+		MaxReadDirsPerSecond: 0,
+		MaxLoadAverage:       0,
+	}
+}
+
+// defaultFilesystemTypes returns the FilesystemTypes for a new config. Pseudo filesystems are skipped and network or FUSE mounts, which can be slow or hang, are only crawled on demand
+func defaultFilesystemTypes() *FilesystemTypes {
+	return &FilesystemTypes{
+		Index: []string{},
+		Skip: []string{
+			"autofs",
+			"binfmt_misc",
+			"bpf",
+			"cgroup",
+			"cgroup2",
+			"configfs",
+			"debugfs",
+			"devpts",
+			"devtmpfs",
+			"efivarfs",
+			"fusectl",
+			"hugetlbfs",
+			"mqueue",
+			"nsfs",
+			"proc",
+			"pstore",
+			"securityfs",
+			"squashfs",
+			"sysfs",
+			"tracefs",
+		},
+		OnDemand: []string{
+			"9p",
+			"afs",
+			"ceph",
+			"cifs",
+			"fuse.*",
+			"glusterfs",
+			"nfs",
+			"nfs4",
+			"smb3",
+			"smbfs",
+			"sshfs",
+		},
+	}
+}
+
+// emptyFileRules returns FileRules that don't exclude anything, with empty lists instead of null in the config.json
+func emptyFileRules() FileRules {
+	return FileRules{
+		Extension: []string{},
+		Name:      []string{},
+	}
+}
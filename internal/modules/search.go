@@ -7,30 +7,39 @@ import (
 	"runtime"
 	"runtime/debug"
 	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/skillptm/Bolt/internal/config"
+	"github.com/skillptm/Bolt/internal/logger"
 	"github.com/skillptm/Bolt/internal/modules/search"
 	"github.com/skillptm/Bolt/internal/modules/search/cache"
-	"github.com/skillptm/Bolt/internal/util"
+	"github.com/skillptm/Bolt/internal/modules/search/extract"
 )
 
 // SearchHandler is an interface which will hold the indexed cache and be the start point for searches
 type SearchHandler struct {
-	fileSystem    *cache.Filesystem
+	fileSystem    atomic.Pointer[cache.Filesystem] // swapped by a reset, so searches get it once and keep using that one
 	forceStopChan chan bool
+	lg            *logger.Logger
+	searchMu      sync.Mutex // guards forceStopChan and searching, as every search runs on its own goroutine
 	searching     bool
+	verifyResults int
 
 	ResultsChan chan []string
 }
 
 // NewSearchHandler is the constructor for SearchHandler, which also sets up the cache and the Filesystem
-func NewSearchHandler(conf *config.Config) (*SearchHandler, error) {
+func NewSearchHandler(conf *config.Config, lg *logger.Logger) (*SearchHandler, error) {
 	sh := SearchHandler{
 		forceStopChan: make(chan bool, 1),
+		lg:            lg,
 		ResultsChan:   make(chan []string, 1),
 		searching:     false,
+		verifyResults: conf.VerifyResults,
 	}
 
 	fs, err := cache.NewFilesystem(conf)
@@ -38,26 +47,28 @@ func NewSearchHandler(conf *config.Config) (*SearchHandler, error) {
 		return nil, fmt.Errorf("NewFilesystem: couldn't setup Filesystem:\n--> %w", err)
 	}
 
-	sh.fileSystem = fs
+	sh.fileSystem.Store(fs)
 
 	return &sh, nil
 }
 
 // ClearImportedCache clears the cache data from memory
 func (sh *SearchHandler) ClearImportedCache() {
-	sh.fileSystem.DefaultDirs.DirMap = make(map[string]map[int][]cache.File)
-	sh.fileSystem.DefaultDirs.Paths = make(map[int]string)
-	sh.fileSystem.DefaultDirs.Imported = false
+	fs := sh.fileSystem.Load()
 
-	sh.fileSystem.ExtendedDirs.DirMap = make(map[string]map[int][]cache.File)
-	sh.fileSystem.ExtendedDirs.Paths = make(map[int]string)
-	sh.fileSystem.ExtendedDirs.Imported = false
+	for _, dirs := range fs.Scopes {
+		dirs.Clear()
+	}
+
+	for _, volume := range fs.Volumes() {
+		volume.Dirs().Clear()
+	}
 
 	runtime.GC()
 	debug.FreeOSMemory()
 }
 
-// ForceUpdateCache immediately updates the cache. If extended is set the default/extended caches are updated and reset will reset the whole Filesystem on the SearchHandler
+// ForceUpdateCache immediately updates the cache, including the mounts that are only indexed on demand. If extended is set all scopes are updated instead of only the default ones and reset will reset the whole Filesystem on the SearchHandler
 func (sh *SearchHandler) ForceUpdateCache(conf *config.Config, extended bool, reset bool) error {
 	if reset {
 		fs, err := cache.NewFilesystem(conf)
@@ -65,12 +76,15 @@ func (sh *SearchHandler) ForceUpdateCache(conf *config.Config, extended bool, re
 			return fmt.Errorf("ForceUpdateCache: couldn't setup Filesystem:\n--> %w", err)
 		}
 
-		sh.fileSystem = fs
-	} else if extended {
-		sh.fileSystem.Update(&sh.fileSystem.DefaultDirs, &sh.fileSystem.ExtendedDirs)
-		sh.fileSystem.Update(&sh.fileSystem.ExtendedDirs, &sh.fileSystem.DefaultDirs)
+		sh.fileSystem.Swap(fs).Close()
 	} else {
-		sh.fileSystem.Update(&sh.fileSystem.DefaultDirs, &sh.fileSystem.ExtendedDirs)
+		fs := sh.fileSystem.Load()
+
+		for _, dirs := range fs.Scopes {
+			if dirs.Default || extended {
+				fs.Update(dirs, true)
+			}
+		}
 	}
 
 	runtime.GC()
@@ -78,51 +92,171 @@ func (sh *SearchHandler) ForceUpdateCache(conf *config.Config, extended bool, re
 	return nil
 }
 
-// ImportCache imports the cache data from the disk into memory
+// IndexStats returns the statistics of the last update of every scope
+func (sh *SearchHandler) IndexStats() []cache.IndexStats {
+	return sh.fileSystem.Load().IndexStats()
+}
+
+// ImportCache imports the cache data from the disk into memory. A cache that can't be imported is logged and rebuilt in the background
 func (sh *SearchHandler) ImportCache() {
-	sh.fileSystem.DefaultDirs.Mu.Lock()
-	util.GetJSON(sh.fileSystem.DefaultDirs.CachePath, &sh.fileSystem.DefaultDirs)
-	sh.fileSystem.DefaultDirs.Mu.Unlock()
-	sh.fileSystem.DefaultDirs.Imported = true
+	fs := sh.fileSystem.Load()
+
+	for _, dirs := range fs.Scopes {
+		if dirs.Default {
+			sh.importDirs(dirs)
+			continue
+		}
+
+		// in a goroutine to speed up start up time
+		go sh.importDirs(dirs)
+	}
 
-	// in a goroutine to speed up start up time
-	go func() {
-		sh.fileSystem.ExtendedDirs.Mu.Lock()
-		util.GetJSON(sh.fileSystem.ExtendedDirs.CachePath, &sh.fileSystem.ExtendedDirs)
-		sh.fileSystem.ExtendedDirs.Mu.Unlock()
-		sh.fileSystem.ExtendedDirs.Imported = true
-	}()
+	// searches don't wait for the volumes, as they're only part of the scopes
+	for _, volume := range fs.Volumes() {
+		go sh.importDirs(volume.Dirs())
+	}
+}
+
+// importDirs imports the cache of a single Dirs and triggers a rebuild of it, if the cache file is missing, truncated or corrupt
+func (sh *SearchHandler) importDirs(dirs *cache.Dirs) {
+	err := dirs.Import()
+	if err != nil {
+		sh.lg.Error("importDirs: couldn't import cache, rebuilding it:\n--> %s", err.Error())
+		sh.fileSystem.Load().Rebuild(dirs)
+	}
 }
 
 // Search is the public facing wrapper for the search function, handling breaking old searches and starting new ones
 func (sh *SearchHandler) Search(input string) {
+	forceStopChan := sh.startSearch()
+	fs := sh.fileSystem.Load()
+	searchString, flags := matchFlags(input)
+	scopes := sh.selectScopes(fs, flags.extended, flags.scopes)
+
+	// Importing the non default scopes is done over a goroutine, which might not have finished here. So we wait for them and break early, if the next search starts in the meantime
+	for _, dirs := range scopes {
+		select {
+		case <-dirs.Ready():
+		case <-forceStopChan:
+			return
+		}
+	}
+
+	var result []string
+	switch {
+	case flags.content:
+		result = search.StartContent(searchString, fs, forceStopChan, scopes, flags.extensions, sh.verifyResults)
+	case flags.changes != "":
+		since := time.Time{}
+		if flags.changedWithin > 0 {
+			since = time.Now().Add(-flags.changedWithin)
+		}
+
+		result = search.StartJournal(searchString, flags.changes == "deleted", since, fs, forceStopChan, scopes, flags.extensions, sh.verifyResults)
+	case flags.trash:
+		result = search.StartTrash(searchString, fs, forceStopChan, flags.extensions, sh.verifyResults)
+	case len(flags.tags) > 0:
+		result = search.StartTags(searchString, flags.tags, fs, forceStopChan, scopes, flags.extensions, sh.verifyResults)
+	default:
+		result = search.Start(searchString, fs, forceStopChan, flags.literal, scopes, flags.extensions, sh.verifyResults)
+	}
+
+	// we only want to emit the results, if we got any and we have a search String (or tags, changes or the trash) to avoid updating to no results in the middle of typing
+	if len(searchString) > 0 || len(flags.tags) > 0 || flags.changes != "" || flags.trash {
+		sh.ResultsChan <- result
+	}
+}
+
+// Duplicates returns the groups of files with the same content in the scopes with one of the names, or in all scopes if there are none. Only files with one of the extensions are compared, or all of them if there are none.
+// Like a search it's broken by the next search, in which case it returns no groups
+func (sh *SearchHandler) Duplicates(scopeNames []string, extensions []string) []cache.DuplicateGroup {
+	forceStopChan := sh.startSearch()
+	fs := sh.fileSystem.Load()
+
+	return fs.Duplicates(sh.selectScopes(fs, true, scopeNames), extensions, forceStopChan)
+}
+
+// FindTrash returns the TrashEntry of a path from the /trash search
+func (sh *SearchHandler) FindTrash(trashPath string) (cache.TrashEntry, error) {
+	entry, err := sh.fileSystem.Load().FindTrash(trashPath)
+	if err != nil {
+		return cache.TrashEntry{}, fmt.Errorf("FindTrash: couldn't find the entry:\n--> %w", err)
+	}
+
+	return entry, nil
+}
+
+// RestoreTrash moves the file with the trashPath from the /trash search back to where it was deleted from
+func (sh *SearchHandler) RestoreTrash(trashPath string) error {
+	err := sh.fileSystem.Load().RestoreTrash(trashPath)
+	if err != nil {
+		return fmt.Errorf("RestoreTrash: couldn't restore file:\n--> %w", err)
+	}
+
+	return nil
+}
+
+// startSearch breaks the running search, if there is one, and returns the forceStopChan of the new one
+func (sh *SearchHandler) startSearch() chan bool {
+	sh.searchMu.Lock()
+	defer sh.searchMu.Unlock()
+
 	if sh.searching {
 		sh.forceStopChan <- true
 		sh.searching = false
 	}
 
 	// set a new forceStopChan everytime, to stop confusion on what search to break
-	sh.forceStopChan = make(chan bool, 1)
-	searchString, extendedSearch, literalSearch, fileExtensions := matchFlags(input)
+	forceStopChan := make(chan bool, 1)
+	sh.forceStopChan = forceStopChan
 	sh.searching = true
 
-	// Importing the extended dirs is done over a goroutine, which might not have finished here. So we wait for it and break early with the forceStopChan, if needed
-	if extendedSearch && !sh.fileSystem.ExtendedDirs.Imported {
-		for !sh.fileSystem.ExtendedDirs.Imported {
-			time.Sleep(time.Duration(5) * time.Millisecond)
-		}
+	return forceStopChan
+}
 
-		if len(sh.forceStopChan) > 0 {
-			return
+// selectScopes returns the scopes a search should cover. Named scopes take priority, then all scopes for an extended search and otherwise the default ones
+func (sh *SearchHandler) selectScopes(fs *cache.Filesystem, extendedSearch bool, scopeNames []string) []*cache.Dirs {
+	scopes := []*cache.Dirs{}
+
+	for _, name := range scopeNames {
+		// unknown names are skipped, as they're most likely still being typed
+		if dirs := fs.Scope(name); dirs != nil && !slices.Contains(scopes, dirs) {
+			scopes = append(scopes, dirs)
 		}
 	}
 
-	result := search.Start(searchString, sh.fileSystem, sh.forceStopChan, literalSearch, extendedSearch, fileExtensions)
+	if len(scopeNames) > 0 {
+		return scopes
+	}
 
-	// we only want to emit the results, if we got any and we have a search String to avoid updating to no results in the middle of typing
-	if len(searchString) > 0 {
-		sh.ResultsChan <- result
+	for _, dirs := range fs.Scopes {
+		if dirs.Default || extendedSearch {
+			scopes = append(scopes, dirs)
+		}
 	}
+
+	return scopes
+}
+
+// changeUnits are the units of the time after /new and /deleted
+var changeUnits = map[string]time.Duration{
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+}
+
+// searchFlags holds the flags matchFlags found in the input
+type searchFlags struct {
+	changedWithin time.Duration // 0 means all changes in the journal
+	changes       string        // "new" or "deleted", if it's a search through the journal
+	content       bool
+	extended      bool
+	extensions    []string
+	literal       bool
+	scopes        []string
+	tags          map[string]string
+	trash         bool
 }
 
 /*
@@ -130,35 +264,105 @@ matchFlags cleans the input and returns the flag values in it, it also removes l
 
 The flags it matches for are:
 
+c: at the start: which tells us the search is a content search, so it searches the words inside of the files instead of their names
 "search term": which tells us the search is a literal search, so we'll only return exact matches
-/e and /E: which tell us if the search is an extended search
+/e and /E: which tell us if the search is an extended search, so it covers all scopes
+/s:<scope names>: which tells us the scopes the search covers. The separator for scope names is a ','
+/new and /deleted: which tell us to search the files that were recently added, modified or renamed, or the ones that were deleted. A time like /new:2h or /deleted:1d (m, h, d or w) limits how recent
+/trash: which tells us to search the files in the trash, by the path they were deleted from
 <file extensions>: which tells us the file extensions. The separator for extensions is a ','
+<tag>:<value> like artist:radiohead or camera:"x100": which tells us the tags the files need to have, the tags are the extract.Keys
 
 Example:
 
-input: "myFile /e <txt, go>" -> output: "myfile", ["txt", "go"], true, false
+input: "myFile /s:projects,media <txt, go>" -> output: "myfile", searchFlags{extensions: ["txt", "go"], scopes: ["projects", "media"]}
 */
-func matchFlags(input string) (string, bool, bool, []string) {
+func matchFlags(input string) (string, searchFlags) {
 	input = strings.ToLower(input)
-	literalSearch := false
-	extendedSearch := false
-	extensions := []string{}
+	flags := searchFlags{extensions: []string{}, scopes: []string{}, tags: make(map[string]string)}
+
+	if trimmed, ok := strings.CutPrefix(strings.TrimLeft(input, " "), "c:"); ok {
+		flags.content = true
+		input = trimmed
+	}
 
 	notInLiteral := func(pattern string) bool {
 		return len(regexp.MustCompile(fmt.Sprintf("\".*(%s).*\"", pattern)).FindAllString(input, -1)) == 0
 	}
 
-	// the pattern detects: /e for the extended search flag
-	pattern := "(?:^| )/e(?:$| )"
+	// the pattern detects: <tag>: and the value after it, which may be quoted to contain spaces
+	pattern := fmt.Sprintf("(?:^| )(%s):(\"[^\"]*\"|[^ \"]*)", strings.Join(extract.Keys, "|"))
 
 	regex := regexp.MustCompile(pattern)
 
+	// tag values can be quoted themselves, so we only skip them, if the whole input is a literal
+	trimmed := strings.TrimSpace(input)
+	wholeLiteral := strings.HasPrefix(trimmed, "\"") && strings.Index(trimmed[1:], "\"") == len(trimmed)-2
+
+	if matches := regex.FindAllStringSubmatch(input, -1); len(matches) > 0 && !wholeLiteral {
+		for _, match := range matches {
+			if value := strings.Trim(match[2], "\""); value != "" {
+				flags.tags[match[1]] = value
+			}
+		}
+
+		input = regex.ReplaceAllString(input, " ")
+	}
+
+	// the pattern detects: /e for the extended search flag
+	pattern = "(?:^| )/e(?:$| )"
+
+	regex = regexp.MustCompile(pattern)
+
 	if len(regex.FindAllString(input, 1)) > 0 && notInLiteral(pattern) {
-		extendedSearch = true
+		flags.extended = true
 
 		input = regex.ReplaceAllString(input, "")
 	}
 
+	// the pattern detects: /new or /deleted and the time after it
+	pattern = "(?:^| )/(new|deleted)(?::(\\d+)([mhdw]))?(?:$| )"
+
+	regex = regexp.MustCompile(pattern)
+
+	if match := regex.FindStringSubmatch(input); match != nil && notInLiteral(pattern) {
+		flags.changes = match[1]
+
+		if amount, err := strconv.Atoi(match[2]); err == nil {
+			flags.changedWithin = time.Duration(amount) * changeUnits[match[3]]
+		}
+
+		input = regex.ReplaceAllString(input, " ")
+	}
+
+	// the pattern detects: /trash for the trash search flag
+	pattern = "(?:^| )/trash(?:$| )"
+
+	regex = regexp.MustCompile(pattern)
+
+	if len(regex.FindAllString(input, 1)) > 0 && notInLiteral(pattern) {
+		flags.trash = true
+
+		input = regex.ReplaceAllString(input, " ")
+	}
+
+	// the pattern detects: /s: and the scope names after it
+	pattern = "(?:^| )/s:([^ \"]*)(?:$| )"
+
+	regex = regexp.MustCompile(pattern)
+
+	if matches := regex.FindAllStringSubmatch(input, -1); len(matches) > 0 && notInLiteral(pattern) {
+		for _, match := range matches {
+			for _, name := range strings.Split(match[1], ",") {
+				if name != "" {
+					flags.scopes = append(flags.scopes, name)
+				}
+			}
+		}
+
+		input = regex.ReplaceAllString(input, " ")
+	}
+
 	// the pattern detects: anything between (and including) < and > for the extensions
 	pattern = "<[^>]*>"
 
@@ -170,7 +374,7 @@ func matchFlags(input string) (string, bool, bool, []string) {
 				match = strings.ReplaceAll(match, char, "")
 			}
 
-			extensions = append(extensions, strings.Split(match, ",")...)
+			flags.extensions = append(flags.extensions, strings.Split(match, ",")...)
 		}
 
 		input = regex.ReplaceAllString(input, "")
@@ -179,15 +383,16 @@ func matchFlags(input string) (string, bool, bool, []string) {
 	// remove any lone flag characters from the search
 	input = strings.Trim(input, " /<>")
 
-	if index := strings.LastIndex(input, "."); index >= 0 && !slices.Contains(extensions, "folder") && notInLiteral("\\.") {
-		extensions = append(extensions, input[index:])
+	// words in a content search can end in a period, so only the <> flag sets extensions for it
+	if index := strings.LastIndex(input, "."); index >= 0 && !flags.content && !slices.Contains(flags.extensions, "folder") && notInLiteral("\\.") {
+		flags.extensions = append(flags.extensions, input[index:])
 		input = input[:index]
 	}
 
 	if strings.HasPrefix(input, "\"") && strings.HasSuffix(input, "\"") && len(input) > 1 {
-		literalSearch = true
+		flags.literal = true
 		input = input[1 : len(input)-1]
 	}
 
-	return input, extendedSearch, literalSearch, extensions
+	return input, flags
 }
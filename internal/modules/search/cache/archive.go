@@ -0,0 +1,435 @@
+// Package cache handles everything that has to do with the generation of the cache for the Search function, to the generation of our folder structure and importing of the config.
+package cache
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/skillptm/Bolt/internal/config"
+)
+
+const (
+	archiveSeparator  string = "!"    // the path of a member is the path of its archive, this and then the path inside of the archive
+	maxArchiveMembers int    = 100000 // archives with more members only get the first ones indexed
+)
+
+// the formats of the archives we can list
+const (
+	noArchive int = iota
+	zipArchive
+	tarArchive
+	tarGzipArchive
+)
+
+// memberList collects the members of an archive as basicFiles. The folders of the members are added, even if the archive has no entries for them
+type memberList struct {
+	folders map[string]bool
+	found   []basicFile
+	root    string
+}
+
+// archiveFormat returns the format of the archive with the file name, or noArchive if it isn't one we can list
+func archiveFormat(name string) int {
+	name = strings.ToLower(name)
+
+	switch {
+	case strings.HasSuffix(name, ".zip"), strings.HasSuffix(name, ".jar"):
+		return zipArchive
+	case strings.HasSuffix(name, ".tar"):
+		return tarArchive
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return tarGzipArchive
+	default:
+		return noArchive
+	}
+}
+
+// SplitArchivePath splits the virtual path of an archive member into the path of the archive and the path inside of it. The bool is false, if the path isn't inside of an archive
+func SplitArchivePath(filePath string) (string, string, bool) {
+	marker := archiveSeparator + string(filepath.Separator)
+
+	for offset := 0; ; {
+		index := strings.Index(filePath[offset:], marker)
+		if index < 0 {
+			return "", "", false
+		}
+
+		index += offset
+
+		// a folder that just happens to end in the separator isn't an archive
+		if archiveFormat(filepath.Base(filePath[:index])) != noArchive {
+			return filePath[:index], filePath[index+len(marker):], true
+		}
+
+		offset = index + len(marker)
+	}
+}
+
+// Lstat works like os.Lstat, but for archive members it returns the FileInfo of their archive, as they don't exist on the disk on their own
+func Lstat(filePath string) (os.FileInfo, error) {
+	if archivePath, _, ok := SplitArchivePath(filePath); ok {
+		return os.Lstat(archivePath)
+	}
+
+	return os.Lstat(filePath)
+}
+
+// maxArchiveSize returns the size up to which the members of archives are indexed, or 0 if they aren't
+func maxArchiveSize(conf config.ArchiveIndex) int64 {
+	if !conf.Enabled {
+		return 0
+	}
+
+	return conf.MaxSize
+}
+
+// inArchive checks, if the item is a member of an archive
+func (item *basicFile) inArchive() bool {
+	_, _, ok := SplitArchivePath(item.path)
+	return ok
+}
+
+// archiveRoot returns the virtual folder the members of the archive item are in
+func (item *basicFile) archiveRoot() string {
+	return fmt.Sprintf("%s%s%s%s%s", item.path, item.name, item.extension, archiveSeparator, string(filepath.Separator))
+}
+
+// wantsArchive checks, if the members of the item should be indexed
+func (fs *Filesystem) wantsArchive(item basicFile) bool {
+	if fs.maxArchiveSize <= 0 || item.isFolder || item.metadata.Size > fs.maxArchiveSize || !os.FileMode(item.metadata.Mode).IsRegular() {
+		return false
+	}
+
+	return archiveFormat(item.name+item.extension) != noArchive && !item.inArchive()
+}
+
+// archiveStat returns the DirStat of the virtual folder of an archive, which changes whenever the archive does. As an archive has no rules of its own, its size takes their place
+func archiveStat(metadata Metadata) DirStat {
+	return DirStat{ModTime: metadata.ModTime, Inode: metadata.Inode, Rules: uint64(metadata.Size)}
+}
+
+// visitArchive sends the members of the archive item to the results. They're only listed again, if the archive changed since the last update, otherwise the ones from back then are sent
+func (fs *Filesystem) visitArchive(t *traversal, item basicFile) {
+	root := item.archiveRoot()
+	stat := archiveStat(item.metadata)
+
+	members := []basicFile{}
+
+	if previous, ok := t.previous.lookup(root); ok && previous.stat == stat {
+		members = t.previousMembers(root)
+	} else if listed, err := listArchive(fmt.Sprintf("%s%s%s", item.path, item.name, item.extension), root); err == nil {
+		members = listed
+	}
+
+	for _, member := range fs.allowedMembers(members, t.dirs) {
+		if t.dirs.maxEntries > 0 && t.indexed.Add(1) > t.dirs.maxEntries {
+			t.truncated(root, truncatedByMaxEntries)
+			return
+		}
+
+		t.results <- member
+	}
+
+	// a broken archive keeps its stat as well, so it's only listed again once it changes
+	t.keepStat(root, stat)
+}
+
+// archiveMembers returns the members of the archive item the Dirs allows, for the crawls of the watcher. An archive we can't read simply has none
+func (fs *Filesystem) archiveMembers(item basicFile, dirs *Dirs) []basicFile {
+	members, err := listArchive(fmt.Sprintf("%s%s%s", item.path, item.name, item.extension), item.archiveRoot())
+	if err != nil {
+		return nil
+	}
+
+	return fs.allowedMembers(members, dirs)
+}
+
+// allowedMembers drops the members, that the file rules of the fs and the Dirs wouldn't allow outside of an archive either. The folders stay, as the members are in them
+func (fs *Filesystem) allowedMembers(members []basicFile, dirs *Dirs) []basicFile {
+	return slices.DeleteFunc(members, func(member basicFile) bool {
+		return !member.isFolder && !fs.allowedFile(member, dirs)
+	})
+}
+
+// previousMembers returns the entries of the virtual folder from the last update, together with everything in the folders inside of it
+func (t *traversal) previousMembers(dirPath string) []basicFile {
+	members := []basicFile{}
+
+	previous, ok := t.previous.lookup(dirPath)
+	if !ok {
+		return members
+	}
+
+	for _, item := range previous.entries {
+		members = append(members, item)
+
+		if item.isFolder {
+			members = append(members, t.previousMembers(item.path)...)
+		}
+	}
+
+	return members
+}
+
+// archiveEvents returns the events, that replace the members of the archive item in a Dirs with the ones it has now. An archive that was removed, or whose members we don't index anymore, only gets its members removed
+func (fs *Filesystem) archiveEvents(item basicFile, dirs *Dirs, removed bool) []fsEvent {
+	if fs.maxArchiveSize <= 0 || item.isFolder || archiveFormat(item.name+item.extension) == noArchive {
+		return nil
+	}
+
+	root := item.archiveRoot()
+	events := []fsEvent{{newBasicFile(parentDir(root), filepath.Base(root), true, Metadata{}), true}}
+
+	if removed || !fs.wantsArchive(item) {
+		return events
+	}
+
+	// an archive that's still being written might not be readable yet, the next write or update lists it again
+	for _, member := range fs.archiveMembers(item, dirs) {
+		events = append(events, fsEvent{member, false})
+	}
+
+	return events
+}
+
+// listArchive returns the members of the archive at archivePath, with root as the virtual folder they're in
+func listArchive(archivePath string, root string) ([]basicFile, error) {
+	members := memberList{folders: make(map[string]bool), found: []basicFile{}, root: root}
+
+	var err error
+
+	switch archiveFormat(filepath.Base(archivePath)) {
+	case zipArchive:
+		err = members.listZip(archivePath)
+	case tarArchive, tarGzipArchive:
+		err = members.listTar(archivePath)
+	default:
+		err = errors.New("file isn't an archive we can list")
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("listArchive: couldn't list %s:\n--> %w", archivePath, err)
+	}
+
+	return members.found, nil
+}
+
+// listZip adds the members of a zip archive, only its central directory at the end is read for this
+func (members *memberList) listZip(archivePath string) error {
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	for _, file := range reader.File {
+		if len(members.found) >= maxArchiveMembers {
+			break
+		}
+
+		fileInfo := file.FileInfo()
+		members.add(file.Name, fileInfo.IsDir(), Metadata{Size: int64(file.UncompressedSize64), ModTime: file.Modified.UnixNano(), Mode: uint32(fileInfo.Mode())})
+	}
+
+	return nil
+}
+
+// listTar adds the members of a tar archive, which may be gzipped. Unlike a zip it has no directory, so all of it has to be read
+func (members *memberList) listTar(archivePath string) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+
+	if archiveFormat(filepath.Base(archivePath)) == tarGzipArchive {
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gzipReader.Close()
+
+		reader = gzipReader
+	}
+
+	tarReader := tar.NewReader(reader)
+
+	for len(members.found) < maxArchiveMembers {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		// links and devices have no content of their own to find
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeDir {
+			continue
+		}
+
+		fileInfo := header.FileInfo()
+		members.add(header.Name, fileInfo.IsDir(), Metadata{Size: header.Size, ModTime: header.ModTime.UnixNano(), Mode: uint32(fileInfo.Mode())})
+	}
+
+	return nil
+}
+
+// add adds a member with its path inside of the archive, together with the folders it's in. Paths that would lead out of the archive are kept inside of it
+func (members *memberList) add(name string, isFolder bool, metadata Metadata) {
+	memberPath := strings.TrimPrefix(path.Clean("/"+name), "/")
+	if memberPath == "" {
+		return
+	}
+
+	dir, base := path.Split(memberPath)
+	parentDir := filepath.Join(members.root, filepath.FromSlash(dir)) + string(filepath.Separator)
+
+	if dir != "" {
+		members.addFolder(strings.TrimSuffix(dir, "/"), Metadata{Mode: uint32(os.ModeDir)})
+	}
+
+	if isFolder {
+		members.addFolder(memberPath, metadata)
+		return
+	}
+
+	members.found = append(members.found, newBasicFile(parentDir, base, false, metadata))
+}
+
+// addFolder adds a folder of the archive and the ones it's in, if they weren't added already
+func (members *memberList) addFolder(folderPath string, metadata Metadata) {
+	if members.folders[folderPath] {
+		return
+	}
+
+	members.folders[folderPath] = true
+
+	dir, base := path.Split(folderPath)
+	if dir != "" {
+		members.addFolder(strings.TrimSuffix(dir, "/"), Metadata{Mode: uint32(os.ModeDir)})
+	}
+
+	members.found = append(members.found, newBasicFile(filepath.Join(members.root, filepath.FromSlash(dir))+string(filepath.Separator), base, true, metadata))
+}
+
+// ExtractArchiveMember extracts the file at the virtual path into a new temporary folder and returns the path it was extracted to. The folder is left to the system to clean up, as whatever opens the file needs it afterwards
+func ExtractArchiveMember(filePath string) (extractedPath string, err error) {
+	archivePath, memberPath, ok := SplitArchivePath(filePath)
+	if !ok || memberPath == "" || strings.HasSuffix(memberPath, string(filepath.Separator)) {
+		return "", fmt.Errorf("ExtractArchiveMember: %s isn't a file inside of an archive", filePath)
+	}
+
+	tempDir, err := os.MkdirTemp("", "bolt-archive-")
+	if err != nil {
+		return "", fmt.Errorf("ExtractArchiveMember: couldn't create a temporary folder:\n--> %w", err)
+	}
+
+	// without the file in it, the folder is of no use to anyone
+	defer func() {
+		if err != nil {
+			os.RemoveAll(tempDir)
+		}
+	}()
+
+	outputPath := filepath.Join(tempDir, filepath.Base(memberPath))
+
+	output, err := os.Create(outputPath)
+	if err != nil {
+		return "", fmt.Errorf("ExtractArchiveMember: couldn't create %s:\n--> %w", outputPath, err)
+	}
+	defer output.Close()
+
+	memberPath = filepath.ToSlash(memberPath)
+
+	switch archiveFormat(filepath.Base(archivePath)) {
+	case zipArchive:
+		err = extractZip(archivePath, memberPath, output)
+	default:
+		err = extractTar(archivePath, memberPath, output)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("ExtractArchiveMember: couldn't extract %s from %s:\n--> %w", memberPath, archivePath, err)
+	}
+
+	return outputPath, nil
+}
+
+// extractZip copies the member of the zip archive to the output
+func extractZip(archivePath string, memberPath string, output io.Writer) error {
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	for _, file := range reader.File {
+		if strings.TrimPrefix(path.Clean("/"+file.Name), "/") != memberPath || file.FileInfo().IsDir() {
+			continue
+		}
+
+		content, err := file.Open()
+		if err != nil {
+			return err
+		}
+		defer content.Close()
+
+		_, err = io.Copy(output, content)
+
+		return err
+	}
+
+	return os.ErrNotExist
+}
+
+// extractTar copies the member of the tar archive, which may be gzipped, to the output
+func extractTar(archivePath string, memberPath string, output io.Writer) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+
+	if archiveFormat(filepath.Base(archivePath)) == tarGzipArchive {
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gzipReader.Close()
+
+		reader = gzipReader
+	}
+
+	tarReader := tar.NewReader(reader)
+
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			return os.ErrNotExist
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if header.Typeflag == tar.TypeReg && strings.TrimPrefix(path.Clean("/"+header.Name), "/") == memberPath {
+			_, err = io.Copy(output, tarReader)
+			return err
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// Package cache handles everything that has to do with the generation of the cache for the Search function, to the generation of our folder structure and importing of the config.
+package cache
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/skillptm/Bolt/internal/config"
+)
+
+// testZip writes a zip with the members, which map their names to their content
+func testZip(t *testing.T, zipPath string, members map[string]string) {
+	t.Helper()
+
+	file, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	writer := zip.NewWriter(file)
+
+	for name, content := range members {
+		member, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := member.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestArchiveMembersFollowFileRules indexes a zip with a member, that the file rules keep out. Neither the update nor the watcher may add it
+func TestArchiveMembersFollowFileRules(t *testing.T) {
+	baseDir := t.TempDir() + string(filepath.Separator)
+	testZip(t, filepath.Join(baseDir, "backup.zip"), map[string]string{"docs/report.pdf": "report", "debug.log": "log"})
+
+	conf := testConfig(t, baseDir, 1)
+	conf.ArchiveIndex = config.ArchiveIndex{Enabled: true, MaxSize: 1 << 20}
+	conf.ExcludeDirs.Files.Extension = []string{"log"}
+	fs, dirs := newTestFilesystem(t, conf)
+	fs.Update(dirs, false)
+
+	if counts := countIndex(t, dirs); counts[".pdf"] != 1 || counts[".log"] != 0 {
+		t.Fatalf("expected only the pdf member, got %v", counts)
+	}
+
+	// the watcher lists the archive again, once it's written to
+	testZip(t, filepath.Join(baseDir, "backup.zip"), map[string]string{"docs/report.pdf": "report", "notes.pdf": "notes", "debug.log": "log", "trace.log": "trace"})
+
+	eventually(t, "the watcher didn't list the changed archive", func() bool {
+		return countIndex(t, dirs)[".pdf"] == 2
+	})
+
+	if counts := countIndex(t, dirs); counts[".log"] != 0 {
+		t.Fatalf("the watcher added %d log members", counts[".log"])
+	}
+}
+
+// TestExtractArchiveMemberCleansUp extracts members, that can't be extracted. None of them may leave its temporary folder behind
+func TestExtractArchiveMemberCleansUp(t *testing.T) {
+	tempDir := t.TempDir()
+	t.Setenv("TMPDIR", tempDir)
+
+	archiveDir := t.TempDir()
+	zipPath := filepath.Join(archiveDir, "backup.zip")
+	testZip(t, zipPath, map[string]string{"docs/report.pdf": "report"})
+
+	extractedPath, err := ExtractArchiveMember(zipPath + archiveSeparator + "/docs/report.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if content, err := os.ReadFile(extractedPath); err != nil || string(content) != "report" {
+		t.Fatalf("expected the content of the member, got %q %v", content, err)
+	}
+
+	os.RemoveAll(filepath.Dir(extractedPath))
+
+	for _, filePath := range []string{zipPath + archiveSeparator + "/docs/missing.pdf", filepath.Join(archiveDir, "missing.zip") + archiveSeparator + "/report.pdf"} {
+		if _, err := ExtractArchiveMember(filePath); err == nil {
+			t.Fatalf("extracted %s, which doesn't exist", filePath)
+		}
+	}
+
+	if left, _ := os.ReadDir(tempDir); len(left) != 0 {
+		t.Fatalf("the failed extractions left %d temporary folders behind", len(left))
+	}
+}
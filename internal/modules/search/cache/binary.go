@@ -0,0 +1,546 @@
+// Package cache handles everything that has to do with the generation of the cache for the Search function, to the generation of our folder structure and importing of the config.
+package cache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/skillptm/Bolt/internal/util"
+)
+
+/*
+The binary cache file is laid out as follows, all numbers are little endian:
+
+header: magic "BOLT" | version uint16 | flags uint16 | checksum uint32 (crc32c of the body) | body length uint64
+
+body:
+
+extensions: count uint32 | count * string
+names:      count uint32 | count * string (the names of the files and the folder names of the paths)
+paths:      count uint32 | count * (parent int32 | name index uint32), the pathKey is the position of the record
+stats:      count uint32 | count * (pathKey uint32 | modTime int64 | inode uint64 | rules uint64)
+files:      count uint32 | count * (extension index uint32 | name index uint32 | pathKey uint32 | encodedName [8]byte | size int64 | modTime int64 | mode uint32 | inode uint64 | device uint64)
+
+strings are stored as a uvarint length followed by the bytes.
+*/
+const (
+	cacheMagic      string = "BOLT"
+	cacheVersion    uint16 = 6
+	cacheHeaderSize int    = 20
+	pathRecordSize  int    = 8
+	statRecordSize  int    = 28
+	fileRecordSize  int    = 56
+)
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// cacheData is the part of a Dirs that gets stored on the disk
+type cacheData struct {
+	dirMap map[string]map[int][]File
+	paths  *PathTree
+	stats  map[int]DirStat
+}
+
+// legacyCache is the JSON cache, that was used before the binary format
+type legacyCache struct {
+	DirMap map[string]map[int][]File `json:"d"`
+	Paths  map[int]string            `json:"p"`
+	Stats  map[int]DirStat           `json:"s"`
+}
+
+// cacheWriter wraps around the buffered body of the cache file and keeps the first error, so we don't have to check after every write
+type cacheWriter struct {
+	buffer  [binary.MaxVarintLen64]byte
+	err     error
+	written uint64
+	writer  *bufio.Writer
+}
+
+// cacheReader wraps around the buffered body of the cache file and keeps the first error, so we don't have to check after every read
+type cacheReader struct {
+	buffer    [binary.MaxVarintLen64]byte
+	err       error
+	reader    *bufio.Reader
+	remaining uint64
+}
+
+// save writes the data as the cache file of the store. Writers are serialized per store and a write that got overtaken by a newer generation is dropped, so an old crawl can never overwrite a newer one
+func (store *memoryStore) save(data cacheData, generation uint64) error {
+	store.writeMu.Lock()
+	defer store.writeMu.Unlock()
+
+	if generation < store.savedGeneration {
+		return nil
+	}
+
+	err := writeCache(store.cachePath, data)
+	if err != nil {
+		return fmt.Errorf("save: couldn't write cache:\n--> %w", err)
+	}
+
+	store.savedGeneration = generation
+
+	return nil
+}
+
+// migrateJSONCache converts the JSON cache, that was used before the binary format, once and removes it afterwards
+func migrateJSONCache(cachePath string) error {
+	jsonPath := fmt.Sprintf("%s.json", strings.TrimSuffix(cachePath, ".bin"))
+
+	if _, err := os.Stat(jsonPath); err != nil {
+		return nil
+	}
+
+	if _, err := os.Stat(cachePath); err == nil {
+		return os.Remove(jsonPath)
+	}
+
+	legacy := legacyCache{}
+
+	err := util.GetJSON(jsonPath, &legacy)
+	if err != nil {
+		return fmt.Errorf("migrateJSONCache: couldn't read JSON cache:\n--> %w", err)
+	}
+
+	// the PathTree hands out its own keys, so the files and stats have to point to those instead
+	tree, newKeys := pathTreeFromPaths(legacy.Paths)
+
+	for _, lengths := range legacy.DirMap {
+		for _, files := range lengths {
+			for index := range files {
+				files[index].PathKey = newKeys[files[index].PathKey]
+			}
+		}
+	}
+
+	stats := make(map[int]DirStat, len(legacy.Stats))
+	for key, stat := range legacy.Stats {
+		if newKey, ok := newKeys[key]; ok {
+			stats[newKey] = stat
+		}
+	}
+
+	err = writeCache(cachePath, cacheData{legacy.DirMap, tree, stats})
+	if err != nil {
+		return fmt.Errorf("migrateJSONCache: couldn't write binary cache:\n--> %w", err)
+	}
+
+	return os.Remove(jsonPath)
+}
+
+// writeCache writes the data in the binary cache format to cachePath
+func writeCache(cachePath string, data cacheData) error {
+	return writeBinary(cachePath, cacheMagic, cacheVersion, func(cw *cacheWriter) {
+		cw.writeData(data)
+	})
+}
+
+// writeBinary writes a body with write to a temp file next to filePath and then renames it over filePath, so a crash can never leave a half written file behind
+func writeBinary(filePath string, magic string, version uint16, write func(cw *cacheWriter)) error {
+	tempFile, err := os.CreateTemp(filepath.Dir(filePath), fmt.Sprintf("%s.*.tmp", filepath.Base(filePath)))
+	if err != nil {
+		return fmt.Errorf("writeBinary: couldn't create temp file for %s:\n--> %w", filePath, err)
+	}
+
+	// after the rename this fails, which is fine, before it, it cleans up after us
+	defer os.Remove(tempFile.Name())
+
+	err = writeBinaryFile(tempFile, magic, version, write)
+	if closeErr := tempFile.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("writeBinary: couldn't close temp file %s:\n--> %w", tempFile.Name(), closeErr)
+	}
+
+	if err != nil {
+		return fmt.Errorf("writeBinary: couldn't write temp file %s:\n--> %w", tempFile.Name(), err)
+	}
+
+	err = os.Rename(tempFile.Name(), filePath)
+	if err != nil {
+		return fmt.Errorf("writeBinary: couldn't replace file %s:\n--> %w", filePath, err)
+	}
+
+	// syncing the folder makes sure the rename itself survives a crash
+	if cacheDir, err := os.Open(filepath.Dir(filePath)); err == nil {
+		cacheDir.Sync()
+		cacheDir.Close()
+	}
+
+	return nil
+}
+
+// writeBinaryFile writes the header and the body from write into cacheFile and syncs it to the disk
+func writeBinaryFile(cacheFile *os.File, magic string, version uint16, write func(cw *cacheWriter)) error {
+	// the header can only be written once we know the checksum, so the body starts right after its space
+	_, err := cacheFile.Seek(int64(cacheHeaderSize), io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("writeBinaryFile: couldn't seek past header:\n--> %w", err)
+	}
+
+	checksum := crc32.New(castagnoliTable)
+	cw := cacheWriter{writer: bufio.NewWriter(io.MultiWriter(cacheFile, checksum))}
+
+	write(&cw)
+
+	if cw.err == nil {
+		cw.err = cw.writer.Flush()
+	}
+
+	if cw.err != nil {
+		return fmt.Errorf("writeBinaryFile: couldn't write body:\n--> %w", cw.err)
+	}
+
+	_, err = cacheFile.WriteAt(newHeader(magic, version, checksum, cw.written), 0)
+	if err != nil {
+		return fmt.Errorf("writeBinaryFile: couldn't write header:\n--> %w", err)
+	}
+
+	err = cacheFile.Sync()
+	if err != nil {
+		return fmt.Errorf("writeBinaryFile: couldn't sync file:\n--> %w", err)
+	}
+
+	return nil
+}
+
+// newHeader returns the encoded header for a body with the provided checksum and length
+func newHeader(magic string, version uint16, checksum hash.Hash32, bodyLength uint64) []byte {
+	header := make([]byte, cacheHeaderSize)
+
+	copy(header[0:4], magic)
+	binary.LittleEndian.PutUint16(header[4:6], version)
+	binary.LittleEndian.PutUint16(header[6:8], 0)
+	binary.LittleEndian.PutUint32(header[8:12], checksum.Sum32())
+	binary.LittleEndian.PutUint64(header[12:20], bodyLength)
+
+	return header
+}
+
+// writeData builds the string tables and writes all sections of the body
+func (cw *cacheWriter) writeData(data cacheData) {
+	extensions := []string{}
+	names := []string{}
+	nameIndexes := make(map[string]uint32)
+	fileCount := 0
+
+	addName := func(name string) {
+		if _, ok := nameIndexes[name]; !ok {
+			nameIndexes[name] = uint32(len(names))
+			names = append(names, name)
+		}
+	}
+
+	for extension, lengths := range data.dirMap {
+		extensions = append(extensions, extension)
+
+		for _, files := range lengths {
+			for _, file := range files {
+				addName(file.Name)
+				fileCount++
+			}
+		}
+	}
+
+	// most folder names are already in there from the folders in the dirMap
+	nodes := data.paths.nodes
+	for _, node := range nodes {
+		addName(node.name)
+	}
+
+	cw.uint32(uint32(len(extensions)))
+	for _, extension := range extensions {
+		cw.string(extension)
+	}
+
+	cw.uint32(uint32(len(names)))
+	for _, name := range names {
+		cw.string(name)
+	}
+
+	cw.uint32(uint32(len(nodes)))
+	for _, node := range nodes {
+		cw.uint32(uint32(node.parent))
+		cw.uint32(nameIndexes[node.name])
+	}
+
+	cw.uint32(uint32(len(data.stats)))
+	for key, stat := range data.stats {
+		cw.uint32(uint32(key))
+		cw.uint64(uint64(stat.ModTime))
+		cw.uint64(stat.Inode)
+		cw.uint64(stat.Rules)
+	}
+
+	cw.uint32(uint32(fileCount))
+	for extensionIndex, extension := range extensions {
+		for _, files := range data.dirMap[extension] {
+			for _, file := range files {
+				cw.uint32(uint32(extensionIndex))
+				cw.uint32(nameIndexes[file.Name])
+				cw.uint32(uint32(file.PathKey))
+				cw.bytes(file.EncodedName[:])
+				cw.uint64(uint64(file.Metadata.Size))
+				cw.uint64(uint64(file.Metadata.ModTime))
+				cw.uint32(file.Metadata.Mode)
+				cw.uint64(file.Metadata.Inode)
+				cw.uint64(file.Metadata.Device)
+			}
+		}
+	}
+}
+
+// bytes writes the raw input to the body
+func (cw *cacheWriter) bytes(input []byte) {
+	if cw.err != nil {
+		return
+	}
+
+	n, err := cw.writer.Write(input)
+	cw.written += uint64(n)
+	cw.err = err
+}
+
+// uint32 writes a little endian uint32 to the body
+func (cw *cacheWriter) uint32(input uint32) {
+	binary.LittleEndian.PutUint32(cw.buffer[:4], input)
+	cw.bytes(cw.buffer[:4])
+}
+
+// uint64 writes a little endian uint64 to the body
+func (cw *cacheWriter) uint64(input uint64) {
+	binary.LittleEndian.PutUint64(cw.buffer[:8], input)
+	cw.bytes(cw.buffer[:8])
+}
+
+// string writes the length of the input as a uvarint followed by the input itself to the body
+func (cw *cacheWriter) string(input string) {
+	n := binary.PutUvarint(cw.buffer[:], uint64(len(input)))
+	cw.bytes(cw.buffer[:n])
+
+	if cw.err != nil {
+		return
+	}
+
+	n, cw.err = cw.writer.WriteString(input)
+	cw.written += uint64(n)
+}
+
+// readCache reads a binary cache file
+func readCache(cachePath string) (*cacheData, error) {
+	var data *cacheData
+
+	err := readBinary(cachePath, cacheMagic, cacheVersion, func(cr *cacheReader) {
+		data = cr.readData()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("readCache: couldn't read cache file:\n--> %w", err)
+	}
+
+	return data, nil
+}
+
+// readBinary reads the body of a binary file with read and verifies its header and checksum
+func readBinary(filePath string, magic string, version uint16, read func(cr *cacheReader)) error {
+	cacheFile, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("readBinary: couldn't open file %s:\n--> %w", filePath, err)
+	}
+	defer cacheFile.Close()
+
+	header := make([]byte, cacheHeaderSize)
+
+	_, err = io.ReadFull(cacheFile, header)
+	if err != nil {
+		return fmt.Errorf("readBinary: couldn't read header:\n--> %w", err)
+	}
+
+	if string(header[0:4]) != magic {
+		return errors.New("readBinary: file has an unknown format")
+	}
+
+	if fileVersion := binary.LittleEndian.Uint16(header[4:6]); fileVersion != version {
+		return fmt.Errorf("readBinary: file has the unsupported version %d", fileVersion)
+	}
+
+	checksum := crc32.New(castagnoliTable)
+	cr := cacheReader{
+		reader:    bufio.NewReader(io.TeeReader(cacheFile, checksum)),
+		remaining: binary.LittleEndian.Uint64(header[12:20]),
+	}
+
+	read(&cr)
+	if cr.err != nil {
+		return fmt.Errorf("readBinary: couldn't read body:\n--> %w", cr.err)
+	}
+
+	// anything still in the file after the body means it wasn't written by us
+	if _, err := cr.reader.ReadByte(); err != io.EOF {
+		return errors.New("readBinary: file is longer than its header says")
+	}
+
+	if checksum.Sum32() != binary.LittleEndian.Uint32(header[8:12]) {
+		return errors.New("readBinary: file doesn't match its checksum")
+	}
+
+	return nil
+}
+
+// readData reads all sections of the body, in the same order writeData wrote them
+func (cr *cacheReader) readData() *cacheData {
+	data := cacheData{
+		dirMap: make(map[string]map[int][]File),
+		paths:  newPathTree(),
+		stats:  make(map[int]DirStat),
+	}
+
+	extensionCount := cr.count(1)
+	extensions := make([]string, 0, extensionCount)
+	for range extensionCount {
+		extensions = append(extensions, cr.string())
+	}
+
+	nameCount := cr.count(1)
+	names := make([]string, 0, nameCount)
+	for range nameCount {
+		names = append(names, cr.string())
+	}
+
+	nodeCount := cr.count(pathRecordSize)
+	data.paths.nodes = make([]pathNode, 0, nodeCount)
+	for range nodeCount {
+		parent, nameIndex := int32(cr.uint32()), cr.uint32()
+
+		if cr.err != nil {
+			break
+		}
+
+		if int(nameIndex) >= len(names) {
+			cr.err = errors.New("path record points outside of the string tables")
+			break
+		}
+
+		data.paths.nodes = append(data.paths.nodes, pathNode{names[nameIndex], parent})
+	}
+
+	if cr.err == nil {
+		cr.err = data.paths.validate()
+	}
+
+	for range cr.count(statRecordSize) {
+		key := int(cr.uint32())
+		data.stats[key] = DirStat{int64(cr.uint64()), cr.uint64(), cr.uint64()}
+	}
+
+	encodedName := [8]byte{}
+
+	for range cr.count(fileRecordSize) {
+		extensionIndex, nameIndex, pathKey := cr.uint32(), cr.uint32(), int(cr.uint32())
+		cr.bytes(encodedName[:])
+		metadata := Metadata{int64(cr.uint64()), int64(cr.uint64()), cr.uint32(), cr.uint64(), cr.uint64()}
+
+		if cr.err != nil {
+			break
+		}
+
+		if int(extensionIndex) >= len(extensions) || int(nameIndex) >= len(names) {
+			cr.err = errors.New("file record points outside of the string tables")
+			break
+		}
+
+		// a file in a folder we don't know would show up without a path
+		if pathKey >= len(data.paths.nodes) || data.paths.nodes[pathKey].parent == removedNode {
+			cr.err = errors.New("file record points to a folder that isn't in the path tree")
+			break
+		}
+
+		extension, name := extensions[extensionIndex], names[nameIndex]
+
+		if _, ok := data.dirMap[extension]; !ok {
+			data.dirMap[extension] = make(map[int][]File)
+		}
+
+		data.dirMap[extension][len(name)] = append(data.dirMap[extension][len(name)], File{encodedName, name, pathKey, metadata})
+	}
+
+	return &data
+}
+
+// count reads the amount of records in a section. It's limited by how many records of recordSize could still fit in the body, so a broken count can't make us allocate everything
+func (cr *cacheReader) count(recordSize int) int {
+	count := uint64(cr.uint32())
+
+	if cr.err == nil && count*uint64(recordSize) > cr.remaining {
+		cr.err = errors.New("section count is larger than the rest of the body")
+	}
+
+	if cr.err != nil {
+		return 0
+	}
+
+	return int(count)
+}
+
+// bytes fills output with the next bytes of the body
+func (cr *cacheReader) bytes(output []byte) {
+	if cr.err != nil {
+		return
+	}
+
+	if uint64(len(output)) > cr.remaining {
+		cr.err = io.ErrUnexpectedEOF
+		return
+	}
+
+	_, cr.err = io.ReadFull(cr.reader, output)
+	cr.remaining -= uint64(len(output))
+}
+
+// uint32 reads a little endian uint32 from the body
+func (cr *cacheReader) uint32() uint32 {
+	cr.bytes(cr.buffer[:4])
+	return binary.LittleEndian.Uint32(cr.buffer[:4])
+}
+
+// uint64 reads a little endian uint64 from the body
+func (cr *cacheReader) uint64() uint64 {
+	cr.bytes(cr.buffer[:8])
+	return binary.LittleEndian.Uint64(cr.buffer[:8])
+}
+
+// string reads a uvarint length followed by that many bytes from the body
+func (cr *cacheReader) string() string {
+	if cr.err != nil {
+		return ""
+	}
+
+	length, err := binary.ReadUvarint(cr.reader)
+	if err != nil {
+		cr.err = err
+		return ""
+	}
+
+	cr.remaining -= min(uint64(uvarintSize(length)), cr.remaining)
+
+	if length > cr.remaining {
+		cr.err = io.ErrUnexpectedEOF
+		return ""
+	}
+
+	output := make([]byte, length)
+	cr.bytes(output)
+
+	return string(output)
+}
+
+// uvarintSize returns how many bytes the uvarint encoding of input takes up
+func uvarintSize(input uint64) int {
+	buffer := [binary.MaxVarintLen64]byte{}
+	return binary.PutUvarint(buffer[:], input)
+}
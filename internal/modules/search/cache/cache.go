@@ -2,60 +2,134 @@
 package cache
 
 import (
+	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
 	"runtime"
 	"runtime/debug"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/skillptm/Bolt/internal/config"
 	"github.com/skillptm/Bolt/internal/util"
 )
 
-// Filesystem stores some metadata for our searches, aswell as the cache of files on the system
-type Filesystem struct {
-	DefaultDirs  Dirs
-	ExtendedDirs Dirs
+const (
+	pathQueueSize int = 4096 // folders waiting for any worker to pick them up, the rest waits on the stack of the worker that found them
+	resultsSize   int = 4096 // files and folders waiting to be added to the DirMap
+)
 
-	excludedDirs           dirsRules
-	excludeFromDefaultDirs dirsRules
-	maxCPUThreads          int
+// Filesystem stores some metadata for our searches, aswell as the cache of files on the system, split into the scopes from the config
+type Filesystem struct {
+	IgnoreDiacritics bool
+	Scopes           []*Dirs
+
+	conf           *config.Config // for the Dirs of the volumes, which come and go while Bolt runs
+	excludedDirs   dirsRules
+	hashesPath     string
+	ignoreFiles    []string
+	maxArchiveSize int64 // 0 if the members of archives aren't indexed
+	maxCPUThreads  int
+	mountRules     mountRules
+	mounts         atomic.Pointer[mountTable]
+	resyncChan     chan *Dirs
+	stopChan       chan bool
+	stopOnce       sync.Once
+	throttle       *throttle // only used by the background updates
+	trash          map[string]*trashSnapshot
+	trashMu        sync.Mutex
+	volumes        []*Volume
+	volumesMu      sync.Mutex
+	volumesPath    string
 }
 
 /*
-Dirs store the DirMap, which is structuered for us the be able to search through as fast as possible.
-They also store Paths, which is a map with which you can access the path to the file. This exists to save memory, for not having to store the same path several times with the file directly.
-
-paths: map[unique ID]Absolute Path
-
-dirMap: map[File Extension]map[File Length][]File{encodedName, Name, pathKey}
+Dirs store the index of a scope in their indexStore, which is structuered for us the be able to search through as fast as possible.
+Searches read it through an Index, that doesn't change while they use it, so they don't need any locks.
 */
 type Dirs struct {
-	BaseDirs  map[string]bool           `json:"-"`
-	CachePath string                    `json:"-"`
-	DirMap    map[string]map[int][]File `json:"d"`
-	Imported  bool                      `json:"-"`
-	Mu        sync.Mutex                `json:"-"`
-	Paths     map[int]string            `json:"p"`
+	BaseDirs  map[string]bool `json:"-"`
+	CachePath string          `json:"-"`
+	Default   bool            `json:"-"`
+	Name      string          `json:"-"`
+
+	baseDirsMu       sync.Mutex
+	content          atomic.Pointer[contentIndex]
+	contentPath      string
+	contentRules     *contentRules
+	docsMu           sync.Mutex // guards pendingDocs, docsStopped and docsTimer
+	docsStopped      bool
+	docsTimer        *time.Timer // set while the pendingDocs wait for refreshDocs
+	eventsMu         sync.Mutex
+	excludedDirs     dirsRules
+	ignoreDiacritics bool
+	indexStats       IndexStats
+	indexStatsMu     sync.Mutex
+	indexStatsTimer  *time.Timer // set while the counts of the watcher wait to be saved
+	journal          *journal
+	maxDepth         int
+	maxDirEntries    int
+	maxEntries       int64
+	mu               sync.Mutex // serializes the changes to the store
+	passExcludedTo   *Dirs
+	pending          []fsEvent
+	pendingDocs      map[string]pendingDoc // full path -> file, whose contents or tags changed since the last update
+	ready            chan struct{}         // closed once the cache is imported, only replaced while holding both the mu and the readyMu
+	readyMu          sync.Mutex
+	resyncQueued     atomic.Bool
+	rulesFingerprint uint64
+	statsPath        string
+	stayOnFilesystem bool
+	store            indexStore
+	tagDirs          []string
+	tags             atomic.Pointer[tagIndex]
+	tagsPath         string
+	updateMu         sync.Mutex // the automatic and forced updates of a Dirs run one after another
+	updateTime       time.Duration
+	volume           *Volume // nil for the scopes of the config
+	watcher          *watcher
 }
 
 // File stores all the data we need for a fast retrival later on
 type File struct {
-	EncodedName [8]byte `json:"e"`
-	Name        string  `json:"n"`
-	PathKey     int     `json:"p"`
+	EncodedName [8]byte  `json:"e"`
+	Name        string   `json:"n"`
+	PathKey     int      `json:"p"`
+	Metadata    Metadata `json:"m"`
 }
 
-// dirsRules holds name, path and regex rules determining the part of the cache a folder will be in
+// Metadata holds what we know about a file from the time it was indexed, so searches don't have to stat all their results
+type Metadata struct {
+	Size    int64  `json:"s"`
+	ModTime int64  `json:"t"` // in nanoseconds since the unix epoch
+	Mode    uint32 `json:"o"`
+	Inode   uint64 `json:"i"`
+	Device  uint64 `json:"d"` // the inode is only unique on this device
+}
+
+// dirsRules holds name, path, regex and glob rules determining the part of the cache a folder will be in, aswell as the fileRules for the files inside of it
 type dirsRules struct {
+	files fileRules
+	globs []string
 	name  map[string]bool
 	path  map[string]bool
-	regex []string
+	regex []*regexp.Regexp
+}
+
+// fileRules holds the extension, name glob and size rules, that keep a file out of the cache
+type fileRules struct {
+	extensions map[string]bool
+	maxSize    int64
+	minSize    int64
+	names      []string
 }
 
 // basicFile is a temp struct we use to not have to re-gather file data between different actions
@@ -64,79 +138,363 @@ type basicFile struct {
 	isFolder  bool
 	name      string
 	path      string
+	metadata  Metadata
 }
 
-// NewFilesystem returns a pointer to a Filesystem struct that has been filled up according to the includedDirs, excludedDirs and config
+// traversal holds everything the traverse workers share during a single Update
+type traversal struct {
+	baseDirs        []string
+	changes         []changedFile // what changed in the folders, that were read again
+	dirs            *Dirs
+	ignores         map[string]*ignoreList
+	ignoresMu       sync.Mutex
+	indexed         atomic.Int64 // how many entries were sent to the results, for the MaxEntries of the Dirs
+	mounts          mountTable
+	onDemand        bool
+	pathQueue       chan string
+	previous        previousIndex
+	results         chan basicFile
+	stats           map[string]DirStat
+	statsMu         sync.Mutex
+	throttle        *throttle
+	truncatedCount  int
+	truncatedDirs   []TruncatedDir
+	unreadableCount int
+	unreadableDirs  []string
+	wg              sync.WaitGroup
+}
+
+// fsEvent is a single change to a Dirs, that was reported by its watcher
+type fsEvent struct {
+	file    basicFile
+	removed bool
+}
+
+// NewFilesystem returns a pointer to a Filesystem struct that has been filled up according to the scopes and excludedDirs in the config
 func NewFilesystem(conf *config.Config) (*Filesystem, error) {
+	excludedDirs, err := newDirsRules(conf.ExcludeDirs)
+	if err != nil {
+		return nil, fmt.Errorf("NewFilesystem: couldn't compile ExcludeDirs:\n--> %w", err)
+	}
+
 	fs := Filesystem{
-		DefaultDirs: Dirs{
-			CachePath: conf.Paths["default_cache.json"],
-			BaseDirs:  util.MakeBoolMap(conf.DefaultDirs),
-			DirMap:    make(map[string]map[int][]File),
-			Paths:     make(map[int]string),
-		},
-		ExtendedDirs: Dirs{
-			CachePath: conf.Paths["extended_cache.json"],
-			BaseDirs:  util.MakeBoolMap(conf.ExtendedDirs),
-			DirMap:    make(map[string]map[int][]File),
-			Paths:     make(map[int]string),
-		},
-		excludedDirs: dirsRules{
-			util.MakeBoolMap(conf.ExcludeDirs.Name),
-			util.MakeBoolMap(conf.ExcludeDirs.Path),
-			conf.ExcludeDirs.Regex,
-		},
-		excludeFromDefaultDirs: dirsRules{
-			util.MakeBoolMap(conf.ExcludeFromDefaultDirs.Name),
-			util.MakeBoolMap(conf.ExcludeFromDefaultDirs.Path),
-			conf.ExcludeFromDefaultDirs.Regex,
-		},
-		maxCPUThreads: conf.MaxCPUThreads,
+		conf:             conf,
+		excludedDirs:     excludedDirs,
+		hashesPath:       hashesPath(conf.Paths["cache"]),
+		IgnoreDiacritics: conf.IgnoreDiacritics,
+		ignoreFiles:      conf.IgnoreFiles,
+		maxArchiveSize:   maxArchiveSize(conf.ArchiveIndex),
+		maxCPUThreads:    conf.MaxCPUThreads,
+		mountRules:       newMountRules(conf.FilesystemTypes, conf.RemovableMedia),
+		resyncChan:       make(chan *Dirs, len(conf.Scopes)+maxVolumes),
+		stopChan:         make(chan bool),
+		volumesPath:      volumesPath(conf.Paths["cache"]),
+	}
+
+	fs.throttle = newThrottle(conf.Throttle, fs.stopChan)
+
+	for _, scope := range conf.Scopes {
+		dirs, err := newDirs(conf, scope)
+		if err != nil {
+			return nil, fmt.Errorf("NewFilesystem: couldn't setup scope %s:\n--> %w", scope.Name, err)
+		}
+
+		fs.Scopes = append(fs.Scopes, dirs)
+	}
+
+	mounts := newMountTable(&fs.mountRules)
+	fs.mounts.Store(&mounts)
+
+	// the config already made sure every scope we pass to exists
+	for index, scope := range conf.Scopes {
+		fs.Scopes[index].passExcludedTo = fs.Scope(scope.PassExcludedTo)
 	}
 
-	fs.Update(&fs.DefaultDirs, &fs.ExtendedDirs)
-	fs.Update(&fs.ExtendedDirs, &fs.DefaultDirs)
+	for index, dirs := range fs.Scopes {
+		// the caches from before the binary format are converted, so the first update can already reuse them. If that fails they're simply rebuilt
+		migrateJSONCache(cachePath(conf.Paths["cache"], dirs.Name))
+
+		// the cache of the Storage the scope used before would only go stale
+		removeUnusedStore(conf.Paths["cache"], conf.Scopes[index])
 
-	go fs.autoUpdateCache(conf.DefaultDirsCacheUpdateTime, conf.ExtendedDirsCacheUpdateTime)
+		// a content or tag index from when it was enabled would only go stale
+		if dirs.contentRules == nil {
+			os.Remove(dirs.contentPath)
+		}
+
+		if dirs.tagDirs == nil {
+			os.Remove(dirs.tagsPath)
+		}
+
+		// if we can't get an inotify instance the watcher stays nil and we fall back to the periodic updates
+		dirs.watcher, _ = newWatcher(&fs, dirs)
+	}
+
+	for _, dirs := range fs.Scopes {
+		fs.Update(dirs, false)
+	}
+
+	for _, dirs := range fs.Scopes {
+		go dirs.watcher.run()
+		go fs.scheduleUpdates(dirs)
+	}
+
+	// the volumes we know are searchable right away, the ones that are plugged in are updated in the background
+	if len(fs.mountRules.volumeDirs) > 0 {
+		fs.loadVolumes()
+		fs.syncVolumes()
+
+		go fs.watchVolumes()
+	}
+
+	go fs.autoUpdateCache()
 
 	return &fs, nil
 }
 
-// Update launches the traversing of the dirs and later starts the adding of the results onto the fs
-func (fs *Filesystem) Update(dirs *Dirs, otherDirs *Dirs) {
+// newDirs returns the Dirs for a scope of the config, which still needs its watcher
+func newDirs(conf *config.Config, scope config.Scope) (*Dirs, error) {
+	scopeExcludedDirs, err := newDirsRules(scope.ExcludeDirs)
+	if err != nil {
+		return nil, fmt.Errorf("newDirs: couldn't compile ExcludeDirs of scope %s:\n--> %w", scope.Name, err)
+	}
+
+	return &Dirs{
+		BaseDirs:         util.MakeBoolMap(withSeparator(scope.Dirs)),
+		CachePath:        storePath(conf.Paths["cache"], scope),
+		Default:          scope.Default,
+		Name:             scope.Name,
+		contentPath:      contentPath(conf.Paths["cache"], scope.Name),
+		contentRules:     newContentRules(conf.ContentIndex),
+		excludedDirs:     scopeExcludedDirs,
+		ignoreDiacritics: conf.IgnoreDiacritics,
+		journal:          loadJournal(journalPath(conf.Paths["cache"], scope.Name)),
+		maxDepth:         scope.MaxDepth,
+		maxDirEntries:    scope.MaxDirEntries,
+		maxEntries:       int64(scope.MaxEntries),
+		ready:            make(chan struct{}),
+		rulesFingerprint: fingerprintRules(conf.ExcludeDirs, conf.IgnoreFiles, scope.ExcludeDirs, scope.StayOnFilesystem, scope.MaxDepth, scope.MaxDirEntries, scope.MaxEntries),
+		statsPath:        statsPath(conf.Paths["cache"], scope.Name),
+		stayOnFilesystem: scope.StayOnFilesystem,
+		store:            newIndexStore(conf, scope),
+		tagDirs:          newTagDirs(conf.TagIndex),
+		tagsPath:         tagsPath(conf.Paths["cache"], scope.Name),
+		updateTime:       time.Duration(scope.UpdateTime) * time.Second,
+	}, nil
+}
+
+// newDirsRules converts the Rules from the config into dirsRules. The regexes are compiled once here, instead of for every folder we check
+func newDirsRules(rules config.Rules) (dirsRules, error) {
+	regexes := []*regexp.Regexp{}
 
-	// 10000000 is the channel size, because we just need a ridiculously large channel to store all the paths until we traversed them
-	pathQueue := make(chan string, 10000000)
-	results := make(chan basicFile, 10000000)
-	wg := sync.WaitGroup{}
+	for _, pattern := range rules.Regex {
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return dirsRules{}, fmt.Errorf("newDirsRules: couldn't compile regex %s:\n--> %w", pattern, err)
+		}
 
-	for dir := range dirs.BaseDirs {
-		wg.Add(1)
-		pathQueue <- dir
+		regexes = append(regexes, regex)
 	}
 
-	for range fs.maxCPUThreads {
-		go fs.traverse(pathQueue, results, otherDirs, &wg)
+	// path.Match only reports a malformed pattern, so matching against nothing is enough to validate it
+	for _, glob := range slices.Concat(rules.Glob, rules.Files.Name) {
+		if _, err := path.Match(glob, ""); err != nil {
+			return dirsRules{}, fmt.Errorf("newDirsRules: invalid glob %s:\n--> %w", glob, err)
+		}
+	}
+
+	extensions := make(map[string]bool)
+	for _, extension := range rules.Files.Extension {
+		if !strings.HasPrefix(extension, ".") {
+			extension = "." + extension
+		}
+
+		extensions[strings.ToLower(extension)] = true
+	}
+
+	return dirsRules{
+		files: fileRules{
+			extensions: extensions,
+			maxSize:    rules.Files.MaxSize,
+			minSize:    rules.Files.MinSize,
+			names:      rules.Files.Name,
+		},
+		globs: rules.Glob,
+		name:  util.MakeBoolMap(rules.Name),
+		path:  util.MakeBoolMap(withSeparator(rules.Path)),
+		regex: regexes,
+	}, nil
+}
+
+// withSeparator returns the folder paths with a trailing separator, like all the folder paths in the cache, so "/home/me" and "/home/me/" are the same folder
+func withSeparator(dirPaths []string) []string {
+	output := make([]string, 0, len(dirPaths))
+
+	for _, dirPath := range dirPaths {
+		if !strings.HasSuffix(dirPath, string(filepath.Separator)) {
+			dirPath += string(filepath.Separator)
+		}
+
+		output = append(output, dirPath)
+	}
+
+	return output
+}
+
+// Scope returns the Dirs of the scope with the provided name, or nil if there is none
+func (fs *Filesystem) Scope(name string) *Dirs {
+	for _, dirs := range fs.Scopes {
+		if dirs.Name == name {
+			return dirs
+		}
+	}
+
+	return nil
+}
+
+// DefaultBaseDirs returns the BaseDirs of all default scopes
+func (fs *Filesystem) DefaultBaseDirs() map[string]bool {
+	baseDirs := make(map[string]bool)
+
+	for _, dirs := range fs.Scopes {
+		if !dirs.Default {
+			continue
+		}
+
+		dirs.baseDirsMu.Lock()
+		maps.Copy(baseDirs, dirs.BaseDirs)
+		dirs.baseDirsMu.Unlock()
+	}
+
+	return baseDirs
+}
+
+// Close stops the watchers and the automatic updates of the Filesystem and writes the journal changes and IndexStats, that are still waiting to be saved
+func (fs *Filesystem) Close() {
+	for _, dirs := range fs.Scopes {
+		dirs.watcher.close()
+		dirs.journal.flush()
+		dirs.flushIndexStats()
+		dirs.stopDocs()
+	}
+
+	for _, volume := range fs.Volumes() {
+		volume.dirs.watcher.close()
+		volume.dirs.journal.flush()
+		volume.dirs.flushIndexStats()
+		volume.dirs.stopDocs()
+	}
+
+	fs.stopOnce.Do(func() {
+		close(fs.stopChan)
+	})
+}
+
+// Update launches the traversing of the dirs and later starts the adding of the results onto the fs. Folders that didn't change since the last update reuse their cached entries.
+// Mounts that are only indexed on demand are crawled, if onDemand is set, otherwise they keep their cached entries as well
+func (fs *Filesystem) Update(dirs *Dirs, onDemand bool) {
+	fs.update(dirs, onDemand, nil)
+}
+
+// update is Update, with the workers slowed down by the throttle, if it isn't nil
+func (fs *Filesystem) update(dirs *Dirs, onDemand bool, throttle *throttle) {
+	dirs.updateMu.Lock()
+	defer dirs.updateMu.Unlock()
+
+	// an unplugged volume would look empty, so it keeps the index it had
+	if !dirs.volume.mounted() {
+		return
+	}
+
+	start := time.Now()
+
+	// the mounts are read again on every update, as drives and shares come and go
+	mounts := newMountTable(&fs.mountRules)
+	fs.mounts.Store(&mounts)
+
+	dirs.baseDirsMu.Lock()
+	baseDirs := slices.Collect(maps.Keys(dirs.BaseDirs))
+	dirs.baseDirsMu.Unlock()
+
+	t := traversal{
+		baseDirs:       baseDirs,
+		dirs:           dirs,
+		ignores:        make(map[string]*ignoreList),
+		mounts:         mounts,
+		onDemand:       onDemand,
+		pathQueue:      make(chan string, pathQueueSize),
+		previous:       dirs.store.snapshot(),
+		results:        make(chan basicFile, resultsSize),
+		stats:          make(map[string]DirStat),
+		throttle:       throttle,
+		truncatedDirs:  []TruncatedDir{},
+		unreadableDirs: []string{},
+	}
+
+	defer t.previous.close()
+
+	// everything the watcher reported so far will be part of this crawl, so we only have to keep what comes in from now on
+	dirs.eventsMu.Lock()
+	dirs.pending = nil
+	dirs.eventsMu.Unlock()
+
+	// the base dirs are counted before any worker starts, so the traversal can't be considered done before they're queued
+	t.wg.Add(len(baseDirs))
+
+	for range max(fs.maxCPUThreads, 1) {
+		go fs.traverse(&t)
 	}
 
 	go func() {
-		wg.Wait()
-		close(results)
-		close(pathQueue)
+		t.wg.Wait()
+		close(t.results)
+		close(t.pathQueue)
+	}()
+
+	// sent from here, as there might be more base dirs than fit into the pathQueue
+	go func() {
+		for _, dir := range baseDirs {
+			t.pathQueue <- dir
+		}
 	}()
 
-	dirs.add(results)
+	dirs.indexStatsMu.Lock()
+	lastUpdate := dirs.indexStats.LastUpdate
+	dirs.indexStatsMu.Unlock()
+
+	indexStats := IndexStats{Extensions: make(map[string]int)}
+	contentFiles, tagFiles := dirs.add(t.results, t.stats, &indexStats)
+
+	// a volume, that was unplugged during the crawl, wasn't published, so its changes are only made up
+	if !dirs.volume.mounted() {
+		return
+	}
+
+	dirs.journal.record(journalChanges(t.changes, lastUpdate, time.Now()), false)
+	dirs.updateContent(contentFiles)
+	dirs.updateTags(tagFiles)
+
+	// the workers are all done, once the results are, so we can read what they found without the statsMu
+	indexStats.CrawlMilliseconds = time.Since(start).Milliseconds()
+	indexStats.LastUpdate = time.Now()
+	indexStats.LastChange = indexStats.LastUpdate
+	indexStats.UnreadableCount = t.unreadableCount
+	indexStats.UnreadableDirs = t.unreadableDirs
+	indexStats.TruncatedCount = t.truncatedCount
+	indexStats.TruncatedDirs = t.truncatedDirs
+
+	dirs.setIndexStats(indexStats)
 }
 
-// check finds out if the provided Directory breaks any of the name, path or regex rules
-func (dr *dirsRules) check(dirPath string, add bool, dirs *Dirs) bool {
+// check finds out if the provided Directory breaks any of the name, path, regex or glob rules. If it does and passTo is set, the Directory becomes one of its BaseDirs instead
+func (dr *dirsRules) check(dirPath string, passTo *Dirs) bool {
 	addPath := func() {
-		if !add {
+		if passTo == nil {
 			return
 		}
-		dirs.Mu.Lock()
-		dirs.BaseDirs[dirPath] = true
-		dirs.Mu.Unlock()
+		passTo.baseDirsMu.Lock()
+		passTo.BaseDirs[dirPath] = true
+		passTo.baseDirsMu.Unlock()
 	}
 
 	if dr.path[dirPath] {
@@ -149,8 +507,20 @@ func (dr *dirsRules) check(dirPath string, add bool, dirs *Dirs) bool {
 		return false
 	}
 
-	for _, pattern := range dr.regex {
-		if matched, _ := regexp.MatchString(pattern, dirPath); matched {
+	for _, regex := range dr.regex {
+		if regex.MatchString(dirPath) {
+			addPath()
+			return false
+		}
+	}
+
+	for _, glob := range dr.globs {
+		target := path.Base(dirPath)
+		if strings.Contains(glob, "/") {
+			target = strings.TrimSuffix(dirPath, "/")
+		}
+
+		if matched, _ := path.Match(glob, target); matched {
 			addPath()
 			return false
 		}
@@ -159,123 +529,446 @@ func (dr *dirsRules) check(dirPath string, add bool, dirs *Dirs) bool {
 	return true
 }
 
-// autoUpdateCache automatically updates both the DefaultDirs and ExtendedDirs
-func (fs *Filesystem) autoUpdateCache(defaultTime int, extendedTime int) {
-	defaultTimer := time.NewTimer(time.Duration(defaultTime) * time.Second)
-	extendedTimer := time.NewTimer(time.Duration(extendedTime) * time.Second)
+// check finds out if the provided file breaks any of the extension, name or size rules
+func (fr *fileRules) check(item basicFile) bool {
+	if fr.extensions[strings.ToLower(item.extension)] {
+		return false
+	}
+
+	for _, glob := range fr.names {
+		if matched, _ := path.Match(glob, item.name+item.extension); matched {
+			return false
+		}
+	}
+
+	if fr.minSize > 0 && item.metadata.Size < fr.minSize {
+		return false
+	}
 
+	if fr.maxSize > 0 && item.metadata.Size > fr.maxSize {
+		return false
+	}
+
+	return true
+}
+
+// autoUpdateCache runs the updates the scopes ask for one after another, so they don't compete for the CPU threads we may use
+func (fs *Filesystem) autoUpdateCache() {
 	for {
 		select {
-		case <-defaultTimer.C:
-			fs.DefaultDirs.Mu.Lock()
-			fs.Update(&fs.DefaultDirs, &fs.ExtendedDirs)
-			fs.DefaultDirs.Mu.Unlock()
-			defaultTimer.Reset(time.Duration(defaultTime) * time.Second)
-		case <-extendedTimer.C:
-			fs.ExtendedDirs.Mu.Lock()
-			fs.Update(&fs.ExtendedDirs, &fs.DefaultDirs)
-			fs.ExtendedDirs.Mu.Unlock()
-			extendedTimer.Reset(time.Duration(extendedTime) * time.Second)
+		case dirs := <-fs.resyncChan:
+			// cleared before the update, so changes during it can schedule the next one
+			dirs.resyncQueued.Store(false)
+
+			fs.backgroundUpdate(dirs)
+		case <-fs.stopChan:
+			return
 		}
 	}
 }
 
-// traverse walks through and expands the pathQueue to store all files and folders it encounters in resultsChan unless it breaks with excludedDirs
-func (fs *Filesystem) traverse(pathQueue chan string, results chan<- basicFile, otherDirs *Dirs, wg *sync.WaitGroup) {
-	for currentDir := range pathQueue {
-		currentEntries, err := os.ReadDir(currentDir)
-		// an error here simply means we didn't have the permissions to read a dir, so we ignore it
-		if err != nil {
-			wg.Done()
-			continue
+// backgroundUpdate runs a throttled update of the Dirs and waits for it. The content and tag indexes are read on the goroutine of the update, so it gets a thread with the lower priority, just like the workers.
+// That thread is thrown away with the goroutine, so nothing else, like the next forced update, ever runs with its priority
+func (fs *Filesystem) backgroundUpdate(dirs *Dirs) {
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		fs.throttle.lowerPriority()
+		fs.update(dirs, false, fs.throttle)
+	}()
+
+	<-done
+}
+
+// scheduleUpdates asks for an update of the Dirs every updateTime. Dirs that are kept up to date by their watcher are only updated, when the watcher asks for a resync
+func (fs *Filesystem) scheduleUpdates(dirs *Dirs) {
+	ticker := time.NewTicker(dirs.updateTime)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !dirs.watcher.live() {
+				fs.requestResync(dirs)
+			}
+		case <-fs.stopChan:
+			return
+		}
+	}
+}
+
+// Rebuild schedules a full update of the provided Dirs, for when its cache couldn't be imported
+func (fs *Filesystem) Rebuild(dirs *Dirs) {
+	fs.requestResync(dirs)
+}
+
+// requestResync schedules a full update of the provided Dirs, if there isn't one scheduled for it already
+func (fs *Filesystem) requestResync(dirs *Dirs) {
+	if !dirs.resyncQueued.CompareAndSwap(false, true) {
+		return
+	}
+
+	select {
+	case fs.resyncChan <- dirs:
+	default:
+		dirs.resyncQueued.Store(false)
+	}
+}
+
+// allowed checks, if a folder may be added to the Dirs, based on the excludedDirs of the fs and the Dirs, and the BaseDirs of the other scopes
+func (fs *Filesystem) allowed(dirPath string, dirs *Dirs) bool {
+	if checked := fs.excludedDirs.check(dirPath, nil); !checked {
+		return false
+	}
+
+	if checked := dirs.excludedDirs.check(dirPath, dirs.passExcludedTo); !checked {
+		return false
+	}
+
+	for _, otherDirs := range fs.Scopes {
+		if otherDirs != dirs && otherDirs.hasBaseDir(dirPath) {
+			return false
 		}
+	}
+
+	return true
+}
+
+// allowedFile checks, if a file may be added to the Dirs, based on the file rules of the fs and the Dirs
+func (fs *Filesystem) allowedFile(item basicFile, dirs *Dirs) bool {
+	return fs.excludedDirs.files.check(item) && dirs.excludedDirs.files.check(item)
+}
+
+// hasBaseDir checks, if dirPath is one of the BaseDirs
+func (dirs *Dirs) hasBaseDir(dirPath string) bool {
+	dirs.baseDirsMu.Lock()
+	defer dirs.baseDirsMu.Unlock()
+
+	return dirs.BaseDirs[dirPath]
+}
 
-		for _, entry := range currentEntries {
-			if entry.IsDir() {
-				if entry.Name() == "." || entry.Name() == ".." {
-					continue
-				}
+// hasBaseDirOn checks, if one of the BaseDirs of the Dirs is on the mount at mountPoint
+func (dirs *Dirs) hasBaseDirOn(mountPoint string) bool {
+	dirs.baseDirsMu.Lock()
+	defer dirs.baseDirsMu.Unlock()
 
-				entryPath := fmt.Sprintf("%s%s", filepath.Join(currentDir, entry.Name()), string(filepath.Separator))
+	for baseDir := range dirs.BaseDirs {
+		if strings.HasPrefix(baseDir, mountPoint) {
+			return true
+		}
+	}
 
-				if checked := fs.excludedDirs.check(entryPath, false, &fs.ExtendedDirs); !checked {
-					continue
-				}
+	return false
+}
 
-				if checked := fs.excludeFromDefaultDirs.check(entryPath, true, &fs.ExtendedDirs); !checked {
-					continue
-				}
+// traverse takes folders from the pathQueue, or its own stack, until there are none left and sends all files and folders it encounters to the results unless they break the rules.
+// Folders that don't fit into the pathQueue go onto the stack and are handled by this worker itself, depth first, so no worker ever blocks on queueing a folder
+func (fs *Filesystem) traverse(t *traversal) {
+	t.throttle.lowerPriority()
 
-				otherDirs.Mu.Lock()
-				if _, ok := otherDirs.BaseDirs[entryPath]; ok {
-					otherDirs.Mu.Unlock()
-					continue
-				}
-				otherDirs.Mu.Unlock()
+	t.work(func(dirPath string, stack *[]string) {
+		t.throttle.pause()
+		fs.visit(t, dirPath, stack)
+	})
+}
 
-				results <- basicFile{"folder", true, entry.Name(), entryPath}
-				wg.Add(1)
-				pathQueue <- entryPath
-			} else {
-				fileExtension := filepath.Ext(filepath.Join(currentDir, entry.Name()))
-				fileName, _ := strings.CutSuffix(entry.Name(), fileExtension)
+// work is the loop of a traverse worker, it calls visit for every folder it takes, until the traversal is done
+func (t *traversal) work(visit func(dirPath string, stack *[]string)) {
+	stack := []string{}
 
-				results <- basicFile{fileExtension, false, fileName, currentDir}
+	for {
+		var currentDir string
+
+		if len(stack) > 0 {
+			currentDir, stack = stack[len(stack)-1], stack[:len(stack)-1]
+		} else {
+			dir, ok := <-t.pathQueue
+			// the pathQueue only closes once every folder is done
+			if !ok {
+				return
 			}
+
+			currentDir = dir
 		}
 
-		wg.Done()
+		visit(currentDir, &stack)
+		t.wg.Done()
 	}
 }
 
-// add formats and overwrites the dirMap on fs
-func (dirs *Dirs) add(results <-chan basicFile) {
+// visit reads a single folder of the traversal and queues the folders inside of it
+func (fs *Filesystem) visit(t *traversal, currentDir string, stack *[]string) {
+	policy := t.mounts.policy(currentDir, t.dirs)
+	if policy == skipMount {
+		return
+	}
 
-	newDirMap := make(map[string]map[int][]File)
-	tempPaths := make(map[string]int)
+	// once the MaxEntries are used up, the folders that are still queued aren't read anymore
+	if t.dirs.maxEntries > 0 && t.indexed.Load() >= t.dirs.maxEntries {
+		t.truncated(currentDir, truncatedByMaxEntries)
+		return
+	}
 
-	for item := range results {
-		itemExtension := strings.ToLower(item.extension)
-		itemName := item.name
-		itemPath := item.path
+	// we watch before reading, so nothing that gets created in between can slip through. Mounts that are only indexed on demand aren't watched, as inotify can't see remote changes anyway
+	if policy == indexMount {
+		t.dirs.watcher.watch(currentDir)
+	}
+
+	cachedOnly := policy == onDemandMount && !t.onDemand
+	ignores := t.ignoreList(fs, currentDir, cachedOnly)
+
+	currentEntries, stat, reread, err := t.entries(currentDir, ignores.fingerprint, cachedOnly)
+	// an error here mostly means we didn't have the permissions to read a dir, which shows up in the IndexStats
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			t.unreadable(currentDir)
+		}
+
+		return
+	}
+
+	depth := folderDepth(currentDir, t.baseDirs)
+	dirEntries := 0
+	kept := []basicFile{}
+	complete := true
+
+	for _, item := range currentEntries {
+		if ignores.ignored(item) {
+			continue
+		}
 
-		if _, ok := newDirMap[itemExtension]; !ok {
-			newDirMap[itemExtension] = make(map[int][]File)
+		if item.isFolder && !fs.allowed(item.path, t.dirs) || !item.isFolder && !fs.allowedFile(item, t.dirs) {
+			continue
 		}
 
-		if _, ok := newDirMap[itemExtension][len(itemName)]; !ok {
-			newDirMap[itemExtension][len(itemName)] = []File{}
+		if t.dirs.maxDirEntries > 0 && dirEntries >= t.dirs.maxDirEntries {
+			t.truncated(currentDir, truncatedByMaxDirEntries)
+			complete = false
+			break
 		}
 
-		if _, ok := tempPaths[itemPath]; item.isFolder && !ok {
-			tempPaths[itemPath] = len(tempPaths)
+		if t.dirs.maxEntries > 0 && t.indexed.Add(1) > t.dirs.maxEntries {
+			t.truncated(currentDir, truncatedByMaxEntries)
+			complete = false
+			break
 		}
 
-		newDirMap[itemExtension][len(itemName)] = append(newDirMap[itemExtension][len(itemName)], File{Encode(itemName), itemName, tempPaths[itemPath]})
+		dirEntries++
+		t.results <- item
+
+		if reread {
+			kept = append(kept, item)
+		}
+
+		if !item.isFolder {
+			if fs.wantsArchive(item) {
+				fs.visitArchive(t, item)
+			}
+
+			continue
+		}
+
+		// like find -xdev, mount points below the base dirs are indexed, but not descended into
+		if t.dirs.stayOnFilesystem && t.mounts.isMountPoint(item.path) {
+			continue
+		}
+
+		// folders deeper than the MaxDepth are indexed, but not read
+		if t.dirs.maxDepth > 0 && depth+1 > t.dirs.maxDepth {
+			t.truncated(item.path, truncatedByMaxDepth)
+			continue
+		}
+
+		t.ignoresMu.Lock()
+		t.ignores[item.path] = ignores
+		t.ignoresMu.Unlock()
+
+		t.queue(item.path, stack)
 	}
 
-	newPaths := make(map[int]string)
-	for key, value := range tempPaths {
-		newPaths[value] = key
+	// the entries a limit cut off would look deleted, and the next update would take the rest for all there is, so the folder is read again then
+	if !complete {
+		return
 	}
 
-	go util.OverwriteJSON(
-		dirs.CachePath,
-		false,
-		map[string]any{
-			"d": newDirMap,
-			"p": newPaths,
-		},
-	)
+	t.keepStat(currentDir, stat)
+
+	if reread {
+		t.diff(currentDir, kept)
+	}
+}
 
-	if len(dirs.DirMap) > 0 && len(dirs.Paths) > 0 {
-		dirs.DirMap = newDirMap
-		dirs.Paths = newPaths
+// folderDepth returns how many folders dirPath is below the closest of the baseDirs, which is 0 for a base dir itself
+func folderDepth(dirPath string, baseDirs []string) int {
+	baseDir := ""
+
+	for _, dir := range baseDirs {
+		if strings.HasPrefix(dirPath, dir) && len(dir) > len(baseDir) {
+			baseDir = dir
+		}
 	}
 
-	// reseting these to nil provides better debug.FreeOSMemory results
-	newDirMap, tempPaths, newPaths = nil, nil, nil
+	return strings.Count(strings.TrimPrefix(dirPath, baseDir), string(filepath.Separator))
+}
+
+// depth returns the folderDepth of dirPath below the current BaseDirs of the Dirs
+func (dirs *Dirs) depth(dirPath string) int {
+	dirs.baseDirsMu.Lock()
+	baseDirs := slices.Collect(maps.Keys(dirs.BaseDirs))
+	dirs.baseDirsMu.Unlock()
+
+	return folderDepth(dirPath, baseDirs)
+}
+
+// queue hands a folder to the other workers through the pathQueue, or puts it onto the stack of the current worker, if the pathQueue is full
+func (t *traversal) queue(dirPath string, stack *[]string) {
+	t.wg.Add(1)
+
+	select {
+	case t.pathQueue <- dirPath:
+	default:
+		*stack = append(*stack, dirPath)
+	}
+}
+
+// crawl walks a single directory tree like traverse does, but without the worker pool, as it's meant for the small trees the watcher finds. parentIgnores is the ignoreList of the folder above dirPath
+func (fs *Filesystem) crawl(dirPath string, dirs *Dirs, parentIgnores *ignoreList) []basicFile {
+	mounts := *fs.mounts.Load()
+	if mounts.policy(dirPath, dirs) != indexMount {
+		return nil
+	}
+
+	ignores := fs.readIgnoreList(dirPath, parentIgnores, dirs)
+
+	dirs.watcher.watch(dirPath)
+
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil
+	}
+
+	found := []basicFile{}
+	depth := dirs.depth(dirPath)
+	dirEntries := 0
+
+	for _, entry := range entries {
+		metadata, err := mounts.entryMetadata(dirPath, entry, false)
+		// the entry got removed since we read the dir
+		if err != nil {
+			continue
+		}
+
+		item := newBasicFile(dirPath, entry.Name(), entry.IsDir(), metadata)
+
+		if ignores.ignored(item) {
+			continue
+		}
+
+		if item.isFolder && !fs.allowed(item.path, dirs) || !item.isFolder && !fs.allowedFile(item, dirs) {
+			continue
+		}
+
+		// the MaxEntries are left to the next update, as the watcher only sees a small part of the Dirs
+		if dirs.maxDirEntries > 0 && dirEntries >= dirs.maxDirEntries {
+			break
+		}
+
+		dirEntries++
+		found = append(found, item)
+
+		if !item.isFolder {
+			if fs.wantsArchive(item) {
+				found = append(found, fs.archiveMembers(item, dirs)...)
+			}
+
+			continue
+		}
+
+		if dirs.stayOnFilesystem && mounts.isMountPoint(item.path) {
+			continue
+		}
+
+		if dirs.maxDepth > 0 && depth+1 > dirs.maxDepth {
+			continue
+		}
+
+		found = append(found, fs.crawl(item.path, dirs, ignores)...)
+	}
+
+	return found
+}
+
+// newBasicFile splits an entry of parentDir into the parts we store in the cache. Folders get their own path, while files get the path of parentDir
+func newBasicFile(parentDir string, name string, isFolder bool, metadata Metadata) basicFile {
+	if isFolder {
+		return basicFile{"folder", true, name, fmt.Sprintf("%s%s", filepath.Join(parentDir, name), string(filepath.Separator)), metadata}
+	}
+
+	fileExtension := filepath.Ext(name)
+	fileName, _ := strings.CutSuffix(name, fileExtension)
+
+	return basicFile{fileExtension, false, fileName, parentDir, metadata}
+}
+
+// newMetadata converts the fileInfo of an entry into the Metadata we store for it
+func newMetadata(fileInfo os.FileInfo) Metadata {
+	metadata := Metadata{
+		Size:    fileInfo.Size(),
+		ModTime: fileInfo.ModTime().UnixNano(),
+		Mode:    uint32(fileInfo.Mode()),
+	}
+
+	if sysStat, ok := fileInfo.Sys().(*syscall.Stat_t); ok {
+		metadata.Inode = sysStat.Ino
+		metadata.Device = sysStat.Dev
+	}
+
+	return metadata
+}
+
+// entryMetadata returns the Metadata of a dir entry, which costs an lstat for it
+func entryMetadata(entry os.DirEntry) (Metadata, error) {
+	fileInfo, err := entry.Info()
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	return newMetadata(fileInfo), nil
+}
+
+// add hands the results to the store and counts them onto the indexStats, then publishes them. It returns the files, whose contents and tags should be indexed
+func (dirs *Dirs) add(results <-chan basicFile, stats map[string]DirStat, indexStats *IndexStats) ([]basicFile, []basicFile) {
+	update := dirs.store.update()
+	contentFiles := []basicFile{}
+	tagFiles := []basicFile{}
+
+	for item := range results {
+		indexStats.count(strings.ToLower(item.extension), item.isFolder, 1)
+
+		if dirs.contentRules.wants(item) {
+			contentFiles = append(contentFiles, item)
+		}
+
+		if dirs.wantsTags(item) {
+			tagFiles = append(tagFiles, item)
+		}
+
+		update.add(item)
+	}
+
+	// stats is complete at this point, because results only closes after all traverse workers are done
+	update.addStats(stats)
+
+	// the events that came in during the crawl might not be part of it, so they're applied on top
+	dirs.publish(update)
+
+	// dropping the update before provides better debug.FreeOSMemory results
+	update = nil
 
 	runtime.GC()
 	debug.FreeOSMemory()
+
+	return contentFiles, tagFiles
 }
@@ -0,0 +1,305 @@
+// Package cache handles everything that has to do with the generation of the cache for the Search function, to the generation of our folder structure and importing of the config.
+package cache
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/skillptm/Bolt/internal/config"
+)
+
+// testScope is the name of the scope testConfig sets up
+const testScope string = "test"
+
+// testConfig returns a config with a single scope over baseDir, which keeps its cache in a temp folder
+func testConfig(t *testing.T, baseDir string, threads int) *config.Config {
+	return &config.Config{
+		MaxCPUThreads: threads,
+		Scopes:        []config.Scope{{Name: testScope, Default: true, UpdateTime: 3600, Dirs: []string{baseDir}}},
+		Paths:         map[string]string{"cache": t.TempDir()},
+	}
+}
+
+// newTestFilesystem sets up the Filesystem for the config and imports the cache of its scope. It's closed once the test is done
+func newTestFilesystem(t *testing.T, conf *config.Config) (*Filesystem, *Dirs) {
+	t.Helper()
+
+	fs, err := NewFilesystem(conf)
+	if err != nil {
+		t.Fatalf("newTestFilesystem: couldn't setup Filesystem:\n--> %s", err)
+	}
+
+	t.Cleanup(fs.Close)
+
+	dirs := fs.Scope(testScope)
+	dirs.Import()
+
+	return fs, dirs
+}
+
+// eventually calls check until it returns true, or fails the test after a few seconds
+func eventually(t *testing.T, message string, check func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+
+	for !check() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// countIndex counts the files per extension in the current Index of the Dirs
+func countIndex(t *testing.T, dirs *Dirs) map[string]int {
+	t.Helper()
+
+	index := dirs.Index()
+	if index == nil {
+		t.Fatal("countIndex: the cache isn't imported")
+	}
+	defer index.Release()
+
+	counts := make(map[string]int)
+
+	err := index.Query(nil, 0, [8]byte{}, "", func(extension string, file File) bool {
+		if index.Path(file.PathKey) == "" {
+			t.Errorf("countIndex: %s%s has no path", file.Name, extension)
+		}
+
+		counts[extension]++
+		return true
+	})
+	if err != nil {
+		t.Fatalf("countIndex: couldn't query the index:\n--> %s", err)
+	}
+
+	return counts
+}
+
+// TestUpdateLargerThanPathQueue crawls a folder with more folders in it than fit into the pathQueue. With a single worker nobody takes folders off the pathQueue while it reads, so it's guaranteed to fill up and the rest has to go onto the stack
+func TestUpdateLargerThanPathQueue(t *testing.T) {
+	baseDir := t.TempDir() + string(filepath.Separator)
+	folderCount := pathQueueSize + 512
+
+	for index := range folderCount {
+		folder := filepath.Join(baseDir, fmt.Sprintf("folder%d", index))
+
+		if err := os.Mkdir(folder, 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(filepath.Join(folder, "file.txt"), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a deadlocked crawl would never return, so NewFilesystem, which runs the first update, gets a deadline
+	conf := testConfig(t, baseDir, 1)
+	done := make(chan error)
+	var fs *Filesystem
+
+	go func() {
+		var err error
+		fs, err = NewFilesystem(conf)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Minute):
+		t.Fatal("the crawl didn't finish")
+	}
+
+	t.Cleanup(fs.Close)
+
+	dirs := fs.Scope(testScope)
+
+	stats := dirs.IndexStats()
+	if stats.Folders != folderCount || stats.Files != folderCount {
+		t.Fatalf("the update found %d folders and %d files, instead of %d of each", stats.Folders, stats.Files, folderCount)
+	}
+
+	// the first update isn't kept in memory, so we import it and update once more to look at what was stored
+	dirs.Import()
+	fs.Update(dirs, false)
+
+	counts := countIndex(t, dirs)
+	if counts["folder"] != folderCount || counts[".txt"] != folderCount {
+		t.Fatalf("the index has %d folders and %d files, instead of %d of each", counts["folder"], counts[".txt"], folderCount)
+	}
+}
+
+// TestQueueOverflowsOntoStack makes sure queue never blocks on a full pathQueue and still counts the folder, so the traversal isn't done before it's read
+func TestQueueOverflowsOntoStack(t *testing.T) {
+	tr := traversal{pathQueue: make(chan string, 1)}
+	stack := []string{}
+
+	tr.queue("/a/", &stack)
+	tr.queue("/b/", &stack)
+
+	if len(tr.pathQueue) != 1 || len(stack) != 1 || stack[0] != "/b/" {
+		t.Fatalf("expected /a/ in the pathQueue and /b/ on the stack, got %d queued and the stack %v", len(tr.pathQueue), stack)
+	}
+
+	// both folders are still waiting, so the traversal can't be done yet
+	waited := make(chan struct{})
+	go func() {
+		tr.wg.Wait()
+		close(waited)
+	}()
+
+	select {
+	case <-waited:
+		t.Fatal("the traversal was done with folders still queued")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	tr.wg.Add(-2)
+	<-waited
+}
+
+// TestTraversalStaysBounded runs the workers of a traversal over a made up tree with more folders than the 10 million slot channels the crawl used to have.
+// Its folders are found a lot faster than any disk could be read, so the workers fall behind all the time, and still the stacks have to stay within the depth times the folders per level and the heap has to stay small
+func TestTraversalStaysBounded(t *testing.T) {
+	const (
+		fanOut   int    = 10 // folders in every folder above the last level
+		depth    int    = 7  // levels below the root, the last one alone has 10 million folders
+		maxHeap  uint64 = 64 << 20
+		heapStep int64  = 1 << 20 // how many folders are visited between looking at the heap
+	)
+
+	folderCount := int64(0)
+	for level, width := 0, int64(1); level <= depth; level, width = level+1, width*int64(fanOut) {
+		folderCount += width
+	}
+
+	tr := traversal{pathQueue: make(chan string, pathQueueSize)}
+	visited := atomic.Int64{}
+	stackPeak := atomic.Uint64{}
+	heapPeak := atomic.Uint64{}
+
+	visit := func(dirPath string, stack *[]string) {
+		if visited.Add(1)%heapStep == 0 {
+			stats := runtime.MemStats{}
+			runtime.ReadMemStats(&stats)
+			storePeak(&heapPeak, stats.HeapAlloc)
+		}
+
+		// "/" is the root, "/3/" is on the first level
+		if strings.Count(dirPath, "/")-1 < depth {
+			for index := range fanOut {
+				tr.queue(dirPath+strconv.Itoa(index)+"/", stack)
+			}
+		}
+
+		storePeak(&stackPeak, uint64(len(*stack)))
+	}
+
+	// the same setup as in Update, with a single base dir
+	workers := sync.WaitGroup{}
+	tr.wg.Add(1)
+
+	for range 4 {
+		workers.Add(1)
+
+		go func() {
+			defer workers.Done()
+			tr.work(visit)
+		}()
+	}
+
+	go func() {
+		tr.wg.Wait()
+		close(tr.pathQueue)
+	}()
+
+	tr.pathQueue <- "/"
+
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Minute):
+		t.Fatalf("the traversal didn't finish, %d of %d folders were visited", visited.Load(), folderCount)
+	}
+
+	if visited.Load() != folderCount {
+		t.Fatalf("%d of %d folders were visited", visited.Load(), folderCount)
+	}
+
+	if stackPeak.Load() > uint64(depth*fanOut) {
+		t.Fatalf("a stack grew to %d folders, more than the %d of a single path down the tree", stackPeak.Load(), depth*fanOut)
+	}
+
+	if heapPeak.Load() > maxHeap {
+		t.Fatalf("the heap grew to %d bytes, more than %d", heapPeak.Load(), maxHeap)
+	}
+}
+
+// storePeak raises peak to value, if it's higher
+func storePeak(peak *atomic.Uint64, value uint64) {
+	for current := peak.Load(); value > current; current = peak.Load() {
+		if peak.CompareAndSwap(current, value) {
+			return
+		}
+	}
+}
+
+// TestTruncatedFolderIsReadAgain cuts a folder off with the MaxEntries and frees some of them up afterwards. The next update has to read the folder again, instead of taking the cut off entries from the last one for all there is
+func TestTruncatedFolderIsReadAgain(t *testing.T) {
+	baseDir := t.TempDir() + string(filepath.Separator)
+	subDir := filepath.Join(baseDir, "sub")
+
+	if err := os.Mkdir(subDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(baseDir, "top.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for index := range 4 {
+		if err := os.WriteFile(filepath.Join(subDir, fmt.Sprintf("file%d.txt", index)), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the base dir takes 2 of the entries, which leaves 2 of the 4 files in sub
+	conf := testConfig(t, baseDir, 1)
+	conf.Scopes[0].MaxEntries = 4
+	fs, dirs := newTestFilesystem(t, conf)
+	fs.Update(dirs, false)
+
+	if counts := countIndex(t, dirs); counts[".txt"] != 3 {
+		t.Fatalf("expected 3 files within the MaxEntries, got %d", counts[".txt"])
+	}
+
+	// sub itself didn't change, but now there's room for one more of its files
+	if err := os.Remove(filepath.Join(baseDir, "top.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	fs.Update(dirs, false)
+
+	if counts := countIndex(t, dirs); counts[".txt"] != 3 {
+		t.Fatalf("expected the 3 files of sub after freeing up an entry, got %d", counts[".txt"])
+	}
+}
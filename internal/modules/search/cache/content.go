@@ -0,0 +1,483 @@
+// Package cache handles everything that has to do with the generation of the cache for the Search function, to the generation of our folder structure and importing of the config.
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/skillptm/Bolt/internal/config"
+)
+
+/*
+The content index file uses the same header as the cache file, with its own magic. The body is laid out as follows:
+
+docs:  count uint32 | count * (path string | modTime int64 | size int64)
+terms: count uint32 | count * (term string | postings count uint32 | postings count * (docID uint32 | count uint32))
+*/
+const (
+	contentMagic      string = "BLTC"
+	contentVersion    uint16 = 1
+	docRecordSize     int    = 17
+	termRecordSize    int    = 5
+	postingRecordSize int    = 8
+
+	binarySniffSize int = 8000 // like git, a NUL byte in the start of a file means it's binary
+	minTermLength   int = 2
+	maxTermLength   int = 64
+
+	docsRefreshDelay time.Duration = 2 * time.Second // a file that's being written is reported many times, so it's only read once the events settle
+)
+
+// contentRules decide which files of a Dirs get their contents indexed
+type contentRules struct {
+	dirs       []string
+	extensions map[string]bool
+	maxSize    int64
+}
+
+/*
+contentIndex is an inverted index over the words in the text files of a Dirs. It's never changed after it was built, an update builds a new one instead.
+
+docs: []contentDoc{path, modTime, size}, where the docID is the index
+
+terms: map[Term][]posting{docID, count}
+*/
+type contentIndex struct {
+	docs  []contentDoc
+	terms map[string][]posting
+}
+
+// contentDoc is a file in the contentIndex. The modTime and size tell us, if it has to be read again on an update
+type contentDoc struct {
+	path    string
+	modTime int64
+	size    int64
+}
+
+// posting tells us how often a term is in a doc
+type posting struct {
+	docID uint32
+	count uint32
+}
+
+// pendingDoc is a file the watcher reported, whose contents and tags have to be read again, or dropped if it was removed
+type pendingDoc struct {
+	item    basicFile
+	removed bool
+}
+
+// ContentMatch is a file, whose contents matched a content search
+type ContentMatch struct {
+	Path    string
+	Terms   int   // how many of the search terms are in the file
+	Count   int   // how often the search terms are in the file in total
+	ModTime int64 // in unix nanoseconds
+}
+
+// newContentRules converts the ContentIndex from the config into contentRules, it returns nil if the ContentIndex is disabled
+func newContentRules(conf config.ContentIndex) *contentRules {
+	if !conf.Enabled {
+		return nil
+	}
+
+	rules := contentRules{extensions: make(map[string]bool), maxSize: conf.MaxSize}
+
+	for _, dir := range conf.Dirs {
+		if !strings.HasSuffix(dir, string(filepath.Separator)) {
+			dir += string(filepath.Separator)
+		}
+
+		rules.dirs = append(rules.dirs, dir)
+	}
+
+	for _, extension := range conf.Extensions {
+		if !strings.HasPrefix(extension, ".") {
+			extension = "." + extension
+		}
+
+		rules.extensions[strings.ToLower(extension)] = true
+	}
+
+	return &rules
+}
+
+// wants checks, if the contents of the item should be indexed
+func (rules *contentRules) wants(item basicFile) bool {
+	return rules.covers(item) && item.metadata.Size <= rules.maxSize
+}
+
+// covers checks, if the item is one of the files the contentRules are about, no matter its size
+func (rules *contentRules) covers(item basicFile) bool {
+	// the members of archives can't be read without extracting them
+	if rules == nil || item.isFolder || !rules.extensions[strings.ToLower(item.extension)] || item.inArchive() {
+		return false
+	}
+
+	for _, dir := range rules.dirs {
+		if strings.HasPrefix(item.path, dir) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// updateContent builds the new contentIndex of the Dirs from the files an update found. Only files that changed since the last update are read again
+func (dirs *Dirs) updateContent(files []basicFile) {
+	if dirs.contentRules == nil {
+		return
+	}
+
+	previous := dirs.content.Load()
+
+	if !dirs.imported() || previous == nil {
+		var err error
+
+		// without a readable content index every file gets read again
+		previous, err = readContent(dirs.contentPath)
+		if err != nil {
+			previous = &contentIndex{terms: make(map[string][]posting)}
+		}
+	}
+
+	index := previous.update(files, dirs.contentRules.maxSize, dirs.ignoreDiacritics)
+
+	// like the cache, a content index we couldn't write is simply rebuilt on the next update
+	writeContent(dirs.contentPath, index)
+
+	// a Clear during the update would otherwise be undone
+	dirs.mu.Lock()
+	if dirs.imported() {
+		dirs.content.Store(index)
+	}
+	dirs.mu.Unlock()
+}
+
+// queueDocs remembers the files of the changes the watcher applied, whose contents or tags have to be read again or dropped. They're applied a little later, together with the ones that follow them
+func (dirs *Dirs) queueDocs(changes []changedFile) {
+	dirs.docsMu.Lock()
+	defer dirs.docsMu.Unlock()
+
+	if dirs.docsStopped {
+		return
+	}
+
+	for _, change := range changes {
+		if !dirs.contentRules.covers(change.item) && !dirs.wantsTags(change.item) {
+			continue
+		}
+
+		if dirs.pendingDocs == nil {
+			dirs.pendingDocs = make(map[string]pendingDoc)
+		}
+
+		dirs.pendingDocs[change.item.fullPath()] = pendingDoc{change.item, change.kind == ChangeDeleted}
+	}
+
+	if len(dirs.pendingDocs) > 0 && dirs.docsTimer == nil {
+		dirs.docsTimer = time.AfterFunc(docsRefreshDelay, dirs.refreshDocs)
+	}
+}
+
+// refreshDocs applies the files queueDocs collected onto the content and tag index
+func (dirs *Dirs) refreshDocs() {
+	dirs.docsMu.Lock()
+	pending := dirs.pendingDocs
+	dirs.pendingDocs, dirs.docsTimer = nil, nil
+	dirs.docsMu.Unlock()
+
+	// an update replaces both indexes as a whole, so it would drop what we change in the meantime
+	dirs.updateMu.Lock()
+	defer dirs.updateMu.Unlock()
+
+	dirs.patchContent(pending)
+	dirs.patchTags(pending)
+}
+
+// stopDocs drops the files, that wait for refreshDocs. The next update reads them again anyway, as they changed since the last one
+func (dirs *Dirs) stopDocs() {
+	dirs.docsMu.Lock()
+	defer dirs.docsMu.Unlock()
+
+	if dirs.docsTimer != nil {
+		dirs.docsTimer.Stop()
+	}
+
+	dirs.pendingDocs, dirs.docsTimer = nil, nil
+	dirs.docsStopped = true
+}
+
+// patchContent reads the pending files into the contentIndex of the Dirs and drops the removed ones from it
+func (dirs *Dirs) patchContent(pending map[string]pendingDoc) {
+	if dirs.contentRules == nil {
+		return
+	}
+
+	previous := dirs.content.Load()
+
+	if !dirs.imported() || previous == nil {
+		var err error
+
+		// without a content index, there's nothing to patch. The next update builds it
+		previous, err = readContent(dirs.contentPath)
+		if err != nil {
+			return
+		}
+	}
+
+	files := make([]basicFile, 0, len(previous.docs)+len(pending))
+
+	for _, doc := range previous.docs {
+		if _, ok := pending[doc.path]; !ok {
+			files = append(files, doc.file())
+		}
+	}
+
+	for _, doc := range pending {
+		if !doc.removed && dirs.contentRules.wants(doc.item) {
+			files = append(files, doc.item)
+		}
+	}
+
+	index := previous.update(files, dirs.contentRules.maxSize, dirs.ignoreDiacritics)
+
+	writeContent(dirs.contentPath, index)
+
+	dirs.mu.Lock()
+	if dirs.imported() {
+		dirs.content.Store(index)
+	}
+	dirs.mu.Unlock()
+}
+
+// file returns the doc as the basicFile an update would have found for it, so update keeps its postings as long as the file didn't change
+func (doc *contentDoc) file() basicFile {
+	return newBasicFile(parentDir(doc.path), filepath.Base(doc.path), false, Metadata{Size: doc.size, ModTime: doc.modTime})
+}
+
+// update returns a new contentIndex for the files. Files with the same modTime and size as in the contentIndex keep their postings, the others are read and tokenized
+func (index *contentIndex) update(files []basicFile, maxSize int64, ignoreDiacritics bool) *contentIndex {
+	previousIDs := make(map[string]uint32, len(index.docs))
+	for docID, doc := range index.docs {
+		previousIDs[doc.path] = uint32(docID)
+	}
+
+	newIndex := contentIndex{terms: make(map[string][]posting)}
+	keptIDs := make(map[uint32]uint32)
+
+	for _, item := range files {
+		doc := contentDoc{fmt.Sprintf("%s%s%s", item.path, item.name, item.extension), item.metadata.ModTime, item.metadata.Size}
+		docID := uint32(len(newIndex.docs))
+
+		if previousID, ok := previousIDs[doc.path]; ok && index.docs[previousID] == doc {
+			keptIDs[previousID] = docID
+			newIndex.docs = append(newIndex.docs, doc)
+			continue
+		}
+
+		counts, err := tokenizeFile(doc.path, maxSize, ignoreDiacritics)
+		// files we can't read right now are tried again on the next update
+		if err != nil {
+			continue
+		}
+
+		newIndex.docs = append(newIndex.docs, doc)
+
+		for term, count := range counts {
+			newIndex.terms[term] = append(newIndex.terms[term], posting{docID, count})
+		}
+	}
+
+	for term, postings := range index.terms {
+		for _, entry := range postings {
+			if docID, ok := keptIDs[entry.docID]; ok {
+				newIndex.terms[term] = append(newIndex.terms[term], posting{docID, entry.count})
+			}
+		}
+	}
+
+	return &newIndex
+}
+
+// tokenizeFile reads up to maxSize bytes of the file and counts the terms in it. Binary files have no terms
+func tokenizeFile(filePath string, maxSize int64, ignoreDiacritics bool) (map[string]uint32, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("tokenizeFile: couldn't open %s:\n--> %w", filePath, err)
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(io.LimitReader(file, maxSize))
+	if err != nil {
+		return nil, fmt.Errorf("tokenizeFile: couldn't read %s:\n--> %w", filePath, err)
+	}
+
+	counts := make(map[string]uint32)
+
+	if bytes.IndexByte(content[:min(len(content), binarySniffSize)], 0) >= 0 {
+		return counts, nil
+	}
+
+	for _, term := range contentTerms(string(content), ignoreDiacritics) {
+		counts[term]++
+	}
+
+	return counts, nil
+}
+
+// contentTerms splits the input into the terms we index, which are its normalized words and numbers. Terms that are too short or too long to be useful are dropped
+func contentTerms(input string, ignoreDiacritics bool) []string {
+	terms := []string{}
+
+	for _, term := range strings.FieldsFunc(Normalize(input, ignoreDiacritics), func(char rune) bool {
+		return !unicode.IsLetter(char) && !unicode.IsDigit(char)
+	}) {
+		if len(term) >= minTermLength && len(term) <= maxTermLength {
+			terms = append(terms, term)
+		}
+	}
+
+	return terms
+}
+
+// SearchContent returns the files in the content index of the Dirs, that contain at least one of the terms of the query. The last term also matches longer terms, as it might not be fully typed yet
+func (dirs *Dirs) SearchContent(query string) []ContentMatch {
+	index := dirs.content.Load()
+	if index == nil {
+		return []ContentMatch{}
+	}
+
+	terms := []string{}
+	for _, term := range contentTerms(query, dirs.ignoreDiacritics) {
+		if !slices.Contains(terms, term) {
+			terms = append(terms, term)
+		}
+	}
+
+	termCounts := make(map[uint32]int)
+	counts := make(map[uint32]int)
+
+	for position, term := range terms {
+		found := make(map[uint32]bool)
+
+		addPostings := func(postings []posting) {
+			for _, entry := range postings {
+				found[entry.docID] = true
+				counts[entry.docID] += int(entry.count)
+			}
+		}
+
+		if position < len(terms)-1 {
+			addPostings(index.terms[term])
+		} else {
+			for indexTerm, postings := range index.terms {
+				if strings.HasPrefix(indexTerm, term) {
+					addPostings(postings)
+				}
+			}
+		}
+
+		for docID := range found {
+			termCounts[docID]++
+		}
+	}
+
+	output := make([]ContentMatch, 0, len(termCounts))
+
+	for docID, termCount := range termCounts {
+		doc := index.docs[docID]
+		output = append(output, ContentMatch{doc.path, termCount, counts[docID], doc.modTime})
+	}
+
+	return output
+}
+
+// loadContent imports the content index file from the disk. A content index that can't be read is left empty, until the next update rebuilds it
+func (dirs *Dirs) loadContent() {
+	if dirs.contentRules == nil {
+		return
+	}
+
+	index, err := readContent(dirs.contentPath)
+	if err != nil {
+		index = &contentIndex{terms: make(map[string][]posting)}
+	}
+
+	dirs.content.Store(index)
+}
+
+// writeContent writes the contentIndex in the binary content format to contentPath
+func writeContent(contentPath string, index *contentIndex) error {
+	return writeBinary(contentPath, contentMagic, contentVersion, func(cw *cacheWriter) {
+		cw.uint32(uint32(len(index.docs)))
+		for _, doc := range index.docs {
+			cw.string(doc.path)
+			cw.uint64(uint64(doc.modTime))
+			cw.uint64(uint64(doc.size))
+		}
+
+		cw.uint32(uint32(len(index.terms)))
+		for term, postings := range index.terms {
+			cw.string(term)
+			cw.uint32(uint32(len(postings)))
+
+			for _, entry := range postings {
+				cw.uint32(entry.docID)
+				cw.uint32(entry.count)
+			}
+		}
+	})
+}
+
+// readContent reads a binary content index file
+func readContent(contentPath string) (*contentIndex, error) {
+	index := contentIndex{terms: make(map[string][]posting)}
+
+	err := readBinary(contentPath, contentMagic, contentVersion, func(cr *cacheReader) {
+		docCount := cr.count(docRecordSize)
+		index.docs = make([]contentDoc, 0, docCount)
+
+		for range docCount {
+			index.docs = append(index.docs, contentDoc{cr.string(), int64(cr.uint64()), int64(cr.uint64())})
+		}
+
+		for range cr.count(termRecordSize) {
+			term := cr.string()
+			postings := make([]posting, 0, cr.count(postingRecordSize))
+
+			for range cap(postings) {
+				entry := posting{cr.uint32(), cr.uint32()}
+
+				if cr.err == nil && int(entry.docID) >= len(index.docs) {
+					cr.err = errors.New("posting points outside of the docs")
+				}
+
+				postings = append(postings, entry)
+			}
+
+			if cr.err != nil {
+				return
+			}
+
+			index.terms[term] = postings
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("readContent: couldn't read content index file:\n--> %w", err)
+	}
+
+	return &index, nil
+}
+
+// contentPath returns the path of the content index file of a scope
+func contentPath(cacheDir string, name string) string {
+	return filepath.Join(cacheDir, fmt.Sprintf("%s_content.bin", name))
+}
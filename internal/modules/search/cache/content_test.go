@@ -0,0 +1,84 @@
+// Package cache handles everything that has to do with the generation of the cache for the Search function, to the generation of our folder structure and importing of the config.
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/skillptm/Bolt/internal/config"
+)
+
+// contentPaths returns the paths of the files, whose contents match the query
+func contentPaths(dirs *Dirs, query string) []string {
+	paths := []string{}
+
+	for _, match := range dirs.SearchContent(query) {
+		paths = append(paths, match.Path)
+	}
+
+	return paths
+}
+
+// TestContentFollowsWatcher makes sure files that are created, changed and removed while the watcher keeps the Dirs up to date reach the content index without an update
+func TestContentFollowsWatcher(t *testing.T) {
+	baseDir := t.TempDir() + string(filepath.Separator)
+	notePath := filepath.Join(baseDir, "note.txt")
+
+	conf := testConfig(t, baseDir, 2)
+	conf.ContentIndex = config.ContentIndex{Enabled: true, Dirs: []string{baseDir}, Extensions: []string{"txt"}, MaxSize: 1024}
+
+	_, dirs := newTestFilesystem(t, conf)
+
+	if !dirs.watcher.live() {
+		t.Skip("no inotify watcher available")
+	}
+
+	if err := os.WriteFile(notePath, []byte("remember the milk"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, "the new file never reached the content index", func() bool {
+		return len(contentPaths(dirs, "milk")) == 1
+	})
+
+	if err := os.WriteFile(notePath, []byte("remember the bread"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, "the changed file never got its new contents", func() bool {
+		return len(contentPaths(dirs, "bread")) == 1 && len(contentPaths(dirs, "milk")) == 0
+	})
+
+	if err := os.Remove(notePath); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, "the removed file stayed in the content index", func() bool {
+		return len(contentPaths(dirs, "bread")) == 0
+	})
+
+	// while the cache is cleared, the watcher can only keep the events, so the file is read once it's imported again
+	dirs.Clear()
+
+	dirs.eventsMu.Lock()
+	reported := len(dirs.pending)
+	dirs.eventsMu.Unlock()
+
+	if err := os.WriteFile(notePath, []byte("remember the eggs"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, "the watcher never reported the file", func() bool {
+		dirs.eventsMu.Lock()
+		defer dirs.eventsMu.Unlock()
+
+		return len(dirs.pending) > reported
+	})
+
+	dirs.Import()
+
+	eventually(t, "the file written while the cache was cleared never reached the content index", func() bool {
+		return len(contentPaths(dirs, "eggs")) == 1
+	})
+}
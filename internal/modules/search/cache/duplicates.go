@@ -0,0 +1,370 @@
+// Package cache handles everything that has to do with the generation of the cache for the Search function, to the generation of our folder structure and importing of the config.
+package cache
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/skillptm/Bolt/internal/config"
+)
+
+/*
+The hashes file uses the same header as the cache file, with its own magic. The body is laid out as follows:
+
+hashes: count uint32 | count * (device uint64 | inode uint64 | modTime int64 | size int64 | partial string | full string)
+*/
+const (
+	hashesMagic    string = "BLTH"
+	hashesVersion  uint16 = 2
+	hashRecordSize int    = 34
+
+	partialHashSize int64 = 64 * 1024 // files with the same start are the only ones we have to read completely
+	maxHashes       int   = 500000    // the hashes the last search didn't look at are dropped first
+)
+
+// DuplicateGroup is a set of files with the same content
+type DuplicateGroup struct {
+	Size  int64    `json:"Size"` // of a single one of the files, in bytes
+	Paths []string `json:"Paths"`
+}
+
+// hashKey identifies a version of a file. A file that's written to gets a new modTime, so its old hashes are never used for it again. Inodes are only unique on their device, so it's part of the key as well
+type hashKey struct {
+	device  uint64
+	inode   uint64
+	modTime int64
+	size    int64
+}
+
+// fileHashes holds the hashes of a file, the full one is only computed once the partial one matched another file
+type fileHashes struct {
+	full    string
+	partial string
+	used    bool // if the current search looked at it
+}
+
+// duplicateCandidate is a file, that might have the same content as another one
+type duplicateCandidate struct {
+	key  hashKey
+	path string
+}
+
+// Duplicates returns the groups of files with the same content in the scopes, the ones that take up the most space first. The forceStopChan can be used to end it early, which makes it return no groups.
+// Scopes that aren't imported are read from the disk for this. Only files with one of the extensions are compared, or all of them, if there are none
+func (fs *Filesystem) Duplicates(scopes []*Dirs, extensions []string, forceStopChan chan bool) []DuplicateGroup {
+	indexes := []Index{}
+
+	for _, dirs := range scopes {
+		if index := dirs.Index(); index != nil {
+			defer index.Release()
+			indexes = append(indexes, index)
+			continue
+		}
+
+		// a scope we can't read has nothing to compare
+		data, err := dirs.store.data()
+		if err != nil {
+			continue
+		}
+
+		indexes = append(indexes, &Generation{dirMap: data.dirMap, paths: data.paths, stats: data.stats})
+	}
+
+	return findDuplicates(indexes, extensions, fs.hashesPath, forceStopChan)
+}
+
+// LoadDuplicates returns the groups of files with the same content in the scopes of the config with one of the names, or all of them if there are none, for when there is no Filesystem to ask. The indexes of the scopes are read from the disk
+func LoadDuplicates(conf *config.Config, scopeNames []string, extensions []string) ([]DuplicateGroup, error) {
+	indexes := []Index{}
+
+	for _, scope := range conf.Scopes {
+		if len(scopeNames) > 0 && !slices.Contains(scopeNames, scope.Name) {
+			continue
+		}
+
+		data, err := newIndexStore(conf, scope).data()
+		if err != nil {
+			return nil, fmt.Errorf("LoadDuplicates: couldn't read the index of scope %s:\n--> %w", scope.Name, err)
+		}
+
+		indexes = append(indexes, &Generation{dirMap: data.dirMap, paths: data.paths, stats: data.stats})
+	}
+
+	return findDuplicates(indexes, extensions, hashesPath(conf.Paths["cache"]), make(chan bool)), nil
+}
+
+// findDuplicates groups the files of the indexes by their size, then by the hash of their start and then by the hash of their whole content. The hashes are taken from the hashes file, if the file didn't change since they were computed
+func findDuplicates(indexes []Index, extensions []string, hashesPath string, forceStopChan chan bool) []DuplicateGroup {
+	output := []DuplicateGroup{}
+	hashes := readHashes(hashesPath)
+
+	bySize := make(map[int64][]duplicateCandidate)
+	listed := make(map[string]bool)
+	listedKeys := make(map[hashKey]bool)
+
+	// with only folders asked for there's nothing to compare, while no extensions at all means every one of them
+	checked := duplicateExtensions(extensions)
+	if len(extensions) > 0 && len(checked) == 0 {
+		return output
+	}
+
+	for _, index := range indexes {
+		index.Query(checked, 0, [8]byte{}, "", func(extension string, file File) bool {
+			if len(forceStopChan) > 0 {
+				return false
+			}
+
+			// empty files are all the same, but there's nothing to gain from removing them. Folders are never compared
+			if extension == "folder" || file.Metadata.Size == 0 || !os.FileMode(file.Metadata.Mode).IsRegular() {
+				return true
+			}
+
+			// the members of archives take up no space of their own
+			if _, _, ok := SplitArchivePath(index.Path(file.PathKey)); ok {
+				return true
+			}
+
+			candidate := duplicateCandidate{hashKey{file.Metadata.Device, file.Metadata.Inode, file.Metadata.ModTime, file.Metadata.Size}, fmt.Sprintf("%s%s%s", index.Path(file.PathKey), file.Name, extension)}
+
+			// a file in more than one scope is only compared once, and hard links share their device and inode, as they're the same file and don't take up any extra space
+			if listed[candidate.path] || candidate.key.inode != 0 && listedKeys[candidate.key] {
+				return true
+			}
+
+			listed[candidate.path] = true
+			listedKeys[candidate.key] = true
+			bySize[file.Metadata.Size] = append(bySize[file.Metadata.Size], candidate)
+
+			return true
+		})
+	}
+
+	for size, candidates := range bySize {
+		if len(forceStopChan) > 0 {
+			return []DuplicateGroup{}
+		}
+
+		if len(candidates) < 2 {
+			continue
+		}
+
+		for _, group := range groupByHash(candidates, hashes, false) {
+			// files that aren't larger than the partial hash were already read completely
+			if size <= partialHashSize {
+				output = append(output, newDuplicateGroup(size, group))
+				continue
+			}
+
+			for _, fullGroup := range groupByHash(group, hashes, true) {
+				output = append(output, newDuplicateGroup(size, fullGroup))
+			}
+		}
+	}
+
+	if len(forceStopChan) > 0 {
+		return []DuplicateGroup{}
+	}
+
+	// like the cache, hashes we couldn't write are simply computed again next time
+	writeHashes(hashesPath, hashes)
+
+	slices.SortFunc(output, func(a DuplicateGroup, b DuplicateGroup) int {
+		return cmp.Or(cmp.Compare(b.Size*int64(len(b.Paths)-1), a.Size*int64(len(a.Paths)-1)), cmp.Compare(a.Paths[0], b.Paths[0]))
+	})
+
+	return output
+}
+
+// duplicateExtensions returns the extensions, that should be compared, in the format of the cache. Folders are never compared
+func duplicateExtensions(extensions []string) []string {
+	output := []string{}
+
+	for _, extension := range extensions {
+		extension = strings.ToLower(extension)
+
+		if extension != "" && extension != "folder" && !strings.HasPrefix(extension, ".") {
+			extension = "." + extension
+		}
+
+		if extension != "folder" && !slices.Contains(output, extension) {
+			output = append(output, extension)
+		}
+	}
+
+	return output
+}
+
+// groupByHash groups the candidates by their partial or full hash and returns the groups with more than one file in them. Files we can't read are left out
+func groupByHash(candidates []duplicateCandidate, hashes map[hashKey]*fileHashes, full bool) [][]duplicateCandidate {
+	byHash := make(map[string][]duplicateCandidate)
+	order := []string{}
+
+	for _, candidate := range candidates {
+		hash, err := candidateHash(candidate, hashes, full)
+		if err != nil {
+			continue
+		}
+
+		if _, ok := byHash[hash]; !ok {
+			order = append(order, hash)
+		}
+
+		byHash[hash] = append(byHash[hash], candidate)
+	}
+
+	output := [][]duplicateCandidate{}
+
+	for _, hash := range order {
+		if len(byHash[hash]) > 1 {
+			output = append(output, byHash[hash])
+		}
+	}
+
+	return output
+}
+
+// candidateHash returns the partial or full hash of the candidate, from the hashes if it's in there, otherwise it's computed and added to them
+func candidateHash(candidate duplicateCandidate, hashes map[hashKey]*fileHashes, full bool) (string, error) {
+	// without an inode we can't tell versions of a file apart, so its hashes aren't kept
+	entry, ok := hashes[candidate.key]
+	if !ok || candidate.key.inode == 0 {
+		entry = &fileHashes{}
+	}
+
+	entry.used = true
+
+	if full && entry.full == "" {
+		hash, err := hashFile(candidate.path, -1)
+		if err != nil {
+			return "", err
+		}
+
+		entry.full = hash
+	}
+
+	if !full && entry.partial == "" {
+		hash, err := hashFile(candidate.path, partialHashSize)
+		if err != nil {
+			return "", err
+		}
+
+		entry.partial = hash
+	}
+
+	if candidate.key.inode != 0 {
+		hashes[candidate.key] = entry
+	}
+
+	if full {
+		return entry.full, nil
+	}
+
+	return entry.partial, nil
+}
+
+// hashFile returns the sha256 of the first limit bytes of the file, or of all of it if the limit is negative
+func hashFile(filePath string, limit int64) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("hashFile: couldn't open %s:\n--> %w", filePath, err)
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if limit >= 0 {
+		reader = io.LimitReader(file, limit)
+	}
+
+	hash := sha256.New()
+
+	_, err = io.Copy(hash, reader)
+	if err != nil {
+		return "", fmt.Errorf("hashFile: couldn't read %s:\n--> %w", filePath, err)
+	}
+
+	return string(hash.Sum(nil)), nil
+}
+
+// newDuplicateGroup turns the candidates into a DuplicateGroup with sorted paths
+func newDuplicateGroup(size int64, candidates []duplicateCandidate) DuplicateGroup {
+	group := DuplicateGroup{Size: size, Paths: make([]string, 0, len(candidates))}
+
+	for _, candidate := range candidates {
+		group.Paths = append(group.Paths, candidate.path)
+	}
+
+	slices.Sort(group.Paths)
+
+	return group
+}
+
+// writeHashes writes the hashes in the binary hashes format to hashesPath. If there are more than maxHashes, the ones the last search used are kept first
+func writeHashes(hashesPath string, hashes map[hashKey]*fileHashes) error {
+	keys := make([]hashKey, 0, len(hashes))
+	for key := range hashes {
+		keys = append(keys, key)
+	}
+
+	slices.SortFunc(keys, func(a hashKey, b hashKey) int {
+		if hashes[a].used != hashes[b].used {
+			if hashes[a].used {
+				return -1
+			}
+
+			return 1
+		}
+
+		return cmp.Or(cmp.Compare(a.device, b.device), cmp.Compare(a.inode, b.inode))
+	})
+
+	keys = keys[:min(len(keys), maxHashes)]
+
+	return writeBinary(hashesPath, hashesMagic, hashesVersion, func(cw *cacheWriter) {
+		cw.uint32(uint32(len(keys)))
+
+		for _, key := range keys {
+			cw.uint64(key.device)
+			cw.uint64(key.inode)
+			cw.uint64(uint64(key.modTime))
+			cw.uint64(uint64(key.size))
+			cw.string(hashes[key].partial)
+			cw.string(hashes[key].full)
+		}
+	})
+}
+
+// readHashes reads a binary hashes file. Hashes that can't be read just have to be computed again, so they start out empty
+func readHashes(hashesPath string) map[hashKey]*fileHashes {
+	hashes := make(map[hashKey]*fileHashes)
+
+	err := readBinary(hashesPath, hashesMagic, hashesVersion, func(cr *cacheReader) {
+		hashCount := cr.count(hashRecordSize)
+
+		for range hashCount {
+			key := hashKey{cr.uint64(), cr.uint64(), int64(cr.uint64()), int64(cr.uint64())}
+			entry := fileHashes{partial: cr.string(), full: cr.string()}
+
+			if cr.err != nil {
+				return
+			}
+
+			hashes[key] = &entry
+		}
+	})
+	if err != nil {
+		return make(map[hashKey]*fileHashes)
+	}
+
+	return hashes
+}
+
+// hashesPath returns the path of the hashes file, which is shared by all scopes, as a file can be in more than one of them
+func hashesPath(cacheDir string) string {
+	return filepath.Join(cacheDir, "hashes.bin")
+}
@@ -0,0 +1,25 @@
+// Package cache handles everything that has to do with the generation of the cache for the Search function, to the generation of our folder structure and importing of the config.
+package cache
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestHashesKeepDevices stores the hashes of two files with the same inode on different devices. They're different files, so both have to come back with their own hashes
+func TestHashesKeepDevices(t *testing.T) {
+	hashesPath := filepath.Join(t.TempDir(), "hashes")
+	first := hashKey{device: 1, inode: 42, modTime: 100, size: 10}
+	second := hashKey{device: 2, inode: 42, modTime: 100, size: 10}
+
+	err := writeHashes(hashesPath, map[hashKey]*fileHashes{first: {partial: "a"}, second: {partial: "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hashes := readHashes(hashesPath)
+
+	if len(hashes) != 2 || hashes[first] == nil || hashes[first].partial != "a" || hashes[second] == nil || hashes[second].partial != "b" {
+		t.Fatalf("expected the hashes of both devices, got %v", hashes)
+	}
+}
@@ -3,48 +3,61 @@ package cache
 
 import (
 	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	digitBits       int    = 26 // 0-9 take up the bits after a-z
+	punctuationBits int    = 36 // the rest of ascii shares 4 bits
+	nonASCIIBits    int    = 40 // all other runes are hashed onto the last 24 bits
+	nonASCIIBitSize uint32 = 24
 )
 
-// charMap with all relevant chars and byte position and increase value for bit flip
-var charMap = map[rune][]uint8{
-	'a': {0, 1}, 'b': {0, 2}, 'c': {0, 4}, 'd': {0, 8}, 'e': {0, 16},
-	'f': {0, 32}, 'g': {0, 64}, 'h': {0, 128}, 'i': {1, 1}, 'j': {1, 2},
-	'k': {1, 4}, 'l': {1, 8}, 'm': {1, 16}, 'n': {1, 32}, 'o': {1, 64},
-	'p': {1, 128}, 'q': {2, 1}, 'r': {2, 2}, 's': {2, 4}, 't': {2, 8},
-	'u': {2, 16}, 'v': {2, 32}, 'w': {2, 64}, 'x': {2, 128}, 'y': {3, 1},
-	'z': {3, 2}, '0': {3, 4}, '1': {3, 8}, '2': {3, 16}, '3': {3, 32},
-	'4': {3, 64}, '5': {3, 128}, '6': {4, 1}, '7': {4, 2}, '8': {4, 4},
-	'9': {4, 8}, '!': {4, 16}, '#': {4, 32}, '$': {4, 64}, '%': {4, 128},
-	'&': {5, 1}, '\'': {5, 2}, '(': {5, 4}, ')': {5, 8}, '+': {5, 16},
-	',': {5, 32}, '-': {5, 64}, '.': {5, 128}, ';': {6, 1}, '=': {6, 2},
-	'@': {6, 4}, '[': {6, 8}, ']': {6, 16}, '^': {6, 32}, '_': {6, 64},
-	'`': {6, 128}, '{': {7, 1}, '}': {7, 2}, '~': {7, 4},
+// foldPool holds case folders, as a cases.Caser can't be shared between goroutines
+var foldPool = sync.Pool{
+	New: func() any {
+		caser := cases.Fold()
+		return &caser
+	},
 }
 
 // Encode takes in a string and return an 8 byte array. The array should be viewed as a 64 long bit chain.
-// The first 60 bit depending on, if they're flipped or not indecate, whether a certain character is inside of the origin string (at least once).
-// The 60 characters are all ascii chars (except for upper case letters).
+// Every bit, depending on if it's flipped or not, indecates whether a certain character (or group of characters) is inside of the origin string (at least once).
+// The string is case folded and stripped of its diacritics first, so "Résumé" sets the same bits as "resume". a-z and 0-9 get their own bit, the rest of ascii shares 4 bits
+// and all other runes are hashed onto the remaining 24 bits, so names in other scripts can still be pruned.
 // This allows us to simply compare two byte arrays on, if a string has all the characters as needded, for the search string later on.
 // If that is not the case, we can just skip that string and save having to do a full sub string search
 func Encode(input string) [8]byte {
-	foundChars := make(map[rune]bool)
 	output := [8]byte{}
 
-	for _, char := range strings.ToLower(input) {
-		if _, ok := foundChars[char]; ok {
-			continue
-		}
-
-		// check if the char is on our charMap at all
-		if bitFlipValue, ok := charMap[char]; ok {
-			output[bitFlipValue[0]] += bitFlipValue[1]
-			foundChars[char] = true
-		}
+	for _, char := range Normalize(input, true) {
+		bit := charBit(char)
+		output[bit/8] |= 1 << (bit % 8)
 	}
 
 	return output
 }
 
+// charBit returns the position of the bit for a (normalized) char
+func charBit(char rune) int {
+	switch {
+	case char >= 'a' && char <= 'z':
+		return int(char - 'a')
+	case char >= '0' && char <= '9':
+		return digitBits + int(char-'0')
+	case char < utf8.RuneSelf:
+		return punctuationBits + int(char%4)
+	default:
+		// a multiplicative hash spreads neighbouring runes (like a whole alphabet) over all the bits
+		return nonASCIIBits + int((uint32(char)*2654435761>>16)%nonASCIIBitSize)
+	}
+}
+
 // CompareEncoding checks, if all required letters from the search string are inside the searched string
 func CompareEncoding(searchBytes [8]byte, compareBytes [8]byte) bool {
 	for index := range searchBytes {
@@ -56,3 +69,42 @@ func CompareEncoding(searchBytes [8]byte, compareBytes [8]byte) bool {
 
 	return true
 }
+
+// Normalize returns the form of a name or search string, that's used for matching. It's case folded (so "Straße" becomes "strasse") and, if ignoreDiacritics is set, stripped of its diacritics
+func Normalize(input string, ignoreDiacritics bool) string {
+	if isASCII(input) {
+		return strings.ToLower(input)
+	}
+
+	caser := foldPool.Get().(*cases.Caser)
+	folded := caser.String(input)
+	foldPool.Put(caser)
+
+	if !ignoreDiacritics {
+		return norm.NFC.String(folded)
+	}
+
+	// decomposing splits a char like "é" into "e" and the combining accent, which we can then drop
+	decomposed := norm.NFD.String(folded)
+	stripped := strings.Builder{}
+	stripped.Grow(len(decomposed))
+
+	for _, char := range decomposed {
+		if !unicode.Is(unicode.Mn, char) {
+			stripped.WriteRune(char)
+		}
+	}
+
+	return norm.NFC.String(stripped.String())
+}
+
+// isASCII checks, if the input only consists of ascii characters, for which lowercasing is all the normalization needed
+func isASCII(input string) bool {
+	for index := 0; index < len(input); index++ {
+		if input[index] >= utf8.RuneSelf {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,419 @@
+// Package cache handles everything that has to do with the generation of the cache for the Search function, to the generation of our folder structure and importing of the config.
+package cache
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+Generation is the Index of the memory store at one point in time. Once it's published it's never changed again, updates and the watcher build a new Generation and publish that instead.
+This way a search can keep using the Generation it started with for as long as it takes, without any locks.
+
+dirMap: map[File Extension]map[File Length][]File{encodedName, Name, pathKey, Metadata}
+
+paths: PathTree{pathKey -> parent pathKey + folder name}
+
+stats: map[pathKey]DirStat{modTime, inode}
+*/
+type Generation struct {
+	dirMap   map[string]map[int][]File
+	paths    *PathTree
+	stats    map[int]DirStat
+	trigrams trigramIndex
+}
+
+// memoryStore is the indexStore, that keeps all files in memory as a Generation and writes them to the binary cache file
+type memoryStore struct {
+	cachePath        string
+	current          atomic.Pointer[Generation] // nil while the store isn't loaded
+	generation       atomic.Uint64
+	ignoreDiacritics bool
+	savedGeneration  uint64
+	useTrigrams      bool
+	writeMu          sync.Mutex
+}
+
+// bucketKey identifies a bucket of a DirMap
+type bucketKey struct {
+	extension string
+	length    int
+}
+
+// generationWriter is the storeBatch of the memoryStore, it derives a new Generation from the current one. The parts of it are only copied the first time they change, so the few events of the watcher don't cost a copy of the whole index
+type generationWriter struct {
+	buckets          map[bucketKey]bool // the DirMap buckets, that were copied already
+	extensions       map[string]bool    // the maps of the DirMap, that were copied already
+	generation       *Generation
+	ignoreDiacritics bool
+	pathsCopied      bool
+	statsCopied      bool
+	store            *memoryStore
+	useTrigrams      bool
+}
+
+// Query calls yield for the files with one of the extensions (or any extension, if there are none), whose names are at least minLength bytes long and have all the chars of encoded, until yield returns false.
+// With the trigram index only the files, that contain all trigrams of the query, are looked at
+func (gen *Generation) Query(extensions []string, minLength int, encoded [8]byte, query string, yield func(string, File) bool) error {
+	if len(extensions) == 0 {
+		extensions = slices.Collect(maps.Keys(gen.dirMap))
+	}
+
+	for _, extension := range extensions {
+		if !gen.queryExtension(extension, minLength, encoded, query, yield) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// queryExtension is Query for a single extension. It returns false, once yield did
+func (gen *Generation) queryExtension(extension string, minLength int, encoded [8]byte, query string, yield func(string, File) bool) bool {
+	for length, files := range gen.dirMap[extension] {
+		if length < minLength {
+			continue
+		}
+
+		if candidates, ok := gen.candidates(extension, length, query); ok {
+			for _, position := range candidates {
+				if CompareEncoding(encoded, files[position].EncodedName) && !yield(extension, files[position]) {
+					return false
+				}
+			}
+
+			continue
+		}
+
+		for _, file := range files {
+			if CompareEncoding(encoded, file.EncodedName) && !yield(extension, file) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// Path returns the path of the folder with the pathKey
+func (gen *Generation) Path(pathKey int) string {
+	return gen.paths.Path(pathKey)
+}
+
+// Release does nothing, a Generation is simply dropped by the garbage collector once no search uses it anymore
+func (gen *Generation) Release() {}
+
+// candidates returns the positions of the files in the DirMap bucket, that could contain the normalized query, based on the trigram index.
+// The bool is false, if there is no trigram index or the query is too short for it, in which case the whole bucket has to be searched
+func (gen *Generation) candidates(extension string, length int, query string) ([]int32, bool) {
+	if gen.trigrams == nil {
+		return nil, false
+	}
+
+	return gen.trigrams.candidates(extension, length, query)
+}
+
+// newGeneration turns the cacheData into a Generation and builds the trigram index for it, if it's enabled
+func (store *memoryStore) newGeneration(data cacheData) *Generation {
+	gen := Generation{dirMap: data.dirMap, paths: data.paths, stats: data.stats}
+
+	if store.useTrigrams {
+		gen.trigrams = newTrigramIndex(gen.dirMap, store.ignoreDiacritics)
+	}
+
+	return &gen
+}
+
+// index returns the current Generation, or nil if the store isn't loaded
+func (store *memoryStore) index() Index {
+	// a nil *Generation in an Index wouldn't be nil anymore
+	gen := store.current.Load()
+	if gen == nil {
+		return nil
+	}
+
+	return gen
+}
+
+// load imports the cache file from the disk as the current Generation
+func (store *memoryStore) load() error {
+	data, err := readCache(store.cachePath)
+	if err != nil {
+		store.current.Store(store.newGeneration(cacheData{make(map[string]map[int][]File), newPathTree(), make(map[int]DirStat)}))
+		return fmt.Errorf("load: couldn't read cache file %s:\n--> %w", store.cachePath, err)
+	}
+
+	store.current.Store(store.newGeneration(*data))
+
+	return nil
+}
+
+// loaded checks, if there is a current Generation
+func (store *memoryStore) loaded() bool {
+	return store.current.Load() != nil
+}
+
+// unload drops the current Generation
+func (store *memoryStore) unload() {
+	store.current.Store(nil)
+}
+
+// memoryUpdate is the storeUpdate of the memoryStore, it builds the cacheData of the next Generation
+type memoryUpdate struct {
+	data  cacheData
+	store *memoryStore
+}
+
+// snapshot groups the entries of the current Generation by their folder, or the ones of the cache file, if the store isn't loaded
+func (store *memoryStore) snapshot() previousIndex {
+	// without a readable cache there's nothing to reuse, so everything gets read from the disk
+	data, err := store.data()
+	if err != nil {
+		return make(snapshotMap)
+	}
+
+	return newSnapshotMap(data)
+}
+
+// update returns a memoryUpdate, that starts out empty
+func (store *memoryStore) update() storeUpdate {
+	return &memoryUpdate{data: cacheData{make(map[string]map[int][]File), newPathTree(), make(map[int]DirStat)}, store: store}
+}
+
+// replace writes the data to the cache file and makes it the current Generation, if the store is loaded
+func (store *memoryStore) replace(data cacheData) {
+	go store.save(data, store.generation.Add(1))
+
+	if store.loaded() {
+		store.current.Store(store.newGeneration(data))
+	}
+}
+
+// data returns the current Generation as cacheData, or reads it from the cache file, if the store isn't loaded
+func (store *memoryStore) data() (*cacheData, error) {
+	if gen := store.current.Load(); gen != nil {
+		return &cacheData{gen.dirMap, gen.paths, gen.stats}, nil
+	}
+
+	return readCache(store.cachePath)
+}
+
+// batch returns a generationWriter for the current Generation
+func (store *memoryStore) batch() storeBatch {
+	current := store.current.Load()
+
+	return &generationWriter{
+		buckets:    make(map[bucketKey]bool),
+		extensions: make(map[string]bool),
+		generation: &Generation{
+			dirMap:   maps.Clone(current.dirMap),
+			paths:    current.paths,
+			stats:    current.stats,
+			trigrams: current.trigrams,
+		},
+		ignoreDiacritics: store.ignoreDiacritics,
+		store:            store,
+		useTrigrams:      current.trigrams != nil,
+	}
+}
+
+// commit rebuilds the trigram index of the buckets that changed and publishes the new Generation. The writer can't be used afterwards
+func (writer *generationWriter) commit() error {
+	gen := writer.generation
+
+	if writer.useTrigrams && len(writer.buckets) > 0 {
+		gen.trigrams = maps.Clone(gen.trigrams)
+		copiedExtensions := make(map[string]bool)
+
+		for key := range writer.buckets {
+			if !copiedExtensions[key.extension] {
+				gen.trigrams[key.extension] = maps.Clone(gen.trigrams[key.extension])
+				copiedExtensions[key.extension] = true
+			}
+
+			gen.trigrams.indexBucket(key.extension, key.length, gen.dirMap[key.extension][key.length], writer.ignoreDiacritics)
+		}
+	}
+
+	writer.store.current.Store(gen)
+
+	return nil
+}
+
+// bucket returns a copy of the DirMap bucket, that belongs to the new Generation and can be changed
+func (writer *generationWriter) bucket(extension string, length int) []File {
+	dirMap := writer.generation.dirMap
+
+	if !writer.extensions[extension] {
+		lengths := maps.Clone(dirMap[extension])
+		if lengths == nil {
+			lengths = make(map[int][]File)
+		}
+
+		dirMap[extension] = lengths
+		writer.extensions[extension] = true
+	}
+
+	key := bucketKey{extension, length}
+
+	if !writer.buckets[key] {
+		dirMap[extension][length] = slices.Clone(dirMap[extension][length])
+		writer.buckets[key] = true
+	}
+
+	return dirMap[extension][length]
+}
+
+// paths returns a copy of the PathTree, that belongs to the new Generation and can be changed
+func (writer *generationWriter) paths() *PathTree {
+	if !writer.pathsCopied {
+		writer.generation.paths = writer.generation.paths.clone()
+		writer.pathsCopied = true
+	}
+
+	return writer.generation.paths
+}
+
+// stats returns a copy of the Stats, that belongs to the new Generation and can be changed
+func (writer *generationWriter) stats() map[int]DirStat {
+	if !writer.statsCopied {
+		writer.generation.stats = maps.Clone(writer.generation.stats)
+		writer.statsCopied = true
+	}
+
+	return writer.generation.stats
+}
+
+// insert adds an item to the new Generation, or updates its Metadata if it's already on there. It returns the item as added or modified, or nothing if it didn't change
+func (writer *generationWriter) insert(item basicFile) []changedFile {
+	itemExtension := strings.ToLower(item.extension)
+
+	// the PathTree is only copied, if the folder is new to it
+	pathKey := writer.generation.paths.key(item.path, false)
+	if pathKey < 0 {
+		pathKey = writer.paths().key(item.path, true)
+	}
+
+	// an item we already know only gets its Metadata refreshed
+	for index, file := range writer.generation.dirMap[itemExtension][len(item.name)] {
+		if file.PathKey != pathKey || file.Name != item.name {
+			continue
+		}
+
+		if item.isFolder || file.Metadata.ModTime == item.metadata.ModTime && file.Metadata.Size == item.metadata.Size {
+			if file.Metadata != item.metadata {
+				writer.bucket(itemExtension, len(item.name))[index].Metadata = item.metadata
+			}
+
+			return nil
+		}
+
+		writer.bucket(itemExtension, len(item.name))[index].Metadata = item.metadata
+
+		return []changedFile{{item, ChangeModified}}
+	}
+
+	// the bucket has to be copied, before we can look up the map to store it in
+	files := writer.bucket(itemExtension, len(item.name))
+	writer.generation.dirMap[itemExtension][len(item.name)] = append(files, File{Encode(item.name), item.name, pathKey, item.metadata})
+
+	return []changedFile{{item, ChangeAdded}}
+}
+
+// delete removes an item from the new Generation. For folders this also removes everything inside of them. It returns everything it removed as deleted
+func (writer *generationWriter) delete(item basicFile) []changedFile {
+	gen := writer.generation
+
+	pathKey := gen.paths.key(item.path, false)
+	if pathKey < 0 {
+		return nil
+	}
+
+	if !item.isFolder {
+		itemExtension := strings.ToLower(item.extension)
+
+		for index, file := range gen.dirMap[itemExtension][len(item.name)] {
+			if file.PathKey == pathKey && file.Name == item.name {
+				files := writer.bucket(itemExtension, len(item.name))
+				gen.dirMap[itemExtension][len(item.name)] = slices.Delete(files, index, index+1)
+
+				// the event doesn't know the inode of a file that's gone, but the cache does
+				item.metadata = file.Metadata
+				return []changedFile{{item, ChangeDeleted}}
+			}
+		}
+
+		return nil
+	}
+
+	removedKeys := gen.paths.subtree(pathKey)
+	removedPaths := make(map[int]string, len(removedKeys))
+	stats := writer.stats()
+
+	for key := range removedKeys {
+		removedPaths[key] = gen.paths.Path(key)
+		delete(stats, key)
+	}
+
+	writer.paths().remove(removedKeys)
+	changes := []changedFile{}
+
+	for extension, lengths := range gen.dirMap {
+		for length, files := range lengths {
+			removed := func(file File) bool {
+				return removedKeys[file.PathKey]
+			}
+
+			if !slices.ContainsFunc(files, removed) {
+				continue
+			}
+
+			for _, file := range files {
+				if removed(file) {
+					changes = append(changes, changedFile{basicFile{extension, extension == "folder", file.Name, removedPaths[file.PathKey], file.Metadata}, ChangeDeleted})
+				}
+			}
+
+			files = writer.bucket(extension, length)
+			gen.dirMap[extension][length] = slices.DeleteFunc(files, removed)
+		}
+	}
+
+	return changes
+}
+
+// add puts the item into the DirMap bucket of its extension and name length
+func (update *memoryUpdate) add(item basicFile) {
+	itemExtension := strings.ToLower(item.extension)
+	dirMap := update.data.dirMap
+
+	if _, ok := dirMap[itemExtension]; !ok {
+		dirMap[itemExtension] = make(map[int][]File)
+	}
+
+	dirMap[itemExtension][len(item.name)] = append(dirMap[itemExtension][len(item.name)], File{Encode(item.name), item.name, update.data.paths.key(item.path, true), item.metadata})
+}
+
+// addStats stores the DirStats under the keys of their folders
+func (update *memoryUpdate) addStats(stats map[string]DirStat) {
+	for dirPath, stat := range stats {
+		update.data.stats[update.data.paths.key(dirPath, true)] = stat
+	}
+}
+
+// discard drops the cacheData
+func (update *memoryUpdate) discard() {
+	update.data = cacheData{}
+}
+
+// publish makes the cacheData the current Generation and writes it to the cache file
+func (update *memoryUpdate) publish() error {
+	update.data.paths.compact()
+	update.store.replace(update.data)
+
+	return nil
+}
@@ -0,0 +1,270 @@
+// Package cache handles everything that has to do with the generation of the cache for the Search function, to the generation of our folder structure and importing of the config.
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignoreRule is a single pattern from an ignore file, compiled into a regex that matches paths relative to the folder of the ignore file
+type ignoreRule struct {
+	dirOnly bool
+	negate  bool
+	regex   *regexp.Regexp
+}
+
+/*
+ignoreList holds the rules from the ignore files of a folder and points to the list of the folder above it, so every folder only stores its own rules.
+Folders without ignore files simply share the list of the folder above them.
+
+The fingerprint identifies all rules that apply to a folder (including the ones from the config), so a folder is read again, once they change.
+*/
+type ignoreList struct {
+	dir         string
+	fingerprint uint64
+	parent      *ignoreList
+	rules       []ignoreRule
+}
+
+// fingerprintRules hashes the rules from the config, that decide what's in a Dirs
+func fingerprintRules(rules ...any) uint64 {
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%v", rules)
+
+	return hash.Sum64()
+}
+
+// readIgnoreList returns the ignoreList for dirPath, by reading the ignore files in it and adding them onto the parent list. Without a parent the list starts with the rules from the config
+func (fs *Filesystem) readIgnoreList(dirPath string, parent *ignoreList, dirs *Dirs) *ignoreList {
+	list := ignoreList{dir: dirPath, parent: parent}
+
+	hash := fnv.New64a()
+	if parent != nil {
+		binary.Write(hash, binary.LittleEndian, parent.fingerprint)
+	} else {
+		binary.Write(hash, binary.LittleEndian, dirs.rulesFingerprint)
+	}
+
+	for _, ignoreFile := range fs.ignoreFiles {
+		content, err := os.ReadFile(filepath.Join(dirPath, ignoreFile))
+		// most folders don't have any ignore files
+		if err != nil {
+			continue
+		}
+
+		fmt.Fprintf(hash, "%s\x00%s\x00", ignoreFile, content)
+		list.rules = append(list.rules, parseIgnoreFile(content)...)
+	}
+
+	if parent != nil && len(list.rules) == 0 {
+		return parent
+	}
+
+	list.fingerprint = hash.Sum64()
+
+	return &list
+}
+
+// ignoreList takes the ignoreList the folder above left for dirPath and adds the ignore files of dirPath onto it.
+// With cachedOnly the folder isn't touched, so its ignore files aren't read either
+func (t *traversal) ignoreList(fs *Filesystem, dirPath string, cachedOnly bool) *ignoreList {
+	t.ignoresMu.Lock()
+	parent := t.ignores[dirPath]
+	delete(t.ignores, dirPath)
+	t.ignoresMu.Unlock()
+
+	if !cachedOnly {
+		return fs.readIgnoreList(dirPath, parent, t.dirs)
+	}
+
+	if parent != nil {
+		return parent
+	}
+
+	return &ignoreList{dir: dirPath, fingerprint: t.dirs.rulesFingerprint}
+}
+
+// ignoreListFor builds the ignoreList of dirPath from scratch, by reading the ignore files from its base dir down to it. It's meant for the watcher, which doesn't have the lists of the crawl
+func (fs *Filesystem) ignoreListFor(dirPath string, dirs *Dirs) *ignoreList {
+	baseDir := ""
+
+	dirs.baseDirsMu.Lock()
+	for dir := range dirs.BaseDirs {
+		if strings.HasPrefix(dirPath, dir) && len(dir) > len(baseDir) {
+			baseDir = dir
+		}
+	}
+	dirs.baseDirsMu.Unlock()
+
+	if baseDir == "" {
+		return fs.readIgnoreList(dirPath, nil, dirs)
+	}
+
+	list := fs.readIgnoreList(baseDir, nil, dirs)
+	currentDir := baseDir
+
+	for _, segment := range strings.Split(strings.TrimSuffix(strings.TrimPrefix(dirPath, baseDir), string(filepath.Separator)), string(filepath.Separator)) {
+		if segment == "" {
+			continue
+		}
+
+		currentDir = fmt.Sprintf("%s%s%s", currentDir, segment, string(filepath.Separator))
+		list = fs.readIgnoreList(currentDir, list, dirs)
+	}
+
+	return list
+}
+
+// ignored checks, if the item is ignored by any of the rules. Like git, the last matching rule wins, which means the rules of deeper folders win over the ones above them
+func (list *ignoreList) ignored(item basicFile) bool {
+	itemPath := strings.TrimSuffix(item.path, string(filepath.Separator))
+	if !item.isFolder {
+		itemPath = filepath.Join(item.path, item.name+item.extension)
+	}
+
+	for current := list; current != nil; current = current.parent {
+		relativePath, ok := strings.CutPrefix(itemPath, current.dir)
+		if !ok {
+			continue
+		}
+
+		for index := len(current.rules) - 1; index >= 0; index-- {
+			rule := current.rules[index]
+
+			if rule.dirOnly && !item.isFolder {
+				continue
+			}
+
+			if rule.regex.MatchString(relativePath) {
+				return !rule.negate
+			}
+		}
+	}
+
+	return false
+}
+
+// parseIgnoreFile compiles the patterns of an ignore file, patterns that can't be compiled are skipped
+func parseIgnoreFile(content []byte) []ignoreRule {
+	rules := []ignoreRule{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreLine(scanner.Text()); ok {
+			rules = append(rules, rule)
+		}
+	}
+
+	return rules
+}
+
+/*
+parseIgnoreLine compiles a single line of an ignore file, following the gitignore semantics:
+
+"#" starts a comment and "!" negates the pattern, unless they're escaped with a "\".
+A trailing "/" only matches folders.
+A "/" at the start or in the middle anchors the pattern to the folder of the ignore file, otherwise it matches at any depth.
+"*" and "?" don't match a "/", while "**" matches any amount of folders.
+*/
+func parseIgnoreLine(line string) (ignoreRule, bool) {
+	line = strings.TrimSuffix(line, "\r")
+
+	// trailing spaces are ignored, unless they're escaped
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = strings.TrimSuffix(line, " ")
+	}
+
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	rule := ignoreRule{}
+
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+
+	if line == "" {
+		return ignoreRule{}, false
+	}
+
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	pattern := strings.Builder{}
+	if anchored {
+		pattern.WriteString("^")
+	} else {
+		pattern.WriteString("^(?:.*/)?")
+	}
+
+	pattern.WriteString(ignoreGlobRegex(line))
+	pattern.WriteString("$")
+
+	regex, err := regexp.Compile(pattern.String())
+	if err != nil {
+		return ignoreRule{}, false
+	}
+
+	rule.regex = regex
+
+	return rule, true
+}
+
+// ignoreGlobRegex converts the glob of an ignore pattern into a regex
+func ignoreGlobRegex(glob string) string {
+	output := strings.Builder{}
+
+	for index := 0; index < len(glob); index++ {
+		atSegmentStart := index == 0 || glob[index-1] == '/'
+
+		switch {
+		case atSegmentStart && strings.HasPrefix(glob[index:], "**/"):
+			output.WriteString("(?:.*/)?")
+			index += 2
+		case atSegmentStart && glob[index:] == "**":
+			output.WriteString(".*")
+			index++
+		case glob[index] == '*':
+			output.WriteString("[^/]*")
+		case glob[index] == '?':
+			output.WriteString("[^/]")
+		case glob[index] == '\\' && index+1 < len(glob):
+			index++
+			output.WriteString(regexp.QuoteMeta(glob[index : index+1]))
+		case glob[index] == '[':
+			end := strings.Index(glob[index+1:], "]")
+			if end < 0 {
+				output.WriteString(`\[`)
+				continue
+			}
+
+			class := glob[index+1 : index+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+
+			output.WriteString("[")
+			output.WriteString(strings.ReplaceAll(class, `\`, `\\`))
+			output.WriteString("]")
+			index += end + 1
+		default:
+			output.WriteString(regexp.QuoteMeta(glob[index : index+1]))
+		}
+	}
+
+	return output.String()
+}
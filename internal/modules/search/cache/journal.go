@@ -0,0 +1,393 @@
+// Package cache handles everything that has to do with the generation of the cache for the Search function, to the generation of our folder structure and importing of the config.
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+The journal file uses the same header as the cache file, with its own magic. The body is laid out as follows:
+
+changes: count uint32 | count * (kind uint32 | time int64 | path string | old path string)
+*/
+const (
+	journalMagic      string        = "BLTJ"
+	journalVersion    uint16        = 1
+	changeRecordSize  int           = 14
+	maxJournalChanges int           = 20000 // the oldest changes are dropped first
+	maxJournalAge     time.Duration = 30 * 24 * time.Hour
+	journalSaveDelay  time.Duration = 10 * time.Second // the watcher can report a lot of changes in a short time, so they're written together
+)
+
+// ChangeKind is what happened to a file in a Change
+type ChangeKind uint32
+
+const (
+	ChangeAdded ChangeKind = iota
+	ChangeModified
+	ChangeDeleted
+	ChangeRenamed
+)
+
+// Change is a single entry of the journal. Folders have their path with a trailing separator, like in the search results
+type Change struct {
+	Kind    ChangeKind
+	OldPath string // only set for ChangeRenamed
+	Path    string
+	Time    int64 // in nanoseconds since the unix epoch
+}
+
+// changedFile is a file the diff of an update or the watcher found, before renames are paired up and it becomes a Change
+type changedFile struct {
+	item basicFile
+	kind ChangeKind
+}
+
+/*
+journal holds the changes to the files of a Dirs, newest last. It's kept in memory even if the cache isn't imported, as it's small and both updates and the watcher add to it.
+
+latest: map[Path]sequence number of the last Change of the path, the sequence number minus dropped is the position in changes
+*/
+type journal struct {
+	changes   []Change
+	dropped   int
+	latest    map[string]int
+	mu        sync.Mutex
+	path      string
+	saveTimer *time.Timer
+}
+
+// loadJournal imports the journal file from the disk. A journal that can't be read starts out empty
+func loadJournal(journalPath string) *journal {
+	j := journal{latest: make(map[string]int), path: journalPath}
+
+	changes, err := readJournal(journalPath)
+	if err != nil {
+		return &j
+	}
+
+	for _, change := range changes {
+		j.append(change)
+	}
+
+	return &j
+}
+
+// record adds the changes to the journal. Changes of the watcher are written to the disk a little later, together with the ones that follow them, the ones of an update right away
+func (j *journal) record(changes []Change, fromWatcher bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, change := range changes {
+		j.append(change)
+	}
+
+	j.trim(time.Now())
+
+	if !fromWatcher {
+		j.save()
+		return
+	}
+
+	if j.saveTimer == nil {
+		j.saveTimer = time.AfterFunc(journalSaveDelay, func() {
+			j.mu.Lock()
+			defer j.mu.Unlock()
+
+			j.saveTimer = nil
+			j.save()
+		})
+	}
+}
+
+// append adds a single Change to the end of the journal. A Change the journal already ends with for the path is skipped, as an update can find what the watcher already reported, if the cache wasn't imported.
+// A file that's modified again only gets the time of its last Change refreshed, so a file that's written to all the time doesn't push everything else out
+func (j *journal) append(change Change) {
+	if sequence, ok := j.latest[change.Path]; ok {
+		last := &j.changes[sequence-j.dropped]
+
+		if last.Kind == change.Kind && last.OldPath == change.OldPath && change.Kind != ChangeModified {
+			return
+		}
+
+		if change.Kind == ChangeModified && last.Kind != ChangeDeleted {
+			last.Time = max(last.Time, change.Time)
+			return
+		}
+	}
+
+	j.latest[change.Path] = j.dropped + len(j.changes)
+	j.changes = append(j.changes, change)
+}
+
+// trim drops the changes that are older than the maxJournalAge or don't fit into the maxJournalChanges anymore
+func (j *journal) trim(now time.Time) {
+	cutoff := now.Add(-maxJournalAge).UnixNano()
+	drop := max(len(j.changes)-maxJournalChanges, 0)
+
+	for drop < len(j.changes) && j.changes[drop].Time < cutoff {
+		drop++
+	}
+
+	for index, change := range j.changes[:drop] {
+		if j.latest[change.Path] == j.dropped+index {
+			delete(j.latest, change.Path)
+		}
+	}
+
+	j.changes = append([]Change{}, j.changes[drop:]...)
+	j.dropped += drop
+}
+
+// flush writes the changes of the watcher, that are still waiting for their save
+func (j *journal) flush() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.saveTimer != nil && j.saveTimer.Stop() {
+		j.saveTimer = nil
+		j.save()
+	}
+}
+
+// save writes the journal to the disk, it's meant to be called while holding the mu. Like the cache, a journal we couldn't write only misses the latest changes
+func (j *journal) save() {
+	writeJournal(j.path, j.changes)
+}
+
+// Changes returns the changes of the Dirs with one of the kinds, that happened after since, newest first
+func (dirs *Dirs) Changes(kinds []ChangeKind, since time.Time) []Change {
+	j := dirs.journal
+	output := []Change{}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for index := len(j.changes) - 1; index >= 0; index-- {
+		change := j.changes[index]
+
+		if change.Time < since.UnixNano() {
+			continue
+		}
+
+		for _, kind := range kinds {
+			if change.Kind == kind {
+				output = append(output, change)
+				break
+			}
+		}
+	}
+
+	return output
+}
+
+// diff compares the entries a folder has now with the ones it had on the last update and adds the differences to the changes of the traversal.
+// The files in folders that were removed are counted as deleted as well. Nothing is compared on the first update, as everything would be new
+func (t *traversal) diff(dirPath string, entries []basicFile) {
+	if t.previous.empty() {
+		return
+	}
+
+	// the cache only knows the lower case extensions, so that's what we compare
+	key := func(item basicFile) string {
+		return fmt.Sprintf("%s%s%s", item.path, item.name, strings.ToLower(item.extension))
+	}
+
+	previousEntries := make(map[string]basicFile)
+	if previous, ok := t.previous.lookup(dirPath); ok {
+		for _, item := range previous.entries {
+			previousEntries[key(item)] = item
+		}
+	}
+
+	changes := []changedFile{}
+
+	for _, item := range entries {
+		previousItem, ok := previousEntries[key(item)]
+		delete(previousEntries, key(item))
+
+		switch {
+		case !ok:
+			changes = append(changes, changedFile{item, ChangeAdded})
+		case !item.isFolder && (item.metadata.ModTime != previousItem.metadata.ModTime || item.metadata.Size != previousItem.metadata.Size):
+			changes = append(changes, changedFile{item, ChangeModified})
+		}
+	}
+
+	for _, item := range previousEntries {
+		changes = append(changes, t.deleted(item)...)
+	}
+
+	if len(changes) == 0 {
+		return
+	}
+
+	t.statsMu.Lock()
+	t.changes = append(t.changes, changes...)
+	t.statsMu.Unlock()
+}
+
+// deleted returns the item as a deleted changedFile, folders together with everything that was inside of them on the last update
+func (t *traversal) deleted(item basicFile) []changedFile {
+	changes := []changedFile{{item, ChangeDeleted}}
+
+	if previous, ok := t.previous.lookup(item.path); ok && item.isFolder {
+		for _, child := range previous.entries {
+			changes = append(changes, t.deleted(child)...)
+		}
+	}
+
+	return changes
+}
+
+// journalChanges turns the changedFiles into Changes. A deleted and an added file with the same inode were renamed, and inside of a renamed folder only the folder itself is listed.
+// Added and modified files get their ModTime as the time of the Change, as long as it's between since and now, the others happened at some point before now
+func journalChanges(files []changedFile, since time.Time, now time.Time) []Change {
+	deletedInodes := make(map[[2]uint64]int) // device and inode -> position of the deleted file, as inodes are only unique on their device
+
+	for index, file := range files {
+		if file.kind != ChangeDeleted || file.item.metadata.Inode == 0 {
+			continue
+		}
+
+		id := [2]uint64{file.item.metadata.Device, file.item.metadata.Inode}
+
+		// hard links share their inode, so we can't tell which of them was renamed
+		if _, ok := deletedInodes[id]; ok {
+			deletedInodes[id] = -1
+			continue
+		}
+
+		deletedInodes[id] = index
+	}
+
+	renamedFrom := make(map[int]int) // position of the added file -> position of the deleted one
+	renamedFolders := make(map[string]string)
+
+	for index, file := range files {
+		id := [2]uint64{file.item.metadata.Device, file.item.metadata.Inode}
+
+		deletedIndex, ok := deletedInodes[id]
+		if file.kind != ChangeAdded || !ok || deletedIndex < 0 || files[deletedIndex].item.isFolder != file.item.isFolder {
+			continue
+		}
+
+		renamedFrom[index] = deletedIndex
+		delete(deletedInodes, id)
+
+		if file.item.isFolder {
+			renamedFolders[files[deletedIndex].item.path] = file.item.path
+		}
+	}
+
+	renamedTo := make(map[int]bool)
+	for _, deletedIndex := range renamedFrom {
+		renamedTo[deletedIndex] = true
+	}
+
+	// an update without stats from the last one doesn't know when it was, so the ModTime is all we have
+	lowest := int64(math.MinInt64)
+	if !since.IsZero() {
+		lowest = since.UnixNano()
+	}
+
+	output := []Change{}
+
+	for index, file := range files {
+		change := Change{Kind: file.kind, Path: file.item.fullPath(), Time: now.UnixNano()}
+
+		if renamedTo[index] {
+			continue
+		}
+
+		if deletedIndex, ok := renamedFrom[index]; ok {
+			change.Kind, change.OldPath = ChangeRenamed, files[deletedIndex].item.fullPath()
+
+			// the folder it was in got renamed, which already covers it
+			if renamedFolders[files[deletedIndex].item.dir()] == file.item.dir() {
+				continue
+			}
+		}
+
+		if change.Kind == ChangeAdded || change.Kind == ChangeModified {
+			change.Time = min(max(file.item.metadata.ModTime, lowest), now.UnixNano())
+		}
+
+		output = append(output, change)
+	}
+
+	return output
+}
+
+// fullPath returns the absolute path of the item, folders already have it as their path
+func (item *basicFile) fullPath() string {
+	if item.isFolder {
+		return item.path
+	}
+
+	return fmt.Sprintf("%s%s%s", item.path, item.name, item.extension)
+}
+
+// dir returns the path of the folder the item is in
+func (item *basicFile) dir() string {
+	if item.isFolder {
+		return parentDir(item.path)
+	}
+
+	return item.path
+}
+
+// writeJournal writes the changes in the binary journal format to journalPath
+func writeJournal(journalPath string, changes []Change) error {
+	return writeBinary(journalPath, journalMagic, journalVersion, func(cw *cacheWriter) {
+		cw.uint32(uint32(len(changes)))
+
+		for _, change := range changes {
+			cw.uint32(uint32(change.Kind))
+			cw.uint64(uint64(change.Time))
+			cw.string(change.Path)
+			cw.string(change.OldPath)
+		}
+	})
+}
+
+// readJournal reads a binary journal file
+func readJournal(journalPath string) ([]Change, error) {
+	changes := []Change{}
+
+	err := readBinary(journalPath, journalMagic, journalVersion, func(cr *cacheReader) {
+		changeCount := cr.count(changeRecordSize)
+		changes = make([]Change, 0, changeCount)
+
+		for range changeCount {
+			change := Change{Kind: ChangeKind(cr.uint32()), Time: int64(cr.uint64())}
+			change.Path, change.OldPath = cr.string(), cr.string()
+
+			if cr.err == nil && change.Kind > ChangeRenamed {
+				cr.err = errors.New("journal has a change of an unknown kind")
+			}
+
+			if cr.err != nil {
+				return
+			}
+
+			changes = append(changes, change)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("readJournal: couldn't read journal file:\n--> %w", err)
+	}
+
+	return changes, nil
+}
+
+// journalPath returns the path of the journal file of a scope
+func journalPath(cacheDir string, name string) string {
+	return filepath.Join(cacheDir, fmt.Sprintf("%s_journal.bin", name))
+}
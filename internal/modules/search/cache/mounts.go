@@ -0,0 +1,322 @@
+// Package cache handles everything that has to do with the generation of the cache for the Search function, to the generation of our folder structure and importing of the config.
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/skillptm/Bolt/internal/config"
+)
+
+const (
+	mountInfoPath string = "/proc/self/mountinfo"
+	uuidLinksDir  string = "/dev/disk/by-uuid"
+	labelLinksDir string = "/dev/disk/by-label"
+)
+
+// mountPolicy tells traverse how to handle the folders on a mount
+type mountPolicy int
+
+const (
+	indexMount    mountPolicy = iota // crawled and watched like any other folder
+	onDemandMount                    // only crawled on a forced update, otherwise the cached entries are kept
+	skipMount                        // never crawled
+	volumeMount                      // a removable drive, which is crawled and watched by its own Dirs, see volumes.go
+)
+
+// mountRules holds the filesystem type patterns from the config, which decide the mountPolicy of a mount, and the folders removable drives are mounted below
+type mountRules struct {
+	index      []string
+	onDemand   []string
+	skip       []string
+	volumeDirs []string // nil if removable drives are crawled like any other mount
+}
+
+/*
+mountTable holds the mounts from /proc/self/mountinfo together with the mountPolicy their filesystem type has.
+Without a readable mountinfo the table is empty and everything gets indexed, like it did before mounts were taken into account.
+
+mountTable: map[Mount Point]mountPolicy
+*/
+type mountTable map[string]mountPolicy
+
+// newMountRules converts the FilesystemTypes and RemovableMedia from the config into mountRules
+func newMountRules(types *config.FilesystemTypes, media config.RemovableMedia) mountRules {
+	rules := mountRules{}
+
+	if types != nil {
+		rules = mountRules{index: types.Index, onDemand: types.OnDemand, skip: types.Skip}
+	}
+
+	if !media.Enabled {
+		return rules
+	}
+
+	for _, dir := range media.Dirs {
+		if !strings.HasSuffix(dir, string(filepath.Separator)) {
+			dir += string(filepath.Separator)
+		}
+
+		rules.volumeDirs = append(rules.volumeDirs, dir)
+	}
+
+	return rules
+}
+
+// policy returns the mountPolicy for a filesystem type. Skip wins over OnDemand, which wins over Index and an empty Index means every other type is indexed
+func (mr *mountRules) policy(fsType string) mountPolicy {
+	if matchesAny(mr.skip, fsType) {
+		return skipMount
+	}
+
+	if matchesAny(mr.onDemand, fsType) {
+		return onDemandMount
+	}
+
+	if len(mr.index) > 0 && !matchesAny(mr.index, fsType) {
+		return skipMount
+	}
+
+	return indexMount
+}
+
+// matchesAny checks, if the fsType matches any of the patterns, which may use globs like "fuse.*"
+func matchesAny(patterns []string, fsType string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, fsType); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newMountTable reads the current mounts and assigns them their mountPolicy based on the mountRules
+func newMountTable(rules *mountRules) mountTable {
+	table, _ := readMounts(rules)
+	return table
+}
+
+// readMounts returns the mountTable of the current mounts, together with the volumes that are mounted right now by their UUID
+func readMounts(rules *mountRules) (mountTable, map[string]volumeInfo) {
+	table := make(mountTable)
+	volumes := make(map[string]volumeInfo)
+
+	mountInfo, err := os.Open(mountInfoPath)
+	if err != nil {
+		return table, volumes
+	}
+	defer mountInfo.Close()
+
+	// the links are only read, once there is a mount that could be a volume
+	var uuids, labels map[string]string
+
+	scanner := bufio.NewScanner(mountInfo)
+
+	for scanner.Scan() {
+		mountPoint, fsType, source, ok := parseMountInfoLine(scanner.Text())
+		if !ok {
+			continue
+		}
+
+		// later mounts on the same point hide the earlier ones, so they overwrite them here as well
+		table[mountPoint] = rules.policy(fsType)
+
+		if table[mountPoint] != indexMount || !rules.onVolumeDir(mountPoint) {
+			continue
+		}
+
+		if uuids == nil {
+			uuids, labels = diskLinks(uuidLinksDir), diskLinks(labelLinksDir)
+		}
+
+		// mounts without a filesystem UUID, like tmpfs or a network share in /mnt, can't be told apart once they're gone
+		device, err := filepath.EvalSymlinks(source)
+		if err != nil || uuids[device] == "" {
+			continue
+		}
+
+		table[mountPoint] = volumeMount
+
+		// a drive that's mounted more than once only gets indexed at the first of its mount points
+		if _, ok := volumes[uuids[device]]; ok {
+			continue
+		}
+
+		name := labels[device]
+		if name == "" {
+			name = filepath.Base(mountPoint)
+		}
+
+		volumes[uuids[device]] = volumeInfo{MountPoint: mountPoint, Name: name, UUID: uuids[device]}
+	}
+
+	return table, volumes
+}
+
+// onVolumeDir checks, if the mount point is below one of the folders removable drives are mounted in
+func (mr *mountRules) onVolumeDir(mountPoint string) bool {
+	for _, dir := range mr.volumeDirs {
+		if strings.HasPrefix(mountPoint, dir) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// diskLinks returns the names of the links in a /dev/disk folder by the device they point to. udev escapes the chars that can't be in a file name, like \x20 for a space
+func diskLinks(linksDir string) map[string]string {
+	links := make(map[string]string)
+
+	dirEntries, err := os.ReadDir(linksDir)
+	if err != nil {
+		return links
+	}
+
+	for _, dirEntry := range dirEntries {
+		device, err := filepath.EvalSymlinks(filepath.Join(linksDir, dirEntry.Name()))
+		if err != nil {
+			continue
+		}
+
+		links[device] = unescapeDiskLink(dirEntry.Name())
+	}
+
+	return links
+}
+
+/*
+parseMountInfoLine returns the mount point (with a trailing separator like all the folder paths in the cache), the filesystem type and the source of a line from the mountinfo.
+
+The format of a line is:
+
+<mount ID> <parent ID> <major:minor> <root> <mount point> <mount options> [optional fields...] - <filesystem type> <source> <super options>
+*/
+func parseMountInfoLine(line string) (string, string, string, bool) {
+	fields := strings.Fields(line)
+
+	separator := -1
+	for index, field := range fields {
+		if field == "-" {
+			separator = index
+			break
+		}
+	}
+
+	if len(fields) < 5 || separator < 0 || separator+2 >= len(fields) {
+		return "", "", "", false
+	}
+
+	mountPoint := unescapeMountInfo(fields[4])
+	if !strings.HasSuffix(mountPoint, string(filepath.Separator)) {
+		mountPoint += string(filepath.Separator)
+	}
+
+	return mountPoint, fields[separator+1], unescapeMountInfo(fields[separator+2]), true
+}
+
+// unescapeMountInfo decodes the octal escapes (like \040 for a space) the kernel uses for whitespace and backslashes in mountinfo paths
+func unescapeMountInfo(input string) string {
+	if !strings.Contains(input, `\`) {
+		return input
+	}
+
+	output := strings.Builder{}
+
+	for index := 0; index < len(input); index++ {
+		if input[index] == '\\' && index+3 < len(input) {
+			if char, err := strconv.ParseUint(input[index+1:index+4], 8, 8); err == nil {
+				output.WriteByte(byte(char))
+				index += 3
+				continue
+			}
+		}
+
+		output.WriteByte(input[index])
+	}
+
+	return output.String()
+}
+
+// unescapeDiskLink decodes the hex escapes (like \x20 for a space) udev uses in the names of the /dev/disk links
+func unescapeDiskLink(input string) string {
+	if !strings.Contains(input, `\x`) {
+		return input
+	}
+
+	output := strings.Builder{}
+
+	for index := 0; index < len(input); index++ {
+		if strings.HasPrefix(input[index:], `\x`) && index+3 < len(input) {
+			if char, err := strconv.ParseUint(input[index+2:index+4], 16, 8); err == nil {
+				output.WriteByte(byte(char))
+				index += 3
+				continue
+			}
+		}
+
+		output.WriteByte(input[index])
+	}
+
+	return output.String()
+}
+
+// isMountPoint checks, if something is mounted directly on dirPath
+func (table mountTable) isMountPoint(dirPath string) bool {
+	_, ok := table[dirPath]
+	return ok
+}
+
+// policy returns the mountPolicy of the mount dirPath is on for the Dirs. The mount one of its base dirs is on is never skipped, as the Dirs asked for it explicitly.
+// Volumes are crawled by their own Dirs, so the scopes skip them, unless one of their base dirs is on the volume
+func (table mountTable) policy(dirPath string, dirs *Dirs) mountPolicy {
+	mountPoint, policy := table.lookup(dirPath)
+	if policy == skipMount && dirs.hasBaseDirOn(mountPoint) {
+		return indexMount
+	}
+
+	if policy != volumeMount {
+		return policy
+	}
+
+	if dirs.volume != nil || dirs.hasBaseDirOn(mountPoint) {
+		return indexMount
+	}
+
+	return skipMount
+}
+
+// lookup returns the closest mount point dirPath is on and its mountPolicy, by walking up the folders
+func (table mountTable) lookup(dirPath string) (string, mountPolicy) {
+	for {
+		if policy, ok := table[dirPath]; ok {
+			return dirPath, policy
+		}
+
+		parent := parentDir(dirPath)
+		if parent == dirPath {
+			return dirPath, indexMount
+		}
+
+		dirPath = parent
+	}
+}
+
+// entryMetadata returns the Metadata of a dir entry like the entryMetadata function does. Mount points of mounts we won't crawl get empty Metadata instead, as a stat on a dead network share can hang
+func (table mountTable) entryMetadata(dirPath string, entry os.DirEntry, onDemand bool) (Metadata, error) {
+	if entry.IsDir() {
+		entryPath := fmt.Sprintf("%s%s", filepath.Join(dirPath, entry.Name()), string(filepath.Separator))
+
+		if policy, ok := table[entryPath]; ok && (policy == skipMount || (policy == onDemandMount && !onDemand)) {
+			return Metadata{}, nil
+		}
+	}
+
+	return entryMetadata(entry)
+}
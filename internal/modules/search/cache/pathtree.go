@@ -0,0 +1,205 @@
+// Package cache handles everything that has to do with the generation of the cache for the Search function, to the generation of our folder structure and importing of the config.
+package cache
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	rootNode    int32 = -1 // the parent of the folders at the top of the tree
+	removedNode int32 = -2 // the parent of folders that were removed, their keys are only reused on the next full update
+)
+
+/*
+PathTree stores the paths of the folders in a Dirs as a tree, where every folder only knows its own name and the key of the folder it's in.
+This way the beginnings that the paths share are only stored once, instead of in every path again.
+
+A folder always gets a higher key than the folder it's in, so going through the keys in order walks the tree from the top down.
+
+nodes: []pathNode{name, parent}, where the key is the index
+*/
+type PathTree struct {
+	children map[pathNode]int // only built once we have to look up a path, which during searches never happens
+	nodes    []pathNode
+	segments map[string]string // interns the names, so a name like "src" is only stored once for all the folders with it
+}
+
+// pathNode is a single folder of a PathTree
+type pathNode struct {
+	name   string
+	parent int32
+}
+
+// newPathTree returns an empty PathTree
+func newPathTree() *PathTree {
+	return &PathTree{}
+}
+
+// Path rebuilds the absolute path (with a trailing separator) of the folder with the key, or returns "" if there is no such folder
+func (tree *PathTree) Path(key int) string {
+	if key < 0 || key >= len(tree.nodes) || tree.nodes[key].parent == removedNode {
+		return ""
+	}
+
+	length := 0
+	segments := make([]string, 0, 16)
+
+	for current := int32(key); current != rootNode; current = tree.nodes[current].parent {
+		segments = append(segments, tree.nodes[current].name)
+		length += len(tree.nodes[current].name) + 1
+	}
+
+	output := strings.Builder{}
+	output.Grow(length)
+
+	for index := len(segments) - 1; index >= 0; index-- {
+		output.WriteString(segments[index])
+		output.WriteByte(filepath.Separator)
+	}
+
+	return output.String()
+}
+
+// all rebuilds the paths of all folders at once, which is a lot cheaper than calling Path for each of them. Removed keys get an empty path
+func (tree *PathTree) all() []string {
+	paths := make([]string, len(tree.nodes))
+
+	for key, node := range tree.nodes {
+		switch node.parent {
+		case removedNode:
+			continue
+		case rootNode:
+			paths[key] = node.name + string(filepath.Separator)
+		default:
+			paths[key] = paths[node.parent] + node.name + string(filepath.Separator)
+		}
+	}
+
+	return paths
+}
+
+// key returns the key of dirPath on the PathTree. If it isn't on there it'll be added together with the folders above it, when add is set, otherwise -1 is returned
+func (tree *PathTree) key(dirPath string, add bool) int {
+	if tree.children == nil {
+		tree.children = make(map[pathNode]int, len(tree.nodes))
+
+		for key, node := range tree.nodes {
+			if node.parent != removedNode {
+				tree.children[node] = key
+			}
+		}
+	}
+
+	current := rootNode
+
+	// "/home/" becomes "", "home", so the root folder is the node with the empty name
+	for _, segment := range strings.Split(strings.TrimSuffix(dirPath, string(filepath.Separator)), string(filepath.Separator)) {
+		key, ok := tree.children[pathNode{segment, current}]
+
+		if !ok {
+			if !add {
+				return -1
+			}
+
+			key = tree.insert(segment, current)
+		}
+
+		current = int32(key)
+	}
+
+	return int(current)
+}
+
+// insert adds a folder below parent and returns its key
+func (tree *PathTree) insert(name string, parent int32) int {
+	if tree.segments == nil {
+		tree.segments = make(map[string]string)
+	}
+
+	if interned, ok := tree.segments[name]; ok {
+		name = interned
+	} else {
+		tree.segments[name] = name
+	}
+
+	key := len(tree.nodes)
+	node := pathNode{name, parent}
+
+	tree.nodes = append(tree.nodes, node)
+
+	if tree.children != nil {
+		tree.children[node] = key
+	}
+
+	return key
+}
+
+// subtree returns the key of the folder and the keys of all folders below it
+func (tree *PathTree) subtree(key int) map[int]bool {
+	keys := make(map[int]bool)
+
+	if key < 0 || key >= len(tree.nodes) || tree.nodes[key].parent == removedNode {
+		return keys
+	}
+
+	keys[key] = true
+
+	// the folders below key all have a higher key, and their parents come before them
+	for current := key + 1; current < len(tree.nodes); current++ {
+		if parent := tree.nodes[current].parent; parent >= 0 && keys[int(parent)] {
+			keys[current] = true
+		}
+	}
+
+	return keys
+}
+
+// remove deletes the folders with the keys from the PathTree, the keys have to be a whole subtree
+func (tree *PathTree) remove(keys map[int]bool) {
+	for key := range keys {
+		delete(tree.children, tree.nodes[key])
+		tree.nodes[key] = pathNode{"", removedNode}
+	}
+}
+
+// clone returns a copy of the PathTree, that can be changed without changing the original. The lookup maps are handed over to the copy instead of being copied, the original rebuilds them, if it ever needs them again
+func (tree *PathTree) clone() *PathTree {
+	copied := PathTree{children: tree.children, nodes: slices.Clone(tree.nodes), segments: tree.segments}
+	tree.children, tree.segments = nil, nil
+
+	return &copied
+}
+
+// compact drops the lookup maps, that were only needed while building the PathTree
+func (tree *PathTree) compact() {
+	tree.children = nil
+	tree.segments = nil
+}
+
+// pathTreeFromPaths builds a PathTree out of the paths of the old format and returns it together with the new key for every old key
+func pathTreeFromPaths(paths map[int]string) (*PathTree, map[int]int) {
+	tree := newPathTree()
+	newKeys := make(map[int]int, len(paths))
+
+	for key, dirPath := range paths {
+		newKeys[key] = tree.key(dirPath, true)
+	}
+
+	tree.compact()
+
+	return tree, newKeys
+}
+
+// validate checks, that every folder points to a folder before it, as a broken cache file could otherwise make Path loop forever
+func (tree *PathTree) validate() error {
+	for key, node := range tree.nodes {
+		if node.parent < removedNode || int(node.parent) >= key {
+			return errors.New("path tree has a folder with an invalid parent")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+// Package cache handles everything that has to do with the generation of the cache for the Search function, to the generation of our folder structure and importing of the config.
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"runtime"
+	"testing"
+)
+
+// syntheticPaths returns the folder paths of a tree shaped like a home folder full of projects, with 16 projects of 64 modules, each with their own src, test and docs folders and a few levels below them
+func syntheticPaths() []string {
+	paths := []string{}
+
+	for project := range 16 {
+		for module := range 64 {
+			for _, kind := range []string{"src", "test", "docs"} {
+				for level := range 4 {
+					for sub := range 4 {
+						paths = append(paths, fmt.Sprintf("/home/user/projects/project-%d/modules/module-%d/%s/level-%d/component-%d/", project, module, kind, level, sub))
+					}
+				}
+			}
+		}
+	}
+
+	return paths
+}
+
+// heapSink keeps the value heapOf measures alive, until it's done measuring
+var heapSink any
+
+// heapOf returns how many bytes of the heap the value build returns holds on to
+func heapOf(build func() any) float64 {
+	var before, after runtime.MemStats
+
+	runtime.GC()
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	heapSink = build()
+
+	runtime.GC()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+
+	heapSink = nil
+
+	return float64(after.HeapAlloc) - float64(before.HeapAlloc)
+}
+
+// BenchmarkPathMap builds the map of full paths, that the Dirs had before the PathTree, and reports its heap and the size its paths section took up in the cache file
+func BenchmarkPathMap(b *testing.B) {
+	paths := syntheticPaths()
+
+	build := func() any {
+		pathMap := make(map[int]string, len(paths))
+
+		for key, dirPath := range paths {
+			// a copy, as the paths from the disk don't share their memory either
+			pathMap[key] = string([]byte(dirPath))
+		}
+
+		return pathMap
+	}
+
+	heap := heapOf(build)
+
+	for b.Loop() {
+		build()
+	}
+
+	// count uint32 | count * (pathKey uint32 | path string)
+	diskSize := 4
+	for _, dirPath := range paths {
+		diskSize += 4 + uvarintSize(uint64(len(dirPath))) + len(dirPath)
+	}
+
+	b.ReportMetric(heap, "heap-B")
+	b.ReportMetric(float64(diskSize), "disk-B")
+}
+
+// BenchmarkPathTree builds the PathTree for the same folders as BenchmarkPathMap and reports its heap and the size of its paths section plus the folder names it added to the names table
+func BenchmarkPathTree(b *testing.B) {
+	paths := syntheticPaths()
+
+	build := func() any {
+		tree := newPathTree()
+
+		for _, dirPath := range paths {
+			tree.key(string([]byte(dirPath)), true)
+		}
+
+		tree.compact()
+
+		return tree
+	}
+
+	heap := heapOf(build)
+
+	for b.Loop() {
+		build()
+	}
+
+	// the other sections are empty, so all that's written are their counts
+	cw := cacheWriter{writer: bufio.NewWriter(io.Discard)}
+	cw.writeData(cacheData{make(map[string]map[int][]File), build().(*PathTree), make(map[int]DirStat)})
+
+	b.ReportMetric(heap, "heap-B")
+	b.ReportMetric(float64(cw.written), "disk-B")
+}
+
+// TestPathTreeRoundTrip makes sure every path comes back out of the PathTree like it went in
+func TestPathTreeRoundTrip(t *testing.T) {
+	tree := newPathTree()
+	paths := syntheticPaths()
+	keys := make([]int, len(paths))
+
+	for index, dirPath := range paths {
+		keys[index] = tree.key(dirPath, true)
+	}
+
+	tree.compact()
+	all := tree.all()
+
+	for index, dirPath := range paths {
+		if got := tree.Path(keys[index]); got != dirPath {
+			t.Fatalf("Path returned %s instead of %s", got, dirPath)
+		}
+
+		if all[keys[index]] != dirPath {
+			t.Fatalf("all returned %s instead of %s", all[keys[index]], dirPath)
+		}
+	}
+}
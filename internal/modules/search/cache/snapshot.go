@@ -0,0 +1,182 @@
+// Package cache handles everything that has to do with the generation of the cache for the Search function, to the generation of our folder structure and importing of the config.
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// DirStat stores the modification time and inode of a folder, so we can tell if its entries changed since the last update.
+// Rules is the fingerprint of the ignoreList the folder was read with, as different rules can change its entries as well
+type DirStat struct {
+	ModTime int64  `json:"m"`
+	Inode   uint64 `json:"i"`
+	Rules   uint64 `json:"r"`
+}
+
+// dirSnapshot holds the entries of a folder from the last update, together with the DirStat it had back then
+type dirSnapshot struct {
+	entries []basicFile
+	stat    DirStat
+}
+
+// previousIndex looks up the folders of the last update for the traversal, a folder at a time
+type previousIndex interface {
+	// close frees what the lookups hold on to
+	close()
+	// empty checks, if there was no last update to compare with
+	empty() bool
+	// lookup returns the dirSnapshot of the folder at dirPath, if it was part of the last update
+	lookup(dirPath string) (*dirSnapshot, bool)
+}
+
+// snapshotMap is the previousIndex of the memoryStore, which has all the folders in memory anyway
+type snapshotMap map[string]*dirSnapshot
+
+// newDirStat returns the DirStat of the folder at dirPath
+func newDirStat(dirPath string) (DirStat, error) {
+	fileInfo, err := os.Stat(dirPath)
+	if err != nil {
+		return DirStat{}, err
+	}
+
+	stat := DirStat{ModTime: fileInfo.ModTime().UnixNano()}
+
+	if sysStat, ok := fileInfo.Sys().(*syscall.Stat_t); ok {
+		stat.Inode = sysStat.Ino
+	}
+
+	return stat, nil
+}
+
+// entries returns the files and folders directly inside of dirPath. If the folder still has the same DirStat as on the last update, the entries are taken from there instead of reading the folder again.
+// Their Metadata is reused as well, so changes to the content of a file only show up, once the watcher reports them or the folder itself changes.
+// With cachedOnly the folder isn't touched at all and only the entries from the last update are returned, for mounts that are only indexed on demand. The bool tells, if the folder was read again.
+// The DirStat isn't stored here, as the entries are only complete for the next update, if no limit cuts them off, see keepStat
+func (t *traversal) entries(dirPath string, rules uint64, cachedOnly bool) ([]basicFile, DirStat, bool, error) {
+	if cachedOnly {
+		previous, ok := t.previous.lookup(dirPath)
+		if !ok {
+			return nil, DirStat{}, false, nil
+		}
+
+		return previous.entries, previous.stat, false, nil
+	}
+
+	stat, err := newDirStat(dirPath)
+	if err != nil {
+		return nil, DirStat{}, false, err
+	}
+
+	stat.Rules = rules
+	found := []basicFile{}
+	reread := false
+
+	if previous, ok := t.previous.lookup(dirPath); ok && previous.stat == stat {
+		found = previous.entries
+	} else {
+		t.throttle.readDir()
+
+		dirEntries, err := os.ReadDir(dirPath)
+		if err != nil {
+			return nil, DirStat{}, false, err
+		}
+
+		reread = true
+
+		for _, entry := range dirEntries {
+			metadata, err := t.mounts.entryMetadata(dirPath, entry, t.onDemand)
+			// the entry got removed since we read the dir
+			if err != nil {
+				continue
+			}
+
+			found = append(found, newBasicFile(dirPath, entry.Name(), entry.IsDir(), metadata))
+		}
+	}
+
+	return found, stat, reread, nil
+}
+
+// keepStat stores the DirStat of a folder, whose entries all made it into the index, so the next update can take them from there.
+// It's only called after a successful read, otherwise an unreadable folder would stay empty even after it becomes readable. A folder that was never cached has no stat to keep
+func (t *traversal) keepStat(dirPath string, stat DirStat) {
+	if stat == (DirStat{}) {
+		return
+	}
+
+	t.statsMu.Lock()
+	t.stats[dirPath] = stat
+	t.statsMu.Unlock()
+}
+
+// close does nothing, the snapshotMap is simply dropped by the garbage collector
+func (snapshots snapshotMap) close() {}
+
+// empty checks, if there are no folders in the snapshotMap
+func (snapshots snapshotMap) empty() bool {
+	return len(snapshots) == 0
+}
+
+// lookup returns the dirSnapshot of the folder at dirPath
+func (snapshots snapshotMap) lookup(dirPath string) (*dirSnapshot, bool) {
+	snapshot, ok := snapshots[dirPath]
+
+	return snapshot, ok
+}
+
+// newSnapshotMap groups the entries of the source by the folder they're in
+func newSnapshotMap(source *cacheData) snapshotMap {
+	snapshots := make(snapshotMap, len(source.stats))
+	paths := source.paths.all()
+
+	for key, stat := range source.stats {
+		if key < len(paths) && paths[key] != "" {
+			snapshots[paths[key]] = &dirSnapshot{stat: stat}
+		}
+	}
+
+	for extension, lengths := range source.dirMap {
+		for _, files := range lengths {
+			for _, file := range files {
+				if file.PathKey >= len(paths) || paths[file.PathKey] == "" {
+					continue
+				}
+
+				filePath := paths[file.PathKey]
+
+				item := basicFile{extension, false, file.Name, filePath, file.Metadata}
+
+				// folders are stored with their own path, so their entry belongs to the folder above them
+				if extension == "folder" {
+					item.isFolder = true
+					filePath = parentDir(filePath)
+				}
+
+				// folders the watcher added have no DirStat yet, so they're read again, but their entries still tell the diff what's new
+				snapshot, ok := snapshots[filePath]
+				if !ok {
+					snapshot = &dirSnapshot{}
+					snapshots[filePath] = snapshot
+				}
+
+				snapshot.entries = append(snapshot.entries, item)
+			}
+		}
+	}
+
+	return snapshots
+}
+
+// parentDir returns the path of the folder above dirPath, with a trailing separator like all the folder paths in the cache
+func parentDir(dirPath string) string {
+	parent := filepath.Dir(strings.TrimSuffix(dirPath, string(filepath.Separator)))
+
+	if !strings.HasSuffix(parent, string(filepath.Separator)) {
+		parent += string(filepath.Separator)
+	}
+
+	return parent
+}
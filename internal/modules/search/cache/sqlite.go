@@ -0,0 +1,766 @@
+// Package cache handles everything that has to do with the generation of the cache for the Search function, to the generation of our folder structure and importing of the config.
+package cache
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	_ "modernc.org/sqlite" // the pure Go driver, so Bolt doesn't need cgo
+)
+
+/*
+The index database has the same data as the cache file, every folder is stored with its whole path though, as the database can look it up by that. The parent of a path is the key of the folder above it, or -1 for the root folder.
+user_version is set to the sqliteVersion, once an update has stored a complete index.
+
+encoded is the EncodedName as a little endian integer, so a search can compare it with a bitwise and. ascii is set for names, whose normalized form is just lower(name).
+
+An update writes its results into the update_ tables first, which are only moved over into the ones the searches read from once it's published.
+*/
+const (
+	sqliteBatchSize int    = 10000 // how many rows an update writes per transaction, in between them the watcher can write its batches
+	sqliteVersion   int    = 3
+	sqliteOptions   string = "?_pragma=busy_timeout(10000)&_pragma=journal_mode(wal)&_pragma=synchronous(normal)" // with the write-ahead log, searches keep reading while an update writes
+	sqliteSchema    string = `
+CREATE TABLE IF NOT EXISTS paths (key INTEGER PRIMARY KEY, path TEXT NOT NULL UNIQUE, parent INTEGER NOT NULL);
+CREATE INDEX IF NOT EXISTS paths_by_parent ON paths (parent);
+CREATE TABLE IF NOT EXISTS files (extension TEXT NOT NULL, length INTEGER NOT NULL, name TEXT NOT NULL, ascii INTEGER NOT NULL, encoded INTEGER NOT NULL, path_key INTEGER NOT NULL, size INTEGER NOT NULL, mod_time INTEGER NOT NULL, mode INTEGER NOT NULL, inode INTEGER NOT NULL, device INTEGER NOT NULL);
+CREATE INDEX IF NOT EXISTS files_by_bucket ON files (extension, length);
+CREATE INDEX IF NOT EXISTS files_by_path ON files (path_key, name);
+CREATE TABLE IF NOT EXISTS stats (path_key INTEGER PRIMARY KEY, mod_time INTEGER NOT NULL, inode INTEGER NOT NULL, rules INTEGER NOT NULL);
+CREATE TABLE IF NOT EXISTS update_paths (key INTEGER PRIMARY KEY, path TEXT NOT NULL UNIQUE, parent INTEGER NOT NULL);
+CREATE TABLE IF NOT EXISTS update_files (extension TEXT NOT NULL, length INTEGER NOT NULL, name TEXT NOT NULL, ascii INTEGER NOT NULL, encoded INTEGER NOT NULL, path_key INTEGER NOT NULL, size INTEGER NOT NULL, mod_time INTEGER NOT NULL, mode INTEGER NOT NULL, inode INTEGER NOT NULL, device INTEGER NOT NULL);
+CREATE TABLE IF NOT EXISTS update_stats (path_key INTEGER PRIMARY KEY, mod_time INTEGER NOT NULL, inode INTEGER NOT NULL, rules INTEGER NOT NULL);`
+	sqliteDropTables  string = "DROP TABLE IF EXISTS files; DROP TABLE IF EXISTS stats; DROP TABLE IF EXISTS paths; DROP TABLE IF EXISTS update_files; DROP TABLE IF EXISTS update_stats; DROP TABLE IF EXISTS update_paths; PRAGMA user_version = 0;"
+	sqliteClearUpdate string = "DELETE FROM update_files; DELETE FROM update_stats; DELETE FROM update_paths;"
+	sqliteFileColumns string = "files.name, files.encoded, files.path_key, files.size, files.mod_time, files.mode, files.inode, files.device"
+	sqliteFileInsert  string = "(extension, length, name, ascii, encoded, path_key, size, mod_time, mode, inode, device) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+)
+
+// sqliteStore is the indexStore, that keeps the files in a SQLite database on the disk. Searches only load the files, that match their query
+type sqliteStore struct {
+	db   atomic.Pointer[sql.DB] // nil while the store isn't loaded
+	path string
+}
+
+// sqliteIndex is the Index of the sqliteStore. It's a read transaction, so it keeps seeing the database as it was, when it was first read from
+type sqliteIndex struct {
+	paths map[int]string // the paths of the folders of the files Query yielded, so they don't have to be looked up again
+	tx    *sql.Tx
+}
+
+// sqliteBatch is the storeBatch of the sqliteStore, a write transaction
+type sqliteBatch struct {
+	err error
+	tx  *sql.Tx
+}
+
+// sqliteSnapshot is the previousIndex of the sqliteStore, a read transaction that looks up a folder at a time
+type sqliteSnapshot struct {
+	db *sql.DB
+	mu sync.Mutex // the workers look up their folders at the same time, but the transaction only runs one query at a time
+	tx *sql.Tx
+}
+
+// sqliteUpdate is the storeUpdate of the sqliteStore. It writes the results into the update_ tables in transactions of sqliteBatchSize rows, so the whole index never has to be in memory
+type sqliteUpdate struct {
+	db         *sql.DB
+	err        error
+	insertFile *sql.Stmt
+	paths      map[string]int // the keys of the folders the current transaction wrote to, so they don't have to be looked up for every file
+	rows       int
+	tx         *sql.Tx
+}
+
+// openDatabase opens the index database at dbPath and creates its tables, if they don't exist yet
+func openDatabase(dbPath string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", dbPath+sqliteOptions)
+	if err != nil {
+		return nil, fmt.Errorf("openDatabase: couldn't open index database %s:\n--> %w", dbPath, err)
+	}
+
+	// the tables of an older version can't be reused, so they're made anew and filled by the next update
+	version := 0
+	if db.QueryRow("PRAGMA user_version").Scan(&version) == nil && version != 0 && version != sqliteVersion {
+		_, err = db.Exec(sqliteDropTables)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("openDatabase: couldn't drop the old tables in %s:\n--> %w", dbPath, err)
+		}
+	}
+
+	_, err = db.Exec(sqliteSchema)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("openDatabase: couldn't create tables in %s:\n--> %w", dbPath, err)
+	}
+
+	return db, nil
+}
+
+// database returns the database of the store. If the store isn't loaded, it's opened just for the caller, which then has to close it
+func (store *sqliteStore) database() (*sql.DB, bool, error) {
+	if db := store.db.Load(); db != nil {
+		return db, false, nil
+	}
+
+	db, err := openDatabase(store.path)
+
+	return db, true, err
+}
+
+// complete checks, if an update has stored a complete index in the database
+func complete(db *sql.DB) error {
+	version := 0
+
+	err := db.QueryRow("PRAGMA user_version").Scan(&version)
+	if err != nil {
+		return fmt.Errorf("complete: couldn't read the version of the index database:\n--> %w", err)
+	}
+
+	if version != sqliteVersion {
+		return errors.New("index database doesn't hold a complete index")
+	}
+
+	return nil
+}
+
+// index starts a read transaction for a search, or returns nil if the store isn't loaded
+func (store *sqliteStore) index() Index {
+	db := store.db.Load()
+	if db == nil {
+		return nil
+	}
+
+	// the store might have been unloaded in the meantime, which the search treats like it never was loaded
+	tx, err := db.Begin()
+	if err != nil {
+		return nil
+	}
+
+	return &sqliteIndex{paths: make(map[int]string), tx: tx}
+}
+
+// load opens the database. If it doesn't hold a complete index the store is loaded anyway, so the watcher and the next update can fill it
+func (store *sqliteStore) load() error {
+	db, err := openDatabase(store.path)
+	if err != nil {
+		return fmt.Errorf("load: couldn't open the index database:\n--> %w", err)
+	}
+
+	store.db.Store(db)
+
+	return complete(db)
+}
+
+// loaded checks, if the database is open
+func (store *sqliteStore) loaded() bool {
+	return store.db.Load() != nil
+}
+
+// unload closes the database, the searches that still read from it finish first
+func (store *sqliteStore) unload() {
+	if db := store.db.Swap(nil); db != nil {
+		db.Close()
+	}
+}
+
+// snapshot starts a read transaction, that the traversal looks up its folders in. It opens the database just for this, so the store can be unloaded in the meantime
+func (store *sqliteStore) snapshot() previousIndex {
+	// without a complete index there's nothing to reuse, so everything gets read from the disk
+	db, err := openDatabase(store.path)
+	if err != nil {
+		return make(snapshotMap)
+	}
+
+	tx, err := db.Begin()
+	if complete(db) != nil || err != nil {
+		if tx != nil {
+			tx.Rollback()
+		}
+
+		db.Close()
+		return make(snapshotMap)
+	}
+
+	return &sqliteSnapshot{db: db, tx: tx}
+}
+
+// update clears what an update that didn't finish left in the update_ tables and returns a sqliteUpdate, with a database it opened just for itself
+func (store *sqliteStore) update() storeUpdate {
+	update := sqliteUpdate{paths: make(map[string]int)}
+
+	update.db, update.err = openDatabase(store.path)
+	if update.err != nil {
+		return &update
+	}
+
+	_, update.err = update.db.Exec(sqliteClearUpdate)
+
+	return &update
+}
+
+// data reads the whole database, for the duplicates of a scope that isn't imported
+func (store *sqliteStore) data() (*cacheData, error) {
+	db, opened, err := store.database()
+	if err != nil {
+		return nil, fmt.Errorf("data: couldn't open the index database:\n--> %w", err)
+	}
+
+	if opened {
+		defer db.Close()
+	}
+
+	// without a complete index there's nothing to reuse
+	err = complete(db)
+	if err != nil {
+		return nil, fmt.Errorf("data: couldn't use the index database:\n--> %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("data: couldn't start a transaction:\n--> %w", err)
+	}
+	defer tx.Rollback()
+
+	data, err := readDatabase(tx)
+	if err != nil {
+		return nil, fmt.Errorf("data: couldn't read the index database:\n--> %w", err)
+	}
+
+	return data, nil
+}
+
+// readDatabase reads everything in the database into cacheData
+func readDatabase(tx *sql.Tx) (*cacheData, error) {
+	rows, err := tx.Query("SELECT key, path FROM paths")
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[int]string)
+
+	for rows.Next() {
+		key, dirPath := 0, ""
+
+		if err := rows.Scan(&key, &dirPath); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		paths[key] = dirPath
+	}
+
+	rows.Close()
+
+	// the PathTree hands out its own keys, so the files and stats have to point to those instead
+	tree, newKeys := pathTreeFromPaths(paths)
+	dirMap := make(map[string]map[int][]File)
+
+	rows, err = tx.Query(fmt.Sprintf("SELECT files.extension, %s FROM files", sqliteFileColumns))
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		extension := ""
+		file, err := scanFile(rows, &extension)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		if _, ok := dirMap[extension]; !ok {
+			dirMap[extension] = make(map[int][]File)
+		}
+
+		file.PathKey = newKeys[file.PathKey]
+		dirMap[extension][len(file.Name)] = append(dirMap[extension][len(file.Name)], file)
+	}
+
+	rows.Close()
+
+	rows, err = tx.Query("SELECT path_key, mod_time, inode, rules FROM stats")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make(map[int]DirStat)
+
+	for rows.Next() {
+		key, stat := 0, DirStat{}
+		inode, rules := int64(0), int64(0)
+
+		if err := rows.Scan(&key, &stat.ModTime, &inode, &rules); err != nil {
+			return nil, err
+		}
+
+		stat.Inode, stat.Rules = uint64(inode), uint64(rules)
+
+		if newKey, ok := newKeys[key]; ok {
+			stats[newKey] = stat
+		}
+	}
+
+	return &cacheData{dirMap, tree, stats}, rows.Err()
+}
+
+// batch starts a write transaction for the events of the watcher
+func (store *sqliteStore) batch() storeBatch {
+	tx, err := store.db.Load().Begin()
+
+	return &sqliteBatch{err: err, tx: tx}
+}
+
+// Query calls yield for the files with one of the extensions (or any extension, if there are none), whose names are at least minLength bytes long and have all the chars of encoded, until yield returns false.
+// For ascii names the database checks, if they contain the query as well, so only the files that match are loaded
+func (index *sqliteIndex) Query(extensions []string, minLength int, encoded [8]byte, query string, yield func(string, File) bool) error {
+	mask := encodedInt(encoded)
+	where, args := "", []any{}
+
+	if len(extensions) > 0 {
+		where = fmt.Sprintf("files.extension IN (%s) AND ", strings.TrimSuffix(strings.Repeat("?, ", len(extensions)), ", "))
+
+		for _, extension := range extensions {
+			args = append(args, extension)
+		}
+	}
+
+	rows, err := index.tx.Query(fmt.Sprintf(`SELECT files.extension, paths.path, %s FROM files JOIN paths ON paths.key = files.path_key
+		WHERE %sfiles.length >= ? AND (files.encoded & ?) = ? AND (files.ascii = 0 OR instr(lower(files.name), ?) > 0)`, sqliteFileColumns, where), append(args, minLength, mask, mask, query)...)
+	if err != nil {
+		return fmt.Errorf("Query: couldn't query the index database:\n--> %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		extension, dirPath := "", ""
+
+		file, err := scanFile(rows, &extension, &dirPath)
+		if err != nil {
+			return fmt.Errorf("Query: couldn't read a file from the index database:\n--> %w", err)
+		}
+
+		index.paths[file.PathKey] = dirPath
+
+		if !yield(extension, file) {
+			return nil
+		}
+	}
+
+	return rows.Err()
+}
+
+// Path returns the path of the folder with the pathKey
+func (index *sqliteIndex) Path(pathKey int) string {
+	if dirPath, ok := index.paths[pathKey]; ok {
+		return dirPath
+	}
+
+	dirPath := ""
+	index.tx.QueryRow("SELECT path FROM paths WHERE key = ?", pathKey).Scan(&dirPath)
+
+	return dirPath
+}
+
+// Release ends the read transaction
+func (index *sqliteIndex) Release() {
+	index.tx.Rollback()
+}
+
+// insert adds an item to the database, or updates its Metadata if it's already in there. It returns the item as added or modified, or nothing if it didn't change
+func (batch *sqliteBatch) insert(item basicFile) []changedFile {
+	if batch.err != nil {
+		return nil
+	}
+
+	itemExtension := strings.ToLower(item.extension)
+
+	pathKey, err := batch.pathKey(item.path, true)
+	if err != nil {
+		batch.err = err
+		return nil
+	}
+
+	rowID, metadata, err := batch.find(pathKey, item.name, itemExtension)
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		_, err = batch.tx.Exec("INSERT INTO files "+sqliteFileInsert,
+			itemExtension, len(item.name), item.name, isASCII(item.name), encodedInt(Encode(item.name)), pathKey, item.metadata.Size, item.metadata.ModTime, item.metadata.Mode, int64(item.metadata.Inode), int64(item.metadata.Device))
+		if err != nil {
+			batch.err = err
+			return nil
+		}
+
+		return []changedFile{{item, ChangeAdded}}
+	case err != nil:
+		batch.err = err
+		return nil
+	}
+
+	// an item we already know only gets its Metadata refreshed
+	if metadata != item.metadata {
+		_, err = batch.tx.Exec("UPDATE files SET size = ?, mod_time = ?, mode = ?, inode = ?, device = ? WHERE rowid = ?", item.metadata.Size, item.metadata.ModTime, item.metadata.Mode, int64(item.metadata.Inode), int64(item.metadata.Device), rowID)
+		if err != nil {
+			batch.err = err
+			return nil
+		}
+	}
+
+	if item.isFolder || metadata.ModTime == item.metadata.ModTime && metadata.Size == item.metadata.Size {
+		return nil
+	}
+
+	return []changedFile{{item, ChangeModified}}
+}
+
+// delete removes an item from the database. For folders this also removes everything inside of them. It returns everything it removed as deleted
+func (batch *sqliteBatch) delete(item basicFile) []changedFile {
+	if batch.err != nil {
+		return nil
+	}
+
+	pathKey, err := batch.pathKey(item.path, false)
+	if err != nil {
+		// a folder we don't know has nothing to remove
+		if !errors.Is(err, sql.ErrNoRows) {
+			batch.err = err
+		}
+
+		return nil
+	}
+
+	if !item.isFolder {
+		rowID, metadata, err := batch.find(pathKey, item.name, strings.ToLower(item.extension))
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				batch.err = err
+			}
+
+			return nil
+		}
+
+		if _, err := batch.tx.Exec("DELETE FROM files WHERE rowid = ?", rowID); err != nil {
+			batch.err = err
+			return nil
+		}
+
+		// the event doesn't know the inode of a file that's gone, but the database does
+		item.metadata = metadata
+		return []changedFile{{item, ChangeDeleted}}
+	}
+
+	// the paths of the folders inside of it all start with its path, which ends in a separator, so they sort between it and the path with the next char instead of the separator
+	lower, upper := item.path, strings.TrimSuffix(item.path, string(filepath.Separator))+string(filepath.Separator+1)
+
+	rows, err := batch.tx.Query(fmt.Sprintf("SELECT files.extension, paths.path, %s FROM files JOIN paths ON paths.key = files.path_key WHERE paths.path >= ? AND paths.path < ?", sqliteFileColumns), lower, upper)
+	if err != nil {
+		batch.err = err
+		return nil
+	}
+
+	changes := []changedFile{}
+
+	for rows.Next() {
+		extension, dirPath := "", ""
+
+		file, err := scanFile(rows, &extension, &dirPath)
+		if err != nil {
+			rows.Close()
+			batch.err = err
+			return nil
+		}
+
+		changes = append(changes, changedFile{basicFile{extension, extension == "folder", file.Name, dirPath, file.Metadata}, ChangeDeleted})
+	}
+
+	rows.Close()
+
+	for _, statement := range []string{
+		"DELETE FROM files WHERE path_key IN (SELECT key FROM paths WHERE path >= ? AND path < ?)",
+		"DELETE FROM stats WHERE path_key IN (SELECT key FROM paths WHERE path >= ? AND path < ?)",
+		"DELETE FROM paths WHERE path >= ? AND path < ?",
+	} {
+		if _, err := batch.tx.Exec(statement, lower, upper); err != nil {
+			batch.err = err
+			return nil
+		}
+	}
+
+	return changes
+}
+
+// commit stores the changes of the batch, or drops them if any of them failed
+func (batch *sqliteBatch) commit() error {
+	if batch.err != nil {
+		if batch.tx != nil {
+			batch.tx.Rollback()
+		}
+
+		return fmt.Errorf("commit: couldn't apply the events to the index database:\n--> %w", batch.err)
+	}
+
+	return batch.tx.Commit()
+}
+
+// pathKey returns the key of dirPath in the database. If it isn't in there, it's added when add is set, otherwise sql.ErrNoRows is returned
+func (batch *sqliteBatch) pathKey(dirPath string, add bool) (int, error) {
+	return findPathKey(batch.tx, "paths", dirPath, add)
+}
+
+// findPathKey returns the key of dirPath in the table of tx. If it isn't in there, it's added together with the folders above it when add is set, otherwise sql.ErrNoRows is returned
+func findPathKey(tx *sql.Tx, table string, dirPath string, add bool) (int, error) {
+	key := 0
+
+	err := tx.QueryRow(fmt.Sprintf("SELECT key FROM %s WHERE path = ?", table), dirPath).Scan(&key)
+	if !errors.Is(err, sql.ErrNoRows) || !add {
+		return key, err
+	}
+
+	// the root folder is the only one without a folder above it
+	parent := -1
+	if strings.Count(dirPath, string(filepath.Separator)) > 1 {
+		parent, err = findPathKey(tx, table, parentDir(dirPath), true)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	result, err := tx.Exec(fmt.Sprintf("INSERT INTO %s (path, parent) VALUES (?, ?)", table), dirPath, parent)
+	if err != nil {
+		return 0, err
+	}
+
+	newKey, err := result.LastInsertId()
+
+	return int(newKey), err
+}
+
+// find returns the rowid and Metadata of a file in the database, or sql.ErrNoRows if it isn't in there
+func (batch *sqliteBatch) find(pathKey int, name string, extension string) (int64, Metadata, error) {
+	rowID, metadata, inode, device := int64(0), Metadata{}, int64(0), int64(0)
+
+	err := batch.tx.QueryRow("SELECT rowid, size, mod_time, mode, inode, device FROM files WHERE path_key = ? AND name = ? AND extension = ?", pathKey, name, extension).Scan(&rowID, &metadata.Size, &metadata.ModTime, &metadata.Mode, &inode, &device)
+	metadata.Inode, metadata.Device = uint64(inode), uint64(device)
+
+	return rowID, metadata, err
+}
+
+// close ends the read transaction and closes the database of the sqliteSnapshot
+func (snapshot *sqliteSnapshot) close() {
+	snapshot.tx.Rollback()
+	snapshot.db.Close()
+}
+
+// empty is always false, as there only is a sqliteSnapshot of a complete index
+func (snapshot *sqliteSnapshot) empty() bool {
+	return false
+}
+
+// lookup reads the DirStat and entries of the folder at dirPath from the database. A folder that can't be read is left out, so it's read from the disk again
+func (snapshot *sqliteSnapshot) lookup(dirPath string) (*dirSnapshot, bool) {
+	snapshot.mu.Lock()
+	defer snapshot.mu.Unlock()
+
+	pathKey := 0
+	if snapshot.tx.QueryRow("SELECT key FROM paths WHERE path = ?", dirPath).Scan(&pathKey) != nil {
+		return nil, false
+	}
+
+	// folders the watcher added have no DirStat yet, so they're read again, but their entries still tell the diff what's new
+	previous := dirSnapshot{entries: []basicFile{}}
+	inode, rules := int64(0), int64(0)
+
+	found := snapshot.tx.QueryRow("SELECT mod_time, inode, rules FROM stats WHERE path_key = ?", pathKey).Scan(&previous.stat.ModTime, &inode, &rules) == nil
+	previous.stat.Inode, previous.stat.Rules = uint64(inode), uint64(rules)
+
+	// folders are stored with their own path, so the ones inside of dirPath are found through the parent of their path
+	rows, err := snapshot.tx.Query(fmt.Sprintf(`SELECT files.extension, ?, %[1]s FROM files WHERE files.path_key = ? AND files.extension != 'folder'
+		UNION ALL SELECT files.extension, paths.path, %[1]s FROM paths JOIN files ON files.path_key = paths.key WHERE paths.parent = ? AND files.extension = 'folder'`, sqliteFileColumns), dirPath, pathKey, pathKey)
+	if err != nil {
+		return nil, false
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		extension, filePath := "", ""
+
+		file, err := scanFile(rows, &extension, &filePath)
+		if err != nil {
+			return nil, false
+		}
+
+		previous.entries = append(previous.entries, basicFile{extension, extension == "folder", file.Name, filePath, file.Metadata})
+	}
+
+	if rows.Err() != nil || !found && len(previous.entries) == 0 {
+		return nil, false
+	}
+
+	return &previous, true
+}
+
+// add writes the item into the update_files table
+func (update *sqliteUpdate) add(item basicFile) {
+	if !update.begin() {
+		return
+	}
+
+	pathKey, err := update.pathKey(item.path)
+	if err != nil {
+		update.err = err
+		return
+	}
+
+	_, err = update.insertFile.Exec(strings.ToLower(item.extension), len(item.name), item.name, isASCII(item.name), encodedInt(Encode(item.name)), pathKey, item.metadata.Size, item.metadata.ModTime, item.metadata.Mode, int64(item.metadata.Inode), int64(item.metadata.Device))
+	if err != nil {
+		update.err = fmt.Errorf("add: couldn't insert file %s:\n--> %w", item.name, err)
+		return
+	}
+
+	update.written()
+}
+
+// addStats writes the DirStats into the update_stats table
+func (update *sqliteUpdate) addStats(stats map[string]DirStat) {
+	for dirPath, stat := range stats {
+		if !update.begin() {
+			return
+		}
+
+		pathKey, err := update.pathKey(dirPath)
+		if err != nil {
+			update.err = err
+			return
+		}
+
+		_, err = update.tx.Exec("INSERT OR REPLACE INTO update_stats (path_key, mod_time, inode, rules) VALUES (?, ?, ?, ?)", pathKey, stat.ModTime, int64(stat.Inode), int64(stat.Rules))
+		if err != nil {
+			update.err = fmt.Errorf("addStats: couldn't insert the stat of %s:\n--> %w", dirPath, err)
+			return
+		}
+
+		update.written()
+	}
+}
+
+// discard drops the results and closes the database of the sqliteUpdate
+func (update *sqliteUpdate) discard() {
+	if update.db == nil {
+		return
+	}
+
+	if update.tx != nil {
+		update.tx.Rollback()
+	}
+
+	update.db.Exec(sqliteClearUpdate)
+	update.db.Close()
+}
+
+// publish moves the results from the update_ tables into the ones the searches read from, in a single transaction, so searches either see all of the old or all of the new index
+func (update *sqliteUpdate) publish() error {
+	if update.err == nil && update.tx != nil {
+		update.err = update.tx.Commit()
+		update.tx = nil
+	}
+
+	if update.err != nil {
+		update.discard()
+		return fmt.Errorf("publish: couldn't write the results of the update:\n--> %w", update.err)
+	}
+
+	defer update.db.Close()
+
+	tx, err := update.db.Begin()
+	if err != nil {
+		return fmt.Errorf("publish: couldn't start a transaction:\n--> %w", err)
+	}
+
+	_, err = tx.Exec(fmt.Sprintf(`DELETE FROM files; DELETE FROM stats; DELETE FROM paths;
+		INSERT INTO paths SELECT key, path, parent FROM update_paths; INSERT INTO files SELECT * FROM update_files; INSERT INTO stats SELECT * FROM update_stats;
+		%s PRAGMA user_version = %d;`, sqliteClearUpdate, sqliteVersion))
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("publish: couldn't replace the index with the results of the update:\n--> %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// begin starts the next transaction, if there is none. It returns false, if the update already failed
+func (update *sqliteUpdate) begin() bool {
+	if update.err != nil {
+		return false
+	}
+
+	if update.tx != nil {
+		return true
+	}
+
+	update.tx, update.err = update.db.Begin()
+	if update.err != nil {
+		update.tx = nil
+		return false
+	}
+
+	update.insertFile, update.err = update.tx.Prepare("INSERT INTO update_files " + sqliteFileInsert)
+
+	return update.err == nil
+}
+
+// written counts a row onto the current transaction and commits it, once it has sqliteBatchSize rows
+func (update *sqliteUpdate) written() {
+	update.rows++
+
+	if update.rows < sqliteBatchSize {
+		return
+	}
+
+	update.err = update.tx.Commit()
+	update.tx, update.rows = nil, 0
+	clear(update.paths)
+}
+
+// pathKey returns the key of dirPath in the update_paths table
+func (update *sqliteUpdate) pathKey(dirPath string) (int, error) {
+	if key, ok := update.paths[dirPath]; ok {
+		return key, nil
+	}
+
+	key, err := findPathKey(update.tx, "update_paths", dirPath, true)
+	if err != nil {
+		return 0, fmt.Errorf("pathKey: couldn't add path %s:\n--> %w", dirPath, err)
+	}
+
+	update.paths[dirPath] = key
+
+	return key, nil
+}
+
+// scanFile reads a File from the sqliteFileColumns of the row, after the columns in before
+func scanFile(rows *sql.Rows, before ...any) (File, error) {
+	file, encoded, inode, device := File{}, int64(0), int64(0), int64(0)
+
+	err := rows.Scan(append(before, &file.Name, &encoded, &file.PathKey, &file.Metadata.Size, &file.Metadata.ModTime, &file.Metadata.Mode, &inode, &device)...)
+	if err != nil {
+		return File{}, err
+	}
+
+	binary.LittleEndian.PutUint64(file.EncodedName[:], uint64(encoded))
+	file.Metadata.Inode, file.Metadata.Device = uint64(inode), uint64(device)
+
+	return file, nil
+}
+
+// encodedInt converts an EncodedName into the integer it's stored as in the database
+func encodedInt(encoded [8]byte) int64 {
+	return int64(binary.LittleEndian.Uint64(encoded[:]))
+}
@@ -0,0 +1,111 @@
+// Package cache handles everything that has to do with the generation of the cache for the Search function, to the generation of our folder structure and importing of the config.
+package cache
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/skillptm/Bolt/internal/config"
+)
+
+// TestSQLiteUpdate crawls more files than fit into a single transaction of a sqliteUpdate, then looks up the folders of the last update and updates again after some of them changed
+func TestSQLiteUpdate(t *testing.T) {
+	baseDir := t.TempDir() + string(filepath.Separator)
+	folderCount, filesPerFolder := 40, 300
+
+	for index := range folderCount {
+		folder := filepath.Join(baseDir, fmt.Sprintf("folder%d", index), "sub")
+
+		if err := os.MkdirAll(folder, 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		for file := range filesPerFolder {
+			extension := ".txt"
+			if file%2 == 0 {
+				extension = ".md"
+			}
+
+			if err := os.WriteFile(filepath.Join(folder, fmt.Sprintf("file%d%s", file, extension)), nil, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if folderCount*filesPerFolder <= sqliteBatchSize {
+		t.Fatal("the files fit into a single transaction")
+	}
+
+	conf := testConfig(t, baseDir, 4)
+	conf.Scopes[0].Storage = config.StorageSQLite
+
+	fs, dirs := newTestFilesystem(t, conf)
+
+	counts := countIndex(t, dirs)
+	if counts["folder"] != 2*folderCount || counts[".txt"] != folderCount*filesPerFolder/2 || counts[".md"] != folderCount*filesPerFolder/2 {
+		t.Fatalf("the index has %v, instead of %d folders and %d files of each extension", counts, 2*folderCount, folderCount*filesPerFolder/2)
+	}
+
+	// a folder has its files and the folders inside of it as its entries
+	previous := dirs.store.snapshot()
+
+	snapshot, ok := previous.lookup(filepath.Join(baseDir, "folder0") + string(filepath.Separator))
+	if !ok || len(snapshot.entries) != 1 || !snapshot.entries[0].isFolder || snapshot.stat.ModTime == 0 {
+		t.Fatalf("expected folder0 with its DirStat and sub as its only entry, got %v", snapshot)
+	}
+
+	snapshot, ok = previous.lookup(filepath.Join(baseDir, "folder0", "sub") + string(filepath.Separator))
+	if !ok || len(snapshot.entries) != filesPerFolder {
+		t.Fatalf("expected the %d files of folder0/sub, got %v", filesPerFolder, snapshot)
+	}
+
+	previous.close()
+
+	if err := os.RemoveAll(filepath.Join(baseDir, "folder1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(baseDir, "folder0", "new.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs.Update(dirs, false)
+
+	counts = countIndex(t, dirs)
+	if counts["folder"] != 2*(folderCount-1) || counts[".txt"] != (folderCount-1)*filesPerFolder/2+1 {
+		t.Fatalf("after the update the index has %v, instead of %d folders and %d .txt files", counts, 2*(folderCount-1), (folderCount-1)*filesPerFolder/2+1)
+	}
+
+	previous = dirs.store.snapshot()
+	defer previous.close()
+
+	if _, ok := previous.lookup(filepath.Join(baseDir, "folder1") + string(filepath.Separator)); ok {
+		t.Fatal("folder1 is still part of the last update")
+	}
+
+	// a single query returns the files of all the extensions it was asked for, and only those
+	index := dirs.Index()
+	defer index.Release()
+
+	found := []string{}
+
+	err := index.Query([]string{".txt", "folder"}, 0, [8]byte{}, "", func(extension string, file File) bool {
+		if !slices.Contains(found, extension) {
+			found = append(found, extension)
+		}
+
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slices.Sort(found)
+
+	if !slices.Equal(found, []string{".txt", "folder"}) {
+		t.Fatalf("the query for .txt and folder returned %v", found)
+	}
+}
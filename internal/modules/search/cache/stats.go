@@ -0,0 +1,211 @@
+// Package cache handles everything that has to do with the generation of the cache for the Search function, to the generation of our folder structure and importing of the config.
+package cache
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/skillptm/Bolt/internal/config"
+	"github.com/skillptm/Bolt/internal/util"
+)
+
+const (
+	maxUnreadableDirs int = 1000 // the rest of the unreadable folders are only counted
+	maxTruncatedDirs  int = 1000 // the rest of the truncated folders are only counted
+
+	statsSaveDelay time.Duration = 10 * time.Second // like the journal, the counts the watcher changes are written together
+
+	truncatedByMaxDepth      string = "MaxDepth"      // the folder is deeper than the MaxDepth, so it wasn't read
+	truncatedByMaxDirEntries string = "MaxDirEntries" // the folder has more entries than the MaxDirEntries, the rest of them wasn't indexed
+	truncatedByMaxEntries    string = "MaxEntries"    // the scope ran out of its MaxEntries in or before this folder
+)
+
+// IndexStats holds the statistics of the last update of a scope, with the files and folders the watcher added and removed since counted in. They're stored next to its cache, so they can be read without Bolt running
+type IndexStats struct {
+	Scope             string         `json:"Scope"`
+	Files             int            `json:"Files"`
+	Folders           int            `json:"Folders"`
+	Extensions        map[string]int `json:"Extensions"`
+	UnreadableDirs    []string       `json:"UnreadableDirs"` // folders we didn't have the permissions to read
+	UnreadableCount   int            `json:"UnreadableCount"`
+	TruncatedDirs     []TruncatedDir `json:"TruncatedDirs"` // folders that weren't fully indexed, because of the limits of the scope
+	TruncatedCount    int            `json:"TruncatedCount"`
+	CrawlMilliseconds int64          `json:"CrawlMilliseconds"`
+	LastUpdate        time.Time      `json:"LastUpdate"` // the last full crawl, the watcher keeps the scope up to date in between
+	LastChange        time.Time      `json:"LastChange"` // the last time the watcher or a crawl changed the index
+	Watched           bool           `json:"Watched"`    // if the scope is kept up to date by its watcher
+	CacheSize         int64          `json:"CacheSize"`  // in bytes, it's read from the disk when the stats are requested
+}
+
+// TruncatedDir is a folder that wasn't fully indexed, with the limit that stopped it
+type TruncatedDir struct {
+	Dir    string `json:"Dir"`
+	Reason string `json:"Reason"`
+}
+
+// IndexStats returns the IndexStats of all scopes
+func (fs *Filesystem) IndexStats() []IndexStats {
+	output := []IndexStats{}
+
+	for _, dirs := range fs.Scopes {
+		output = append(output, dirs.IndexStats())
+	}
+
+	return output
+}
+
+// IndexStats returns the IndexStats of the last update of the Dirs
+func (dirs *Dirs) IndexStats() IndexStats {
+	dirs.indexStatsMu.Lock()
+	stats := dirs.indexStats
+	stats.Extensions = maps.Clone(stats.Extensions)
+	stats.UnreadableDirs = slices.Clone(stats.UnreadableDirs)
+	stats.TruncatedDirs = slices.Clone(stats.TruncatedDirs)
+	dirs.indexStatsMu.Unlock()
+
+	stats.Scope = dirs.Name
+	stats.Watched = dirs.watcher.live()
+	stats.CacheSize = fileSize(dirs.CachePath)
+
+	return stats
+}
+
+// LoadIndexStats reads the IndexStats of all scopes in the config from the disk, for when there is no Filesystem to ask. Scopes that were never updated only have their name set
+func LoadIndexStats(conf *config.Config) []IndexStats {
+	output := []IndexStats{}
+
+	for _, scope := range conf.Scopes {
+		stats := IndexStats{}
+
+		// a missing or broken stats file just means we don't know anything about the scope yet
+		util.GetJSON(statsPath(conf.Paths["cache"], scope.Name), &stats)
+
+		stats.Scope = scope.Name
+		stats.CacheSize = fileSize(storePath(conf.Paths["cache"], scope))
+
+		output = append(output, stats)
+	}
+
+	return output
+}
+
+// setIndexStats stores the IndexStats of an update on the Dirs and on the disk
+func (dirs *Dirs) setIndexStats(stats IndexStats) {
+	stats.Scope = dirs.Name
+	stats.Watched = dirs.watcher.live()
+
+	dirs.indexStatsMu.Lock()
+	defer dirs.indexStatsMu.Unlock()
+
+	// the counts of the watcher are part of the crawl
+	if dirs.indexStatsTimer != nil {
+		dirs.indexStatsTimer.Stop()
+		dirs.indexStatsTimer = nil
+	}
+
+	dirs.indexStats = stats
+
+	util.OverwriteJSON(dirs.statsPath, true, stats)
+}
+
+// countChanges counts the files and folders the watcher added and removed onto the IndexStats. They're written to the disk a little later, together with the ones that follow them
+func (dirs *Dirs) countChanges(changes []changedFile, now time.Time) {
+	dirs.indexStatsMu.Lock()
+	defer dirs.indexStatsMu.Unlock()
+
+	// before the first update there is nothing to count onto, the crawl counts everything anyway
+	if dirs.indexStats.LastUpdate.IsZero() {
+		return
+	}
+
+	for _, change := range changes {
+		switch change.kind {
+		case ChangeAdded:
+			dirs.indexStats.count(strings.ToLower(change.item.extension), change.item.isFolder, 1)
+		case ChangeDeleted:
+			dirs.indexStats.count(strings.ToLower(change.item.extension), change.item.isFolder, -1)
+		}
+	}
+
+	dirs.indexStats.LastChange = now
+
+	if dirs.indexStatsTimer == nil {
+		dirs.indexStatsTimer = time.AfterFunc(statsSaveDelay, func() {
+			dirs.indexStatsMu.Lock()
+			defer dirs.indexStatsMu.Unlock()
+
+			dirs.indexStatsTimer = nil
+			util.OverwriteJSON(dirs.statsPath, true, dirs.indexStats)
+		})
+	}
+}
+
+// flushIndexStats writes the counts of the watcher, that are still waiting for their save
+func (dirs *Dirs) flushIndexStats() {
+	dirs.indexStatsMu.Lock()
+	defer dirs.indexStatsMu.Unlock()
+
+	if dirs.indexStatsTimer != nil && dirs.indexStatsTimer.Stop() {
+		dirs.indexStatsTimer = nil
+		util.OverwriteJSON(dirs.statsPath, true, dirs.indexStats)
+	}
+}
+
+// count adds an item to the IndexStats, or removes it with a negative delta
+func (stats *IndexStats) count(extension string, isFolder bool, delta int) {
+	if isFolder {
+		stats.Folders += delta
+		return
+	}
+
+	stats.Files += delta
+	stats.Extensions[extension] += delta
+
+	if stats.Extensions[extension] <= 0 {
+		delete(stats.Extensions, extension)
+	}
+}
+
+// unreadable records a folder of the traversal, that we didn't have the permissions to read
+func (t *traversal) unreadable(dirPath string) {
+	t.statsMu.Lock()
+	defer t.statsMu.Unlock()
+
+	t.unreadableCount++
+
+	if len(t.unreadableDirs) < maxUnreadableDirs {
+		t.unreadableDirs = append(t.unreadableDirs, dirPath)
+	}
+}
+
+// truncated records a folder of the traversal, that wasn't fully indexed because of the limit in reason
+func (t *traversal) truncated(dirPath string, reason string) {
+	t.statsMu.Lock()
+	defer t.statsMu.Unlock()
+
+	t.truncatedCount++
+
+	if len(t.truncatedDirs) < maxTruncatedDirs {
+		t.truncatedDirs = append(t.truncatedDirs, TruncatedDir{dirPath, reason})
+	}
+}
+
+// statsPath returns the path of the IndexStats file of a scope
+func statsPath(cacheDir string, name string) string {
+	return filepath.Join(cacheDir, fmt.Sprintf("%s_stats.json", name))
+}
+
+// fileSize returns the size of the file at filePath, or 0 if it doesn't exist
+func fileSize(filePath string) int64 {
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return 0
+	}
+
+	return fileInfo.Size()
+}
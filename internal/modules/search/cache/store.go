@@ -0,0 +1,270 @@
+// Package cache handles everything that has to do with the generation of the cache for the Search function, to the generation of our folder structure and importing of the config.
+package cache
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/skillptm/Bolt/internal/config"
+)
+
+// Index is the view of the files of a Dirs at one point in time. A search gets it once and keeps using it until it's done, no matter what updates and the watcher change in the meantime, then it has to Release it
+type Index interface {
+	// Query calls yield for the files with one of the extensions (or any extension, if there are none), whose names are at least minLength bytes long and have all the chars of encoded, until yield returns false.
+	// query is the normalized search, which lets the Index narrow the files down further, if it can
+	Query(extensions []string, minLength int, encoded [8]byte, query string, yield func(extension string, file File) bool) error
+	// Path returns the path of the folder with the pathKey, for the files Query yielded
+	Path(pathKey int) string
+	// Release frees what the Index holds on to
+	Release()
+}
+
+/*
+indexStore is where a Dirs keeps its files. The memory store keeps all of them in a DirMap, the sqlite store keeps them in a database on the disk and only loads the ones a search asks for.
+
+The methods that change the store are only called while holding the mu of the Dirs, index can be called at any time.
+*/
+type indexStore interface {
+	// batch starts the changes of a batch of watcher events, it's only called while the store is loaded
+	batch() storeBatch
+	// data returns everything in the store, loaded or not
+	data() (*cacheData, error)
+	// index returns the Index for a search, or nil if the store isn't loaded
+	index() Index
+	// load makes the store searchable. If the stored index can't be read the store starts out empty and the error is returned
+	load() error
+	// loaded checks, if the store is searchable
+	loaded() bool
+	// snapshot returns what the store had on the last update, loaded or not, for the traversal of the next one to compare with. The caller has to close it
+	snapshot() previousIndex
+	// unload frees what load took up
+	unload()
+	// update starts taking the results of an update, which replace everything that was stored once they're published
+	update() storeUpdate
+}
+
+// storeUpdate takes the results of an update. Searches keep seeing what the store had before, until it's published. An error is kept until publish, like for the storeBatch
+type storeUpdate interface {
+	// add stores a file or folder the update found
+	add(item basicFile)
+	// addStats stores the DirStats of the folders, once all results were added
+	addStats(stats map[string]DirStat)
+	// discard drops the results, if they won't be published
+	discard()
+	// publish replaces everything that was stored with the results. It's only called while holding the mu of the Dirs
+	publish() error
+}
+
+// storeBatch applies the changes of a batch of watcher events to an indexStore, which only sees them once they're committed. An error is kept until commit, so we don't have to check after every change
+type storeBatch interface {
+	// insert adds an item, or updates its Metadata if it's already stored. It returns the item as added or modified, or nothing if it didn't change
+	insert(item basicFile) []changedFile
+	// delete removes an item, for folders together with everything inside of them. It returns everything it removed as deleted
+	delete(item basicFile) []changedFile
+	commit() error
+}
+
+// newIndexStore returns the indexStore the Storage of the scope asks for
+func newIndexStore(conf *config.Config, scope config.Scope) indexStore {
+	if scope.Storage == config.StorageSQLite {
+		return &sqliteStore{path: storePath(conf.Paths["cache"], scope)}
+	}
+
+	return &memoryStore{cachePath: storePath(conf.Paths["cache"], scope), ignoreDiacritics: conf.IgnoreDiacritics, useTrigrams: conf.TrigramIndex}
+}
+
+// Index returns the current Index of the Dirs, or nil if its cache isn't imported. The caller has to Release it
+func (dirs *Dirs) Index() Index {
+	return dirs.store.index()
+}
+
+// imported checks, if the cache of the Dirs is imported
+func (dirs *Dirs) imported() bool {
+	return dirs.store.loaded()
+}
+
+// Ready returns a channel, that's closed once the cache of the Dirs is imported
+func (dirs *Dirs) Ready() <-chan struct{} {
+	dirs.readyMu.Lock()
+	defer dirs.readyMu.Unlock()
+
+	return dirs.ready
+}
+
+// isReady checks, if the ready channel is closed. It's meant to be called while holding the mu, which the channel is only replaced under
+func (dirs *Dirs) isReady() bool {
+	select {
+	case <-dirs.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// Import imports the cache of the Dirs from the disk, applies the events the watcher reported since and marks the Dirs as Ready. A Dirs that's already imported is left as it is.
+// If the cache can't be read, the Dirs starts out empty and the error is returned, so the caller can have it rebuilt
+func (dirs *Dirs) Import() error {
+	dirs.mu.Lock()
+	defer dirs.mu.Unlock()
+
+	// a store that couldn't be loaded at all still marks the Dirs as Ready, so that's what we check
+	if dirs.isReady() {
+		return nil
+	}
+
+	err := dirs.store.load()
+	if err != nil {
+		err = fmt.Errorf("Import: couldn't load the index of scope %s:\n--> %w", dirs.Name, err)
+	}
+
+	dirs.loadContent()
+	dirs.loadTags()
+
+	// the contents of the files that changed while the cache wasn't imported are only known now
+	dirs.queueDocs(dirs.replayEvents())
+
+	dirs.readyMu.Lock()
+	close(dirs.ready)
+	dirs.readyMu.Unlock()
+
+	return err
+}
+
+// Clear removes the imported cache data from memory. Searches that still use an Index of it keep it until they're done
+func (dirs *Dirs) Clear() {
+	dirs.mu.Lock()
+	defer dirs.mu.Unlock()
+
+	if !dirs.isReady() {
+		return
+	}
+
+	dirs.store.unload()
+	dirs.content.Store(nil)
+	dirs.tags.Store(nil)
+
+	dirs.readyMu.Lock()
+	dirs.ready = make(chan struct{})
+	dirs.readyMu.Unlock()
+}
+
+// publish stores the results of an update and applies the events the watcher reported during it, if the cache is imported. The results of a volume, that was unplugged during the update, are dropped
+func (dirs *Dirs) publish(update storeUpdate) {
+	dirs.mu.Lock()
+	defer dirs.mu.Unlock()
+
+	if !dirs.volume.mounted() {
+		update.discard()
+		return
+	}
+
+	// like the cache file, a store we couldn't write is simply rewritten on the next update
+	if update.publish() != nil {
+		return
+	}
+
+	if dirs.imported() {
+		dirs.queueDocs(dirs.replayEvents())
+	}
+}
+
+// replayEvents applies all events the watcher reported since the last update onto the store and returns what changed. It's meant to be called, while holding the mu, after the cache was imported
+func (dirs *Dirs) replayEvents() []changedFile {
+	dirs.eventsMu.Lock()
+	defer dirs.eventsMu.Unlock()
+
+	if len(dirs.pending) == 0 {
+		return nil
+	}
+
+	batch := dirs.store.batch()
+	changes := []changedFile{}
+
+	for _, event := range dirs.pending {
+		changes = append(changes, applyEvent(batch, event)...)
+	}
+
+	// a batch that couldn't be stored is part of the next update anyway
+	if batch.commit() != nil {
+		return nil
+	}
+
+	return changes
+}
+
+// apply stores the events until the next update and applies them directly, if the cache is imported. It returns the amount of events waiting for the next update
+func (dirs *Dirs) apply(events []fsEvent) int {
+	dirs.mu.Lock()
+	defer dirs.mu.Unlock()
+
+	dirs.eventsMu.Lock()
+	defer dirs.eventsMu.Unlock()
+
+	dirs.pending = append(dirs.pending, events...)
+
+	// without an imported cache we can't tell what changed, the diff of the next update takes care of that instead
+	if dirs.imported() {
+		batch := dirs.store.batch()
+		changes := []changedFile{}
+
+		for _, event := range events {
+			changes = append(changes, applyEvent(batch, event)...)
+		}
+
+		if batch.commit() == nil {
+			now := time.Now()
+			dirs.countChanges(changes, now)
+			dirs.queueDocs(changes)
+
+			// the members of archives come and go with their archive, which the journal already lists
+			changes = slices.DeleteFunc(changes, func(change changedFile) bool {
+				return change.item.inArchive()
+			})
+
+			dirs.journal.record(journalChanges(changes, now, now), true)
+		}
+	}
+
+	return len(dirs.pending)
+}
+
+// applyEvent adds or removes the file of a single event to/from the batch and returns what changed
+func applyEvent(batch storeBatch, event fsEvent) []changedFile {
+	if event.removed {
+		return batch.delete(event.file)
+	}
+
+	return batch.insert(event.file)
+}
+
+// removeUnusedStore removes the files of the Storage the scope doesn't use
+func removeUnusedStore(cacheDir string, scope config.Scope) {
+	unused := scope
+	unused.Storage = config.StorageSQLite
+
+	if scope.Storage == config.StorageSQLite {
+		unused.Storage = config.StorageMemory
+	}
+
+	// a database comes with its write-ahead log and the shared memory file for it
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		os.Remove(storePath(cacheDir, unused) + suffix)
+	}
+}
+
+// storePath returns the path of the file the index of a scope is stored in, which depends on its Storage
+func storePath(cacheDir string, scope config.Scope) string {
+	if scope.Storage == config.StorageSQLite {
+		return filepath.Join(cacheDir, fmt.Sprintf("%s_index.db", scope.Name))
+	}
+
+	return cachePath(cacheDir, scope.Name)
+}
+
+// cachePath returns the path of the binary cache file of a scope
+func cachePath(cacheDir string, name string) string {
+	return filepath.Join(cacheDir, fmt.Sprintf("%s_cache.bin", name))
+}
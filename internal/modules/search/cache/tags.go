@@ -0,0 +1,287 @@
+// Package cache handles everything that has to do with the generation of the cache for the Search function, to the generation of our folder structure and importing of the config.
+package cache
+
+import (
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/skillptm/Bolt/internal/config"
+	"github.com/skillptm/Bolt/internal/modules/search/extract"
+)
+
+/*
+The tag index file uses the same header as the cache file, with its own magic. The body is laid out as follows:
+
+docs: count uint32 | count * (path string | modTime int64 | size int64 | tag count uint32 | tag count * (key string | value string))
+*/
+const (
+	tagsMagic        string = "BLTT"
+	tagsVersion      uint16 = 1
+	tagDocRecordSize int    = 21
+	tagRecordSize    int    = 2
+)
+
+/*
+tagIndex holds the tags of the photos, music and documents of a Dirs. Like the contentIndex it's never changed after it was built.
+
+docs: []tagDoc{contentDoc{path, modTime, size}, Tags}
+*/
+type tagIndex struct {
+	docs []tagDoc
+}
+
+// tagDoc is a file in the tagIndex
+type tagDoc struct {
+	contentDoc
+	tags extract.Tags
+}
+
+// TagMatch is a file, whose tags matched all tag filters of a search
+type TagMatch struct {
+	Path  string
+	Exact int // how many of the tag filters are exactly the tag, instead of a part of it
+	Tags  extract.Tags
+}
+
+// newTagDirs returns the folders from the TagIndex in the config, it returns nil if the TagIndex is disabled
+func newTagDirs(conf config.TagIndex) []string {
+	if !conf.Enabled {
+		return nil
+	}
+
+	tagDirs := []string{}
+
+	for _, dir := range conf.Dirs {
+		if !strings.HasSuffix(dir, string(filepath.Separator)) {
+			dir += string(filepath.Separator)
+		}
+
+		tagDirs = append(tagDirs, dir)
+	}
+
+	return tagDirs
+}
+
+// wantsTags checks, if the tags of the item should be extracted
+func (dirs *Dirs) wantsTags(item basicFile) bool {
+	if item.isFolder || !extract.Supported(item.extension) || item.inArchive() {
+		return false
+	}
+
+	for _, dir := range dirs.tagDirs {
+		if strings.HasPrefix(item.path, dir) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// updateTags builds the new tagIndex of the Dirs from the files an update found. Only files that changed since the last update are read again
+func (dirs *Dirs) updateTags(files []basicFile) {
+	if dirs.tagDirs == nil {
+		return
+	}
+
+	previous := dirs.tags.Load()
+
+	if !dirs.imported() || previous == nil {
+		var err error
+
+		// without a readable tag index every file gets read again
+		previous, err = readTags(dirs.tagsPath)
+		if err != nil {
+			previous = &tagIndex{}
+		}
+	}
+
+	index := previous.update(files)
+
+	// like the cache, a tag index we couldn't write is simply rebuilt on the next update
+	writeTags(dirs.tagsPath, index)
+
+	// a Clear during the update would otherwise be undone
+	dirs.mu.Lock()
+	if dirs.imported() {
+		dirs.tags.Store(index)
+	}
+	dirs.mu.Unlock()
+}
+
+// patchTags reads the tags of the pending files into the tagIndex of the Dirs and drops the removed ones from it
+func (dirs *Dirs) patchTags(pending map[string]pendingDoc) {
+	if dirs.tagDirs == nil {
+		return
+	}
+
+	previous := dirs.tags.Load()
+
+	if !dirs.imported() || previous == nil {
+		var err error
+
+		// without a tag index, there's nothing to patch. The next update builds it
+		previous, err = readTags(dirs.tagsPath)
+		if err != nil {
+			return
+		}
+	}
+
+	files := make([]basicFile, 0, len(previous.docs)+len(pending))
+
+	for _, doc := range previous.docs {
+		if _, ok := pending[doc.path]; !ok {
+			files = append(files, doc.file())
+		}
+	}
+
+	for _, doc := range pending {
+		if !doc.removed && dirs.wantsTags(doc.item) {
+			files = append(files, doc.item)
+		}
+	}
+
+	index := previous.update(files)
+
+	writeTags(dirs.tagsPath, index)
+
+	dirs.mu.Lock()
+	if dirs.imported() {
+		dirs.tags.Store(index)
+	}
+	dirs.mu.Unlock()
+}
+
+// update returns a new tagIndex for the files. Files with the same modTime and size as in the tagIndex keep their tags, the others are read again
+func (index *tagIndex) update(files []basicFile) *tagIndex {
+	previousDocs := make(map[string]tagDoc, len(index.docs))
+	for _, doc := range index.docs {
+		previousDocs[doc.path] = doc
+	}
+
+	newIndex := tagIndex{docs: make([]tagDoc, 0, len(files))}
+
+	for _, item := range files {
+		doc := tagDoc{contentDoc: contentDoc{fmt.Sprintf("%s%s%s", item.path, item.name, item.extension), item.metadata.ModTime, item.metadata.Size}}
+
+		if previousDoc, ok := previousDocs[doc.path]; ok && previousDoc.contentDoc == doc.contentDoc {
+			newIndex.docs = append(newIndex.docs, previousDoc)
+			continue
+		}
+
+		tags, err := extract.File(doc.path, item.extension)
+		// files we couldn't get any tags from are stored without them, so they're only read again once they change
+		if err != nil {
+			tags = extract.Tags{}
+		}
+
+		doc.tags = tags
+		newIndex.docs = append(newIndex.docs, doc)
+	}
+
+	return &newIndex
+}
+
+// SearchTags returns the files in the tag index of the Dirs, that have every tag of the filters containing its value. The values have to be normalized already
+func (dirs *Dirs) SearchTags(filters map[string]string) []TagMatch {
+	index := dirs.tags.Load()
+	if index == nil || len(filters) == 0 {
+		return []TagMatch{}
+	}
+
+	output := []TagMatch{}
+
+	for _, doc := range index.docs {
+		match := TagMatch{Path: doc.path, Tags: doc.tags}
+
+		for key, value := range filters {
+			tag := Normalize(doc.tags[key], dirs.ignoreDiacritics)
+
+			if !strings.Contains(tag, value) {
+				match.Exact = -1
+				break
+			}
+
+			if tag == value {
+				match.Exact++
+			}
+		}
+
+		if match.Exact >= 0 {
+			output = append(output, match)
+		}
+	}
+
+	return output
+}
+
+// loadTags imports the tag index file from the disk. A tag index that can't be read is left empty, until the next update rebuilds it
+func (dirs *Dirs) loadTags() {
+	if dirs.tagDirs == nil {
+		return
+	}
+
+	index, err := readTags(dirs.tagsPath)
+	if err != nil {
+		index = &tagIndex{}
+	}
+
+	dirs.tags.Store(index)
+}
+
+// writeTags writes the tagIndex in the binary tag format to tagsPath
+func writeTags(tagsPath string, index *tagIndex) error {
+	return writeBinary(tagsPath, tagsMagic, tagsVersion, func(cw *cacheWriter) {
+		cw.uint32(uint32(len(index.docs)))
+
+		for _, doc := range index.docs {
+			cw.string(doc.path)
+			cw.uint64(uint64(doc.modTime))
+			cw.uint64(uint64(doc.size))
+			cw.uint32(uint32(len(doc.tags)))
+
+			// sorted, so the same tags always give the same file
+			for _, key := range slices.Sorted(maps.Keys(doc.tags)) {
+				cw.string(key)
+				cw.string(doc.tags[key])
+			}
+		}
+	})
+}
+
+// readTags reads a binary tag index file
+func readTags(tagsPath string) (*tagIndex, error) {
+	index := tagIndex{}
+
+	err := readBinary(tagsPath, tagsMagic, tagsVersion, func(cr *cacheReader) {
+		docCount := cr.count(tagDocRecordSize)
+		index.docs = make([]tagDoc, 0, docCount)
+
+		for range docCount {
+			doc := tagDoc{contentDoc: contentDoc{cr.string(), int64(cr.uint64()), int64(cr.uint64())}, tags: extract.Tags{}}
+
+			for range cr.count(tagRecordSize) {
+				key := cr.string()
+				doc.tags[key] = cr.string()
+			}
+
+			if cr.err != nil {
+				return
+			}
+
+			index.docs = append(index.docs, doc)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("readTags: couldn't read tag index file:\n--> %w", err)
+	}
+
+	return &index, nil
+}
+
+// tagsPath returns the path of the tag index file of a scope
+func tagsPath(cacheDir string, name string) string {
+	return filepath.Join(cacheDir, fmt.Sprintf("%s_tags.bin", name))
+}
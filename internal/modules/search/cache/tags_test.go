@@ -0,0 +1,59 @@
+// Package cache handles everything that has to do with the generation of the cache for the Search function, to the generation of our folder structure and importing of the config.
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/skillptm/Bolt/internal/config"
+)
+
+// id3v1 returns an MP3 without any audio, that only has an ID3v1 tag with the artist
+func id3v1(artist string) []byte {
+	tag := make([]byte, 128)
+
+	copy(tag[0:3], "TAG")
+	copy(tag[33:63], artist)
+
+	return tag
+}
+
+// TestTagsFollowWatcher makes sure files that are created, changed and removed while the watcher keeps the Dirs up to date reach the tag index without an update
+func TestTagsFollowWatcher(t *testing.T) {
+	baseDir := t.TempDir() + string(filepath.Separator)
+	trackPath := filepath.Join(baseDir, "track03.mp3")
+
+	conf := testConfig(t, baseDir, 2)
+	conf.TagIndex = config.TagIndex{Enabled: true, Dirs: []string{baseDir}}
+
+	_, dirs := newTestFilesystem(t, conf)
+
+	if !dirs.watcher.live() {
+		t.Skip("no inotify watcher available")
+	}
+
+	if err := os.WriteFile(trackPath, id3v1("radiohead"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, "the new file never reached the tag index", func() bool {
+		return len(dirs.SearchTags(map[string]string{"artist": "radiohead"})) == 1
+	})
+
+	if err := os.WriteFile(trackPath, id3v1("portishead"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, "the changed file never got its new tags", func() bool {
+		return len(dirs.SearchTags(map[string]string{"artist": "portishead"})) == 1 && len(dirs.SearchTags(map[string]string{"artist": "radiohead"})) == 0
+	})
+
+	if err := os.Remove(trackPath); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, "the removed file stayed in the tag index", func() bool {
+		return len(dirs.SearchTags(map[string]string{"artist": "portishead"})) == 0
+	})
+}
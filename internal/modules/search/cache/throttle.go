@@ -0,0 +1,153 @@
+// Package cache handles everything that has to do with the generation of the cache for the Search function, to the generation of our folder structure and importing of the config.
+package cache
+
+import (
+	"bytes"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/skillptm/Bolt/internal/config"
+)
+
+const (
+	loadAvgPath       string        = "/proc/loadavg"
+	loadCheckInterval time.Duration = time.Second
+	loadPauseInterval time.Duration = 5 * time.Second // the kernel only updates the load average every 5 seconds
+
+	ioprioWhoProcess int = 1 // with 0 as the id this is the calling thread
+	ioprioClassIdle  int = 3
+	ioprioClassShift int = 13
+)
+
+// throttle slows the background updates down according to the Throttle from the config. A nil throttle doesn't slow anything down, which is what the forced updates use
+type throttle struct {
+	idleIO          bool
+	loadAverage     float64
+	loadCheck       time.Time
+	loadMu          sync.Mutex
+	maxLoadAverage  float64
+	nextReadDir     time.Time
+	nice            int
+	readDirInterval time.Duration
+	readDirMu       sync.Mutex
+	stopChan        chan bool // closed when the Filesystem is, so a paused crawl doesn't keep it waiting
+}
+
+// newThrottle converts the Throttle from the config into a throttle, it returns nil if there is nothing to throttle
+func newThrottle(conf *config.Throttle, stopChan chan bool) *throttle {
+	if conf == nil || !conf.IdleIO && conf.Nice == 0 && conf.MaxReadDirsPerSecond == 0 && conf.MaxLoadAverage == 0 {
+		return nil
+	}
+
+	t := throttle{
+		idleIO:         conf.IdleIO,
+		maxLoadAverage: conf.MaxLoadAverage,
+		nice:           conf.Nice,
+		stopChan:       stopChan,
+	}
+
+	if conf.MaxReadDirsPerSecond > 0 {
+		t.readDirInterval = time.Second / time.Duration(conf.MaxReadDirsPerSecond)
+	}
+
+	return &t
+}
+
+// lowerPriority gives the calling goroutine a thread of its own and lowers the I/O and CPU priority of that thread, so it's only meant for goroutines that run a part of a background update and then return.
+// The thread is never unlocked, so once the goroutine returns the thread is thrown away, instead of going back to the runtime with the lower priority. Restoring it isn't an option, as a thread can't become less nice again without privileges.
+// Errors are ignored, the thread then simply keeps the priority it had
+func (t *throttle) lowerPriority() {
+	if t == nil {
+		return
+	}
+
+	runtime.LockOSThread()
+
+	if t.idleIO {
+		syscall.Syscall(syscall.SYS_IOPRIO_SET, uintptr(ioprioWhoProcess), 0, uintptr(ioprioClassIdle<<ioprioClassShift))
+	}
+
+	// Getpriority returns 20 - niceness, and without privileges we can only become nicer than we already are
+	if priority, err := syscall.Getpriority(syscall.PRIO_PROCESS, 0); err == nil && t.nice > 20-priority {
+		syscall.Setpriority(syscall.PRIO_PROCESS, 0, t.nice)
+	}
+}
+
+// pause blocks while the load average of the system is above the maxLoadAverage, or until the Filesystem is closed
+func (t *throttle) pause() {
+	if t == nil || t.maxLoadAverage == 0 {
+		return
+	}
+
+	for t.overloaded() {
+		select {
+		case <-time.After(loadPauseInterval):
+		case <-t.stopChan:
+			return
+		}
+	}
+}
+
+// overloaded checks, if the load average is above the maxLoadAverage. /proc/loadavg is read at most once every loadCheckInterval, the workers in between share the last value
+func (t *throttle) overloaded() bool {
+	t.loadMu.Lock()
+	defer t.loadMu.Unlock()
+
+	if time.Since(t.loadCheck) >= loadCheckInterval {
+		t.loadAverage = readLoadAverage()
+		t.loadCheck = time.Now()
+	}
+
+	return t.loadAverage > t.maxLoadAverage
+}
+
+// readDir blocks until the next folder may be read, so all workers together stay below the MaxReadDirsPerSecond
+func (t *throttle) readDir() {
+	if t == nil || t.readDirInterval == 0 {
+		return
+	}
+
+	t.readDirMu.Lock()
+	now := time.Now()
+	wait := t.nextReadDir.Sub(now)
+
+	if t.nextReadDir.Before(now) {
+		t.nextReadDir = now
+	}
+
+	t.nextReadDir = t.nextReadDir.Add(t.readDirInterval)
+	t.readDirMu.Unlock()
+
+	if wait <= 0 {
+		return
+	}
+
+	select {
+	case <-time.After(wait):
+	case <-t.stopChan:
+	}
+}
+
+// readLoadAverage returns the 1 minute load average from /proc/loadavg, or 0 if it can't be read, so the crawl never pauses because of it
+func readLoadAverage() float64 {
+	data, err := os.ReadFile(loadAvgPath)
+	if err != nil {
+		return 0
+	}
+
+	fields := bytes.Fields(data)
+	if len(fields) == 0 {
+		return 0
+	}
+
+	loadAverage, err := strconv.ParseFloat(string(fields[0]), 64)
+	if err != nil {
+		return 0
+	}
+
+	return loadAverage
+}
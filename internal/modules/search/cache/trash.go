@@ -0,0 +1,283 @@
+// Package cache handles everything that has to do with the generation of the cache for the Search function, to the generation of our folder structure and importing of the config.
+package cache
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	trashInfoExtension string = ".trashinfo"
+	trashDateLayout    string = "2006-01-02T15:04:05" // the DeletionDate is in local time, without a zone
+	trashPrefix        string = "trash ("
+	trashDateSuffix    string = ")"
+)
+
+// TrashEntry is a file or folder in one of the trashes of the freedesktop Trash spec, together with where it was deleted from
+type TrashEntry struct {
+	DeletionDate time.Time
+	OriginalPath string // folders end with a separator, like in the search results
+	TrashedPath  string // where it's kept in the trash now
+
+	infoPath string
+}
+
+// Path returns the path the /trash search lists the entry with. It's the OriginalPath behind "trash (<deletion date>)", so it can't be taken for a file that's still there, and two deletions of the same path can be told apart
+func (entry TrashEntry) Path() string {
+	return fmt.Sprintf("%s%s%s%s", trashPrefix, entry.DeletionDate.Format(trashDateLayout), trashDateSuffix, entry.OriginalPath)
+}
+
+// SplitTrashPath splits the path of a trash entry into its deletion date and the path it was deleted from. The bool is false, if the path isn't one of a trash entry
+func SplitTrashPath(filePath string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(filePath, trashPrefix)
+	if !ok {
+		return "", "", false
+	}
+
+	// the original path is absolute, so it starts with the separator the date is followed by
+	date, originalPath, ok := strings.Cut(rest, trashDateSuffix)
+
+	return date, originalPath, ok && filepath.IsAbs(originalPath)
+}
+
+/*
+trashDir is a trash of the freedesktop Trash spec. Every trash has a files folder with the deleted files and an info folder with a .trashinfo file for each of them.
+
+topDir: the folder relative paths in the .trashinfo files start from
+*/
+type trashDir struct {
+	path   string
+	topDir string
+}
+
+// trashSnapshot holds the entries of an info folder, together with the DirStat it had when they were read
+type trashSnapshot struct {
+	entries []TrashEntry
+	stat    DirStat
+}
+
+// Trash returns the entries of all trashes, the most recently deleted ones first. These are the trash in the home dir and the .Trash/$uid and .Trash-$uid folders at the top of the mounts we index.
+// The .trashinfo files of a trash are only read again, once its info folder changed
+func (fs *Filesystem) Trash() []TrashEntry {
+	output := []TrashEntry{}
+	found := make(map[string]*trashSnapshot)
+
+	fs.trashMu.Lock()
+	defer fs.trashMu.Unlock()
+
+	for _, dir := range fs.trashDirs() {
+		infoDir := filepath.Join(dir.path, "info")
+
+		stat, err := newDirStat(infoDir)
+		if err != nil {
+			continue
+		}
+
+		snapshot, ok := fs.trash[infoDir]
+		if !ok || snapshot.stat != stat {
+			snapshot = &trashSnapshot{readTrashInfos(dir), stat}
+		}
+
+		found[infoDir] = snapshot
+		output = append(output, snapshot.entries...)
+	}
+
+	// trashes that are gone, like the ones of an unmounted drive, are dropped
+	fs.trash = found
+
+	slices.SortStableFunc(output, func(a TrashEntry, b TrashEntry) int {
+		return cmp.Compare(b.DeletionDate.UnixNano(), a.DeletionDate.UnixNano())
+	})
+
+	return output
+}
+
+// FindTrash returns the TrashEntry the path from the /trash search stands for. Of deletions in the same second, the one listed first is taken
+func (fs *Filesystem) FindTrash(trashPath string) (TrashEntry, error) {
+	if _, _, ok := SplitTrashPath(trashPath); !ok {
+		return TrashEntry{}, fmt.Errorf("FindTrash: %s isn't the path of a trash entry", trashPath)
+	}
+
+	for _, entry := range fs.Trash() {
+		if entry.Path() == trashPath {
+			return entry, nil
+		}
+	}
+
+	return TrashEntry{}, fmt.Errorf("FindTrash: %s isn't in the trash anymore", trashPath)
+}
+
+// RestoreTrash moves the entry with the trashPath from the /trash search out of the trash and back to where it was deleted from and then removes its .trashinfo file, like the freedesktop Trash spec describes.
+// Missing parent folders are created again, but something that's at the OriginalPath by now is never overwritten
+func (fs *Filesystem) RestoreTrash(trashPath string) error {
+	entry, err := fs.FindTrash(trashPath)
+	if err != nil {
+		return fmt.Errorf("RestoreTrash: couldn't find the entry:\n--> %w", err)
+	}
+
+	target := strings.TrimSuffix(entry.OriginalPath, string(filepath.Separator))
+
+	if _, err := os.Lstat(target); err == nil {
+		return fmt.Errorf("RestoreTrash: couldn't restore %s, as something else is there by now", target)
+	}
+
+	err = os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return fmt.Errorf("RestoreTrash: couldn't create the parent folders of %s:\n--> %w", target, err)
+	}
+
+	// the trashes are on the same mount as the files in them, so this never has to copy
+	err = os.Rename(entry.TrashedPath, target)
+	if err != nil {
+		return fmt.Errorf("RestoreTrash: couldn't move %s back to %s:\n--> %w", entry.TrashedPath, target, err)
+	}
+
+	// a .trashinfo file without its file is ignored by every trash implementation, so that's not worth an error
+	os.Remove(entry.infoPath)
+
+	return nil
+}
+
+// trashDirs returns the trashes of the user, the home trash first. Mounts we don't index are skipped, as a stat on a dead network share can hang
+func (fs *Filesystem) trashDirs() []trashDir {
+	output := []trashDir{}
+
+	if dataDir, err := dataHome(); err == nil {
+		output = append(output, trashDir{filepath.Join(dataDir, "Trash"), dataDir})
+	}
+
+	uid := fmt.Sprint(os.Getuid())
+
+	for mountPoint, policy := range *fs.mounts.Load() {
+		if policy != indexMount && policy != volumeMount {
+			continue
+		}
+
+		// the shared .Trash only counts, if it's a real folder with the sticky bit set, otherwise anyone could have planted it
+		if fileInfo, err := os.Lstat(filepath.Join(mountPoint, ".Trash")); err == nil && fileInfo.IsDir() && fileInfo.Mode()&os.ModeSticky != 0 {
+			output = append(output, trashDir{filepath.Join(mountPoint, ".Trash", uid), mountPoint})
+		}
+
+		output = append(output, trashDir{filepath.Join(mountPoint, fmt.Sprintf(".Trash-%s", uid)), mountPoint})
+	}
+
+	return output
+}
+
+// dataHome returns $XDG_DATA_HOME, or ~/.local/share if it isn't set
+func dataHome() (string, error) {
+	if dataDir := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dataDir) {
+		return dataDir, nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("dataHome: couldn't access the user's home dir:\n--> %w", err)
+	}
+
+	return filepath.Join(homeDir, ".local", "share"), nil
+}
+
+// readTrashInfos reads the .trashinfo files of the trash. The ones that are broken or whose file is gone are skipped
+func readTrashInfos(dir trashDir) []TrashEntry {
+	output := []TrashEntry{}
+
+	dirEntries, err := os.ReadDir(filepath.Join(dir.path, "info"))
+	if err != nil {
+		return output
+	}
+
+	for _, dirEntry := range dirEntries {
+		name, ok := strings.CutSuffix(dirEntry.Name(), trashInfoExtension)
+		if !ok || dirEntry.IsDir() {
+			continue
+		}
+
+		entry, err := readTrashInfo(filepath.Join(dir.path, "info", dirEntry.Name()), dir.topDir)
+		if err != nil {
+			continue
+		}
+
+		entry.TrashedPath = filepath.Join(dir.path, "files", name)
+
+		fileInfo, err := os.Lstat(entry.TrashedPath)
+		if err != nil {
+			continue
+		}
+
+		if fileInfo.IsDir() {
+			entry.OriginalPath += string(filepath.Separator)
+		}
+
+		output = append(output, entry)
+	}
+
+	return output
+}
+
+/*
+readTrashInfo reads the original path and deletion date from a .trashinfo file. The Path is percent encoded like an URL and relative paths start from the topDir of the trash.
+
+The format of the file is:
+
+[Trash Info]
+Path=foo/bar/meow.bow-wow
+DeletionDate=2004-08-31T22:32:08
+*/
+func readTrashInfo(infoPath string, topDir string) (TrashEntry, error) {
+	entry := TrashEntry{infoPath: infoPath}
+
+	infoFile, err := os.Open(infoPath)
+	if err != nil {
+		return entry, fmt.Errorf("readTrashInfo: couldn't open %s:\n--> %w", infoPath, err)
+	}
+	defer infoFile.Close()
+
+	scanner := bufio.NewScanner(infoFile)
+	inGroup := false
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, "[") {
+			inGroup = line == "[Trash Info]"
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !inGroup || !ok {
+			continue
+		}
+
+		switch key {
+		case "Path":
+			originalPath, err := url.PathUnescape(value)
+			if err != nil {
+				return entry, fmt.Errorf("readTrashInfo: couldn't decode the Path of %s:\n--> %w", infoPath, err)
+			}
+
+			if !filepath.IsAbs(originalPath) {
+				originalPath = filepath.Join(topDir, originalPath)
+			}
+
+			entry.OriginalPath = filepath.Clean(originalPath)
+		case "DeletionDate":
+			// a missing or broken date only affects the order
+			entry.DeletionDate, _ = time.ParseInLocation(trashDateLayout, value, time.Local)
+		}
+	}
+
+	if entry.OriginalPath == "" {
+		return entry, errors.New("trashinfo file has no Path")
+	}
+
+	return entry, scanner.Err()
+}
@@ -0,0 +1,74 @@
+// Package cache handles everything that has to do with the generation of the cache for the Search function, to the generation of our folder structure and importing of the config.
+package cache
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestTrashPaths deletes the same file into the trash twice. Both deletions have to be listed with their own path, which leads back to the file the trash keeps for them and restores that one
+func TestTrashPaths(t *testing.T) {
+	baseDir := t.TempDir()
+	dataDir := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", dataDir)
+
+	originalPath := filepath.Join(baseDir, "docs", "report.pdf")
+	dates := map[string]string{"report.pdf": "2026-10-01T09:00:00", "report.2.pdf": "2026-10-02T09:00:00"}
+
+	for name, date := range dates {
+		if err := os.MkdirAll(filepath.Join(dataDir, "Trash", "info"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.MkdirAll(filepath.Join(dataDir, "Trash", "files"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(filepath.Join(dataDir, "Trash", "files", name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		info := fmt.Sprintf("[Trash Info]\nPath=%s\nDeletionDate=%s\n", originalPath, date)
+		if err := os.WriteFile(filepath.Join(dataDir, "Trash", "info", name+trashInfoExtension), []byte(info), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fs, _ := newTestFilesystem(t, testConfig(t, baseDir+string(filepath.Separator), 1))
+
+	entries := fs.Trash()
+	if len(entries) != 2 {
+		t.Fatalf("expected both deletions, got %v", entries)
+	}
+
+	for _, entry := range entries {
+		date, path, ok := SplitTrashPath(entry.Path())
+		if !ok || date != dates[filepath.Base(entry.TrashedPath)] || path != originalPath {
+			t.Fatalf("%s doesn't split into its deletion date and %s, got %s %s %t", entry.Path(), originalPath, date, path, ok)
+		}
+
+		found, err := fs.FindTrash(entry.Path())
+		if err != nil || found.TrashedPath != entry.TrashedPath {
+			t.Fatalf("%s doesn't lead back to %s, got %s %v", entry.Path(), entry.TrashedPath, found.TrashedPath, err)
+		}
+	}
+
+	if _, err := fs.FindTrash(originalPath); err == nil {
+		t.Fatal("a path that isn't in the trash was found there")
+	}
+
+	// the older deletion is listed last
+	if err := fs.RestoreTrash(entries[1].Path()); err != nil {
+		t.Fatal(err)
+	}
+
+	if content, err := os.ReadFile(originalPath); err != nil || string(content) != "report.pdf" {
+		t.Fatalf("expected the older deletion to be restored, got %q %v", content, err)
+	}
+
+	if left := fs.Trash(); len(left) != 1 || left[0].TrashedPath != entries[0].TrashedPath {
+		t.Fatalf("expected only the newer deletion to be left in the trash, got %v", left)
+	}
+}
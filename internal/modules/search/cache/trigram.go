@@ -0,0 +1,137 @@
+// Package cache handles everything that has to do with the generation of the cache for the Search function, to the generation of our folder structure and importing of the config.
+package cache
+
+// trigram is a sequence of three bytes from a normalized name
+type trigram [3]byte
+
+/*
+trigramIndex mirrors the buckets of a DirMap and stores for every trigram in a bucket the positions of the files whose normalized name contains it.
+The positions are sorted, so the lists of several trigrams can be intersected without extra work.
+
+trigramIndex: map[File Extension]map[File Length]map[trigram][]position in the DirMap bucket
+*/
+type trigramIndex map[string]map[int]trigramBucket
+
+// trigramBucket holds the posting lists of a single DirMap bucket
+type trigramBucket map[trigram][]int32
+
+// newTrigramIndex builds the trigramIndex for the whole dirMap
+func newTrigramIndex(dirMap map[string]map[int][]File, ignoreDiacritics bool) trigramIndex {
+	index := make(trigramIndex, len(dirMap))
+
+	for extension, lengths := range dirMap {
+		for length, files := range lengths {
+			index.indexBucket(extension, length, files, ignoreDiacritics)
+		}
+	}
+
+	return index
+}
+
+// indexBucket (re)builds the posting lists of a single DirMap bucket
+func (index trigramIndex) indexBucket(extension string, length int, files []File, ignoreDiacritics bool) {
+	if _, ok := index[extension]; !ok {
+		index[extension] = make(map[int]trigramBucket)
+	}
+
+	bucket := make(trigramBucket)
+
+	for position, file := range files {
+		bucket.add(Normalize(file.Name, ignoreDiacritics), int32(position))
+	}
+
+	index[extension][length] = bucket
+}
+
+// add appends the position to the posting lists of all trigrams in the normalized name
+func (bucket trigramBucket) add(name string, position int32) {
+	for _, gram := range trigrams(name) {
+		bucket[gram] = append(bucket[gram], position)
+	}
+}
+
+// candidates returns the positions of the files in a DirMap bucket, that contain all trigrams of the normalized query.
+// The bool is false, if the query is too short to have trigrams, in which case the whole bucket has to be searched
+func (index trigramIndex) candidates(extension string, length int, query string) ([]int32, bool) {
+	grams := trigrams(query)
+	if len(grams) == 0 {
+		return nil, false
+	}
+
+	bucket := index[extension][length]
+	lists := make([][]int32, 0, len(grams))
+
+	for _, gram := range grams {
+		postings, ok := bucket[gram]
+		if !ok {
+			return nil, true
+		}
+
+		lists = append(lists, postings)
+	}
+
+	// starting with the shortest list keeps the intersection as small as possible from the start
+	shortest := 0
+	for listIndex, list := range lists {
+		if len(list) < len(lists[shortest]) {
+			shortest = listIndex
+		}
+	}
+
+	result := lists[shortest]
+
+	for listIndex, list := range lists {
+		if listIndex == shortest {
+			continue
+		}
+
+		result = intersect(result, list)
+
+		if len(result) == 0 {
+			break
+		}
+	}
+
+	return result, true
+}
+
+// trigrams returns every distinct trigram of the input
+func trigrams(input string) []trigram {
+	if len(input) < 3 {
+		return nil
+	}
+
+	seen := make(map[trigram]bool, len(input)-2)
+	output := make([]trigram, 0, len(input)-2)
+
+	for index := 0; index+3 <= len(input); index++ {
+		gram := trigram{input[index], input[index+1], input[index+2]}
+
+		if !seen[gram] {
+			seen[gram] = true
+			output = append(output, gram)
+		}
+	}
+
+	return output
+}
+
+// intersect returns the positions that are in both of the sorted lists
+func intersect(left []int32, right []int32) []int32 {
+	output := []int32{}
+
+	for leftIndex, rightIndex := 0, 0; leftIndex < len(left) && rightIndex < len(right); {
+		switch {
+		case left[leftIndex] < right[rightIndex]:
+			leftIndex++
+		case left[leftIndex] > right[rightIndex]:
+			rightIndex++
+		default:
+			output = append(output, left[leftIndex])
+			leftIndex++
+			rightIndex++
+		}
+	}
+
+	return output
+}
@@ -0,0 +1,375 @@
+// Package cache handles everything that has to do with the generation of the cache for the Search function, to the generation of our folder structure and importing of the config.
+package cache
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skillptm/Bolt/internal/config"
+	"github.com/skillptm/Bolt/internal/util"
+)
+
+const (
+	maxVolumes        int           = 32 // the volumes, that weren't plugged in for the longest time, are forgotten first
+	volumePollTime    time.Duration = 3 * time.Second
+	volumeUpdateTime  int           = 1800 // in seconds, only used if the watcher of a volume can't keep it up to date
+	offlinePrefix     string        = "offline ("
+	offlineNameSuffix string        = ")"
+)
+
+/*
+Volume is a removable drive, that's mounted below one of the Dirs of the RemovableMedia in the config. It has its own Dirs, which is named after the UUID of its filesystem, so it keeps its index no matter where it's mounted.
+
+A search through a scope, that would have crawled the drive, searches its Dirs as well. While the drive is unplugged, the paths of its files start with "offline (<volume name>)/" instead of its mount point.
+*/
+type Volume struct {
+	dirs   *Dirs
+	info   volumeInfo
+	mu     sync.Mutex // guards info and online
+	online bool
+}
+
+// volumeInfo is what the volumes file keeps about a volume, so it's known again after a restart
+type volumeInfo struct {
+	LastSeen   time.Time `json:"LastSeen"`
+	MountPoint string    `json:"MountPoint"` // where it was mounted the last time
+	Name       string    `json:"Name"`       // the label of the filesystem, or the name of the mount point if it has none
+	UUID       string    `json:"UUID"`
+}
+
+// offlineIndex is the Index of a volume, that's unplugged. It only changes the paths of the files, so they show where they can be found, once it's plugged in again
+type offlineIndex struct {
+	Index
+	mountPoint string
+	name       string
+}
+
+// Path returns the path of the folder with the pathKey on the volume, with the offlinePrefix and the name of the volume instead of its mount point
+func (index *offlineIndex) Path(pathKey int) string {
+	return fmt.Sprintf("%s%s%s%s%s", offlinePrefix, index.name, offlineNameSuffix, string(filepath.Separator), strings.TrimPrefix(index.Index.Path(pathKey), index.mountPoint))
+}
+
+// SplitOfflinePath splits the path of a file on an unplugged volume into the name of the volume and the path on it. The bool is false, if the path isn't on an unplugged volume
+func SplitOfflinePath(filePath string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(filePath, offlinePrefix)
+	if !ok {
+		return "", "", false
+	}
+
+	return strings.Cut(rest, offlineNameSuffix+string(filepath.Separator))
+}
+
+// Dirs returns the Dirs, that holds the index of the volume
+func (volume *Volume) Dirs() *Dirs {
+	return volume.dirs
+}
+
+// Name returns the label of the filesystem of the volume, or the name of its mount point if it has none
+func (volume *Volume) Name() string {
+	volume.mu.Lock()
+	defer volume.mu.Unlock()
+
+	return volume.info.Name
+}
+
+// Online checks, if the volume is plugged in
+func (volume *Volume) Online() bool {
+	volume.mu.Lock()
+	defer volume.mu.Unlock()
+
+	return volume.online
+}
+
+// Index returns the current Index of the volume like Dirs.Index does. While the volume is unplugged, the Index gives the paths of its files as offline paths
+func (volume *Volume) Index() Index {
+	index := volume.dirs.Index()
+	if index == nil {
+		return nil
+	}
+
+	volume.mu.Lock()
+	defer volume.mu.Unlock()
+
+	if volume.online {
+		return index
+	}
+
+	return &offlineIndex{index, volume.info.MountPoint, volume.info.Name}
+}
+
+// mounted checks, if the volume is still mounted where it's indexed. A nil volume is the Dirs of a scope, which is always there.
+// The mountinfo is read again for this, as an update has to know before it replaces the index with the empty folder of an unplugged volume
+func (volume *Volume) mounted() bool {
+	if volume == nil {
+		return true
+	}
+
+	volume.mu.Lock()
+	info := volume.info
+	volume.mu.Unlock()
+
+	device, err := filepath.EvalSymlinks(filepath.Join(uuidLinksDir, info.UUID))
+	if err != nil {
+		return false
+	}
+
+	mountInfo, err := os.Open(mountInfoPath)
+	if err != nil {
+		return false
+	}
+	defer mountInfo.Close()
+
+	mounted := false
+	scanner := bufio.NewScanner(mountInfo)
+
+	for scanner.Scan() {
+		mountPoint, _, source, ok := parseMountInfoLine(scanner.Text())
+		if !ok || mountPoint != info.MountPoint {
+			continue
+		}
+
+		// later mounts on the same point hide the earlier ones, so the last one decides
+		sourceDevice, err := filepath.EvalSymlinks(source)
+		mounted = err == nil && sourceDevice == device
+	}
+
+	return mounted
+}
+
+// Volumes returns all volumes we know, plugged in or not
+func (fs *Filesystem) Volumes() []*Volume {
+	fs.volumesMu.Lock()
+	defer fs.volumesMu.Unlock()
+
+	return slices.Clone(fs.volumes)
+}
+
+// VolumesOf returns the volumes a search through the scopes covers, which are the ones the scopes would have crawled, if they weren't volumes
+func (fs *Filesystem) VolumesOf(scopes []*Dirs) []*Volume {
+	output := []*Volume{}
+
+	for _, volume := range fs.Volumes() {
+		volume.mu.Lock()
+		mountPoint := volume.info.MountPoint
+		volume.mu.Unlock()
+
+		for _, dirs := range scopes {
+			// only the mount point itself is checked against the rules, as it's the only folder of the volume the scope sees
+			if dirs.covers(mountPoint) && fs.excludedDirs.check(mountPoint, nil) && dirs.excludedDirs.check(mountPoint, nil) {
+				output = append(output, volume)
+				break
+			}
+		}
+	}
+
+	return output
+}
+
+// covers checks, if dirPath is in one of the BaseDirs of the Dirs
+func (dirs *Dirs) covers(dirPath string) bool {
+	dirs.baseDirsMu.Lock()
+	defer dirs.baseDirsMu.Unlock()
+
+	for baseDir := range dirs.BaseDirs {
+		if strings.HasPrefix(dirPath, baseDir) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// loadVolumes adds the volumes from the volumes file, they start out as unplugged until syncVolumes finds them
+func (fs *Filesystem) loadVolumes() {
+	infos := []volumeInfo{}
+
+	// a missing or broken volumes file just means we don't know any volumes yet
+	util.GetJSON(fs.volumesPath, &infos)
+
+	fs.volumesMu.Lock()
+	defer fs.volumesMu.Unlock()
+
+	for _, info := range infos {
+		if !validVolumeUUID(info.UUID) || slices.ContainsFunc(fs.volumes, func(volume *Volume) bool { return volume.info.UUID == info.UUID }) {
+			continue
+		}
+
+		fs.addVolume(info)
+	}
+}
+
+// watchVolumes checks the mounts every volumePollTime, so volumes get updated once they're plugged in and marked as offline once they're unplugged
+func (fs *Filesystem) watchVolumes() {
+	ticker := time.NewTicker(volumePollTime)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			fs.syncVolumes()
+		case <-fs.stopChan:
+			return
+		}
+	}
+}
+
+// syncVolumes compares the volumes we know with the ones that are mounted. New and plugged in volumes are marked as online and updated, the ones that are gone are marked as offline
+func (fs *Filesystem) syncVolumes() {
+	_, mounted := readMounts(&fs.mountRules)
+	resync := []*Dirs{}
+
+	fs.volumesMu.Lock()
+
+	changed := false
+
+	for uuid, info := range mounted {
+		// a drive a scope has one of its Dirs on is crawled by that scope instead
+		if !validVolumeUUID(uuid) || slices.ContainsFunc(fs.Scopes, func(dirs *Dirs) bool { return dirs.hasBaseDirOn(info.MountPoint) }) {
+			continue
+		}
+
+		index := slices.IndexFunc(fs.volumes, func(volume *Volume) bool { return volume.info.UUID == uuid })
+		if index < 0 {
+			fs.forgetVolumes(maxVolumes - 1)
+
+			if fs.addVolume(info) != nil {
+				continue
+			}
+
+			index = len(fs.volumes) - 1
+		}
+
+		volume := fs.volumes[index]
+
+		volume.mu.Lock()
+
+		if volume.online && volume.info.MountPoint == info.MountPoint {
+			volume.mu.Unlock()
+			continue
+		}
+
+		// the index of a drive, that's mounted somewhere else now, is rebuilt by the update with the new paths
+		volume.info = volumeInfo{LastSeen: time.Now(), MountPoint: info.MountPoint, Name: info.Name, UUID: uuid}
+		volume.online = true
+		volume.mu.Unlock()
+
+		volume.dirs.baseDirsMu.Lock()
+		volume.dirs.BaseDirs = map[string]bool{info.MountPoint: true}
+		volume.dirs.baseDirsMu.Unlock()
+
+		resync = append(resync, volume.dirs)
+		changed = true
+	}
+
+	for _, volume := range fs.volumes {
+		volume.mu.Lock()
+
+		if _, ok := mounted[volume.info.UUID]; volume.online && !ok {
+			volume.info.LastSeen = time.Now()
+			volume.online = false
+			changed = true
+		}
+
+		volume.mu.Unlock()
+	}
+
+	if changed {
+		fs.saveVolumes()
+	}
+
+	fs.volumesMu.Unlock()
+
+	for _, dirs := range resync {
+		fs.requestResync(dirs)
+	}
+
+	// without a watcher, the volumes that are plugged in get the periodic updates the scopes get as well
+	for _, volume := range fs.Volumes() {
+		if volume.Online() && !volume.dirs.watcher.live() && time.Since(volume.dirs.IndexStats().LastUpdate) > volume.dirs.updateTime {
+			fs.requestResync(volume.dirs)
+		}
+	}
+}
+
+// addVolume adds a volume, which starts out as unplugged, together with its Dirs and watcher. It's meant to be called while holding the volumesMu
+func (fs *Filesystem) addVolume(info volumeInfo) error {
+	dirs, err := newDirs(fs.conf, config.Scope{
+		Name:             volumeName(info.UUID),
+		UpdateTime:       volumeUpdateTime,
+		Dirs:             []string{info.MountPoint},
+		StayOnFilesystem: true,
+		Storage:          config.StorageMemory,
+	})
+	if err != nil {
+		return fmt.Errorf("addVolume: couldn't setup the Dirs of volume %s:\n--> %w", info.UUID, err)
+	}
+
+	volume := Volume{dirs: dirs, info: info}
+	dirs.volume = &volume
+
+	// if we can't get an inotify instance the watcher stays nil and we fall back to the periodic updates
+	dirs.watcher, _ = newWatcher(fs, dirs)
+	go dirs.watcher.run()
+
+	fs.volumes = append(fs.volumes, &volume)
+
+	return nil
+}
+
+// forgetVolumes removes the unplugged volumes, that weren't seen for the longest time, together with their files, until there are at most keep volumes left. It's meant to be called while holding the volumesMu
+func (fs *Filesystem) forgetVolumes(keep int) {
+	slices.SortStableFunc(fs.volumes, func(a *Volume, b *Volume) int {
+		return cmp.Compare(b.info.LastSeen.UnixNano(), a.info.LastSeen.UnixNano())
+	})
+
+	for index := len(fs.volumes) - 1; index >= 0 && len(fs.volumes) > keep; index-- {
+		volume := fs.volumes[index]
+		if volume.Online() {
+			continue
+		}
+
+		volume.dirs.watcher.close()
+
+		for _, filePath := range []string{volume.dirs.CachePath, volume.dirs.statsPath, volume.dirs.journal.path, volume.dirs.contentPath, volume.dirs.tagsPath} {
+			os.Remove(filePath)
+		}
+
+		fs.volumes = slices.Delete(fs.volumes, index, index+1)
+	}
+}
+
+// saveVolumes writes the volumes we know to the volumes file. It's meant to be called while holding the volumesMu
+func (fs *Filesystem) saveVolumes() {
+	infos := []volumeInfo{}
+
+	for _, volume := range fs.volumes {
+		volume.mu.Lock()
+		infos = append(infos, volume.info)
+		volume.mu.Unlock()
+	}
+
+	// like the cache, a volumes file we couldn't write only means the volumes are found again, once they're plugged in
+	util.OverwriteJSON(fs.volumesPath, true, infos)
+}
+
+// validVolumeUUID checks, if the UUID can be part of the names of the files of a volume. udev escapes separators in the links, but we unescape them again
+func validVolumeUUID(uuid string) bool {
+	return uuid != "" && !strings.ContainsRune(uuid, filepath.Separator) && uuid != "." && uuid != ".."
+}
+
+// volumeName returns the name of the Dirs of the volume with the UUID, which its files are named after
+func volumeName(uuid string) string {
+	return fmt.Sprintf("volume-%s", uuid)
+}
+
+// volumesPath returns the path of the volumes file, which lists the volumes we know
+func volumesPath(cacheDir string) string {
+	return filepath.Join(cacheDir, "volumes.json")
+}
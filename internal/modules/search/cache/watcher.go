@@ -0,0 +1,252 @@
+// Package cache handles everything that has to do with the generation of the cache for the Search function, to the generation of our folder structure and importing of the config.
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	watchMask         uint32 = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_CLOSE_WRITE | syscall.IN_ONLYDIR | syscall.IN_DONT_FOLLOW | syscall.IN_EXCL_UNLINK
+	watchBufferSize   int    = 64 * 1024
+	maxPendingEvents  int    = 100000 // after this many events we'd rather re-crawl than keep them all in memory
+	inotifyNameOffset int    = syscall.SizeofInotifyEvent
+)
+
+// watcher keeps a Dirs up to date with inotify events, so it doesn't have to be re-crawled on a timer
+type watcher struct {
+	active  atomic.Bool
+	dirs    *Dirs
+	fd      int
+	file    *os.File
+	fs      *Filesystem
+	ignores map[string]*ignoreList // dir path -> ignoreList, filled as events come in
+	mu      sync.Mutex
+	paths   map[int]string // watch descriptor -> dir path
+	wds     map[string]int // dir path -> watch descriptor
+}
+
+// newWatcher is the constructor for watcher, it creates the inotify instance, but doesn't watch any dirs yet
+func newWatcher(fs *Filesystem, dirs *Dirs) (*watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("newWatcher: couldn't create inotify instance:\n--> %w", err)
+	}
+
+	// using an *os.File lets the read be handled by the runtime poller, so close() can unblock run()
+	w := watcher{
+		dirs:    dirs,
+		fd:      fd,
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		fs:      fs,
+		ignores: make(map[string]*ignoreList),
+		paths:   make(map[int]string),
+		wds:     make(map[string]int),
+	}
+	w.active.Store(true)
+
+	return &w, nil
+}
+
+// live reports, if the watcher is still keeping its Dirs up to date
+func (w *watcher) live() bool {
+	return w != nil && w.active.Load()
+}
+
+// close stops the watcher, after which the Dirs will only be updated by the periodic crawls
+func (w *watcher) close() {
+	if w == nil || !w.active.CompareAndSwap(true, false) {
+		return
+	}
+
+	w.file.Close()
+}
+
+// watch adds an inotify watch for dirPath. Running out of watches closes the watcher, as a partially watched Dirs would silently go stale
+func (w *watcher) watch(dirPath string) {
+	if !w.live() {
+		return
+	}
+
+	wd, err := syscall.InotifyAddWatch(w.fd, dirPath, watchMask)
+	if errors.Is(err, syscall.ENOSPC) {
+		w.close()
+		return
+	}
+
+	// any other error means we can't read the dir, which traverse will skip as well
+	if err != nil {
+		return
+	}
+
+	w.mu.Lock()
+	w.paths[wd] = dirPath
+	w.wds[dirPath] = wd
+	w.mu.Unlock()
+}
+
+// unwatch removes the watches of dirPath and all dirs inside of it
+func (w *watcher) unwatch(dirPath string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for watchedPath, wd := range w.wds {
+		if !strings.HasPrefix(watchedPath, dirPath) {
+			continue
+		}
+
+		syscall.InotifyRmWatch(w.fd, uint32(wd))
+		delete(w.ignores, watchedPath)
+		delete(w.wds, watchedPath)
+		delete(w.paths, wd)
+	}
+}
+
+// forget drops a watch descriptor the kernel already removed
+func (w *watcher) forget(wd int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if dirPath, ok := w.paths[wd]; ok {
+		delete(w.wds, dirPath)
+		delete(w.paths, wd)
+	}
+}
+
+// run reads the inotify events until the watcher is closed and applies them onto the Dirs
+func (w *watcher) run() {
+	if w == nil {
+		return
+	}
+
+	buffer := make([]byte, watchBufferSize)
+
+	for {
+		n, err := w.file.Read(buffer)
+		if err != nil {
+			w.close()
+			return
+		}
+
+		events := []fsEvent{}
+		overflowed := false
+
+		for offset := 0; offset+inotifyNameOffset <= n; {
+			rawEvent := (*syscall.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+			name := strings.TrimRight(string(buffer[offset+inotifyNameOffset:offset+inotifyNameOffset+int(rawEvent.Len)]), "\x00")
+			offset += inotifyNameOffset + int(rawEvent.Len)
+
+			switch {
+			case rawEvent.Mask&syscall.IN_Q_OVERFLOW != 0:
+				overflowed = true
+			case rawEvent.Mask&syscall.IN_IGNORED != 0:
+				w.forget(int(rawEvent.Wd))
+			case rawEvent.Mask&syscall.IN_UNMOUNT != 0:
+				// the folder is still there, just not the drive it was on, and the kernel drops the watch right after
+			default:
+				events = append(events, w.translate(int(rawEvent.Wd), rawEvent.Mask, name)...)
+			}
+		}
+
+		// we lost events, so only a full crawl can bring the Dirs back in sync
+		if overflowed {
+			w.fs.requestResync(w.dirs)
+			continue
+		}
+
+		if len(events) > 0 && w.dirs.apply(events) > maxPendingEvents {
+			w.fs.requestResync(w.dirs)
+		}
+	}
+}
+
+// translate turns a single inotify event into the fsEvents for the Dirs. New folders are crawled right away, as they might have been moved in with content
+func (w *watcher) translate(wd int, mask uint32, name string) []fsEvent {
+	w.mu.Lock()
+	parentDir, ok := w.paths[wd]
+	w.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	item := newBasicFile(parentDir, name, mask&syscall.IN_ISDIR != 0, Metadata{})
+
+	if mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0 {
+		if item.isFolder {
+			w.unwatch(item.path)
+		}
+
+		return append([]fsEvent{{item, true}}, w.fs.archiveEvents(item, w.dirs, true)...)
+	}
+
+	// created, moved in or written to, in any case the Metadata has to be (re)captured
+	fileInfo, err := os.Lstat(filepath.Join(parentDir, name))
+	if err != nil {
+		return nil
+	}
+
+	item.metadata = newMetadata(fileInfo)
+
+	// changed rules can affect the whole tree below, which only a crawl can sort out
+	if !item.isFolder && slices.Contains(w.fs.ignoreFiles, name) {
+		w.mu.Lock()
+		clear(w.ignores)
+		w.mu.Unlock()
+
+		w.fs.requestResync(w.dirs)
+	}
+
+	ignores := w.ignoreList(parentDir)
+	if ignores.ignored(item) {
+		return nil
+	}
+
+	if !item.isFolder {
+		if !w.fs.allowedFile(item, w.dirs) {
+			// a file that grew past the size limit has to leave the cache
+			return append([]fsEvent{{item, true}}, w.fs.archiveEvents(item, w.dirs, true)...)
+		}
+
+		return append([]fsEvent{{item, false}}, w.fs.archiveEvents(item, w.dirs, false)...)
+	}
+
+	if !w.fs.allowed(item.path, w.dirs) {
+		return nil
+	}
+
+	events := []fsEvent{{item, false}}
+
+	for _, found := range w.fs.crawl(item.path, w.dirs, ignores) {
+		events = append(events, fsEvent{found, false})
+	}
+
+	return events
+}
+
+// ignoreList returns the ignoreList of dirPath, which is only built once until an ignore file changes
+func (w *watcher) ignoreList(dirPath string) *ignoreList {
+	w.mu.Lock()
+	list, ok := w.ignores[dirPath]
+	w.mu.Unlock()
+
+	if ok {
+		return list
+	}
+
+	list = w.fs.ignoreListFor(dirPath, w.dirs)
+
+	w.mu.Lock()
+	w.ignores[dirPath] = list
+	w.mu.Unlock()
+
+	return list
+}
@@ -0,0 +1,66 @@
+// Package search handles the search, aswell as ranking and sorting of the results.
+package search
+
+import (
+	"cmp"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/skillptm/Bolt/internal/modules/search/cache"
+)
+
+// StartContent searches the content indexes of the provided scopes for the words in the searchInput. Files with more of the words come first, then the ones with more occurrences of them and then the newer ones.
+// Like with Start, the forceStopChan makes it yield no results and only the first verifyCount results are checked to still exist on the disk
+func StartContent(searchInput string, fs *cache.Filesystem, forceStopChan chan bool, scopes []*cache.Dirs, fileExtensions []string, verifyCount int) []string {
+	output := []string{}
+
+	if len(strings.TrimSpace(searchInput)) < 1 {
+		return output
+	}
+
+	pattern := newSearchString(searchInput, fileExtensions, fs.IgnoreDiacritics)
+	matches := make(map[string]cache.ContentMatch)
+
+	for _, dirs := range scopes {
+		if len(forceStopChan) > 0 {
+			return output
+		}
+
+		for _, match := range dirs.SearchContent(searchInput) {
+			if len(pattern.extensions) > 0 && !slices.Contains(pattern.extensions, strings.ToLower(filepath.Ext(match.Path))) {
+				continue
+			}
+
+			// a file in more than one scope is only listed once
+			matches[match.Path] = match
+		}
+	}
+
+	if len(forceStopChan) > 0 {
+		return output
+	}
+
+	rankedMatches := make([]cache.ContentMatch, 0, len(matches))
+	for _, match := range matches {
+		rankedMatches = append(rankedMatches, match)
+	}
+
+	slices.SortFunc(rankedMatches, func(a cache.ContentMatch, b cache.ContentMatch) int {
+		return cmp.Or(cmp.Compare(b.Terms, a.Terms), cmp.Compare(b.Count, a.Count), cmp.Compare(b.ModTime, a.ModTime), cmp.Compare(a.Path, b.Path))
+	})
+
+	for _, match := range rankedMatches {
+		if len(output) < verifyCount {
+			// if we error, it's most likely the file doesn't exist anymore, so we skip it
+			if _, err := os.Lstat(match.Path); err != nil {
+				continue
+			}
+		}
+
+		output = append(output, match.Path)
+	}
+
+	return output
+}
@@ -0,0 +1,152 @@
+// Package extract reads the tags embedded in photos, music and documents, like the EXIF camera of a photo or the ID3 artist of a song.
+package extract
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+)
+
+const (
+	maxJPEGSegments int = 64 // the EXIF segment is at the start, so we don't walk through all segments of broken files
+
+	exifMake             uint16 = 0x010f
+	exifModel            uint16 = 0x0110
+	exifDateTime         uint16 = 0x0132
+	exifIFDPointer       uint16 = 0x8769
+	exifDateTimeOriginal uint16 = 0x9003
+	exifASCII            uint16 = 2
+)
+
+// exifTags reads the camera and the date from the EXIF segment of a JPEG
+func exifTags(file io.ReaderAt, size int64) (Tags, error) {
+	tags := Tags{}
+
+	start, err := readAt(file, 0, 2)
+	if err != nil || !bytes.Equal(start, []byte{0xff, 0xd8}) {
+		return nil, errors.New("exifTags: not a JPEG")
+	}
+
+	offset := int64(2)
+
+	for range maxJPEGSegments {
+		header, err := readAt(file, offset, 4)
+		if err != nil || len(header) < 4 || header[0] != 0xff {
+			return tags, nil
+		}
+
+		marker, length := header[1], int64(binary.BigEndian.Uint16(header[2:4]))
+
+		// the image data starts at SOS, nothing after it is metadata
+		if marker == 0xda || length < 2 || offset+2+length > size {
+			return tags, nil
+		}
+
+		if marker == 0xe1 {
+			segment, err := readAt(file, offset+4, int(length-2))
+			if err != nil {
+				return nil, err
+			}
+
+			if tiff, ok := bytes.CutPrefix(segment, []byte("Exif\x00\x00")); ok {
+				parseTIFF(tiff, tags)
+				return tags, nil
+			}
+		}
+
+		offset += 2 + length
+	}
+
+	return tags, nil
+}
+
+// parseTIFF reads the tags we want from the IFD0 and the EXIF IFD of the TIFF structure inside of the EXIF segment
+func parseTIFF(tiff []byte, tags Tags) {
+	if len(tiff) < 8 {
+		return
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return
+	}
+
+	values := make(map[uint16]string)
+
+	exifOffset := readIFD(tiff, order, order.Uint32(tiff[4:8]), values)
+	if exifOffset > 0 {
+		readIFD(tiff, order, exifOffset, values)
+	}
+
+	camera := values[exifModel]
+
+	// most models already start with the make, like "Canon EOS R6"
+	if maker := values[exifMake]; maker != "" && !strings.HasPrefix(strings.ToLower(camera), strings.ToLower(maker)) {
+		camera = strings.TrimSpace(maker + " " + camera)
+	}
+
+	tags.set("camera", camera)
+
+	date := values[exifDateTimeOriginal]
+	if date == "" {
+		date = values[exifDateTime]
+	}
+
+	// EXIF dates look like "2021:07:04 13:37:00"
+	if len(date) >= 10 {
+		tags.set("date", strings.ReplaceAll(date[:10], ":", "-"))
+	}
+}
+
+// readIFD stores the ASCII values of the IFD at offset in values and returns the offset of the EXIF IFD, if the IFD points to one
+func readIFD(tiff []byte, order binary.ByteOrder, offset uint32, values map[uint16]string) uint32 {
+	if uint64(offset)+2 > uint64(len(tiff)) {
+		return 0
+	}
+
+	count := int(order.Uint16(tiff[offset : offset+2]))
+	exifOffset := uint32(0)
+
+	for index := range count {
+		entryStart := uint64(offset) + 2 + uint64(index)*12
+		if entryStart+12 > uint64(len(tiff)) {
+			break
+		}
+
+		entry := tiff[entryStart : entryStart+12]
+		tag, kind, length := order.Uint16(entry[0:2]), order.Uint16(entry[2:4]), uint64(order.Uint32(entry[4:8]))
+
+		if tag == exifIFDPointer {
+			exifOffset = order.Uint32(entry[8:12])
+			continue
+		}
+
+		if kind != exifASCII || (tag != exifMake && tag != exifModel && tag != exifDateTime && tag != exifDateTimeOriginal) {
+			continue
+		}
+
+		// values of up to 4 bytes are stored in the entry itself, longer ones somewhere else
+		value := entry[8:12]
+		if length > 4 {
+			valueOffset := uint64(order.Uint32(entry[8:12]))
+			if valueOffset+length > uint64(len(tiff)) {
+				continue
+			}
+
+			value = tiff[valueOffset : valueOffset+length]
+		} else {
+			value = value[:length]
+		}
+
+		values[tag] = strings.TrimRight(string(value), "\x00 ")
+	}
+
+	return exifOffset
+}
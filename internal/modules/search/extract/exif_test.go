@@ -0,0 +1,146 @@
+// Package extract reads the tags embedded in photos, music and documents, like the EXIF camera of a photo or the ID3 artist of a song.
+package extract
+
+import (
+	"bytes"
+	"encoding/binary"
+	"maps"
+	"testing"
+)
+
+// tiffEntry is a single entry of an IFD for testTIFF. ASCII values are stored with their NUL, other kinds get the pointer as their value
+type tiffEntry struct {
+	tag     uint16
+	kind    uint16
+	value   string
+	pointer uint32
+}
+
+// testTIFF builds a little endian TIFF with the entries in IFD0 and the exifEntries in the EXIF IFD, if there are any. The values that don't fit into their entry come after the IFDs
+func testTIFF(entries []tiffEntry, exifEntries []tiffEntry) []byte {
+	ifdSize := func(count int) int {
+		return 2 + count*12 + 4
+	}
+
+	ifd0Count := len(entries)
+	if len(exifEntries) > 0 {
+		ifd0Count++
+	}
+
+	exifOffset := uint32(8 + ifdSize(ifd0Count))
+	dataOffset := exifOffset
+	if len(exifEntries) > 0 {
+		dataOffset += uint32(ifdSize(len(exifEntries)))
+		entries = append(entries, tiffEntry{exifIFDPointer, 4, "", exifOffset})
+	}
+
+	tiff := []byte("II\x2a\x00\x08\x00\x00\x00")
+	data := []byte{}
+
+	writeIFD := func(entries []tiffEntry) {
+		tiff = binary.LittleEndian.AppendUint16(tiff, uint16(len(entries)))
+
+		for _, entry := range entries {
+			tiff = binary.LittleEndian.AppendUint16(tiff, entry.tag)
+			tiff = binary.LittleEndian.AppendUint16(tiff, entry.kind)
+
+			if entry.kind != exifASCII {
+				tiff = binary.LittleEndian.AppendUint32(tiff, 1)
+				tiff = binary.LittleEndian.AppendUint32(tiff, entry.pointer)
+				continue
+			}
+
+			value := entry.value + "\x00"
+			tiff = binary.LittleEndian.AppendUint32(tiff, uint32(len(value)))
+
+			if len(value) <= 4 {
+				tiff = append(tiff, []byte(value + "\x00\x00\x00")[:4]...)
+				continue
+			}
+
+			tiff = binary.LittleEndian.AppendUint32(tiff, dataOffset+uint32(len(data)))
+			data = append(data, value...)
+		}
+
+		tiff = binary.LittleEndian.AppendUint32(tiff, 0)
+	}
+
+	writeIFD(entries)
+	if len(exifEntries) > 0 {
+		writeIFD(exifEntries)
+	}
+
+	return append(tiff, data...)
+}
+
+// testJPEG builds a JPEG with the segment as its APP1 segment, followed by the start of the image data. A length of 0 is replaced with the actual length of the segment
+func testJPEG(segment []byte, length int) []byte {
+	if length == 0 {
+		length = len(segment) + 2
+	}
+
+	jpeg := []byte{0xff, 0xd8, 0xff, 0xe1}
+	jpeg = binary.BigEndian.AppendUint16(jpeg, uint16(length))
+	jpeg = append(jpeg, segment...)
+
+	return append(jpeg, 0xff, 0xda, 0x00, 0x02)
+}
+
+// TestEXIFTags reads JPEGs with intact, truncated and hostile EXIF segments. None of them may panic
+func TestEXIFTags(t *testing.T) {
+	camera := []tiffEntry{{exifMake, exifASCII, "Canon", 0}, {exifModel, exifASCII, "EOS R6", 0}}
+	date := []tiffEntry{{exifDateTimeOriginal, exifASCII, "2021:07:04 13:37:00", 0}}
+	valid := append([]byte("Exif\x00\x00"), testTIFF(camera, date)...)
+
+	// an IFD0 with a single ASCII entry, whose fields are set below
+	hostileEntry := func(count uint16, length uint32, valueOffset uint32) []byte {
+		tiff := []byte("II\x2a\x00\x08\x00\x00\x00")
+		tiff = binary.LittleEndian.AppendUint16(tiff, count)
+		tiff = binary.LittleEndian.AppendUint16(tiff, exifModel)
+		tiff = binary.LittleEndian.AppendUint16(tiff, exifASCII)
+		tiff = binary.LittleEndian.AppendUint32(tiff, length)
+		tiff = binary.LittleEndian.AppendUint32(tiff, valueOffset)
+
+		return append([]byte("Exif\x00\x00"), tiff...)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    Tags
+		wantErr bool
+	}{
+		{"exif", testJPEG(valid, 0), Tags{"camera": "Canon EOS R6", "date": "2021-07-04"}, false},
+		{"big endian", testJPEG(append([]byte("Exif\x00\x00"), "MM\x00\x2a\x00\x00\x00\x08\x00\x01\x01\x10\x00\x02\x00\x00\x00\x03R6\x00\x00\x00\x00\x00\x00"...), 0), Tags{"camera": "R6"}, false},
+		{"short value in the entry", testJPEG(append([]byte("Exif\x00\x00"), testTIFF([]tiffEntry{{exifModel, exifASCII, "X1", 0}}, nil)...), 0), Tags{"camera": "X1"}, false},
+		{"segment cut off", testJPEG(valid, 0)[:len(valid)/2], Tags{}, false},
+		{"segment longer than the file", testJPEG(valid, 0xffff), Tags{}, false},
+		{"segment length below its own size", testJPEG(valid, 1), Tags{}, false},
+		{"tiff cut off", testJPEG(valid[:12], 0), Tags{}, false},
+		{"unknown byte order", testJPEG(append([]byte("Exif\x00\x00XX"), valid[8:]...), 0), Tags{}, false},
+		{"ifd past the tiff", testJPEG(append([]byte("Exif\x00\x00II\x2a\x00\xff\xff\xff\xff"), 0, 0), 0), Tags{}, false},
+		{"entry count past the tiff", testJPEG(hostileEntry(0xffff, 5, 8), 0), Tags{"camera": "\xff\xff\x10\x01\x02"}, false},
+		{"value past the tiff", testJPEG(hostileEntry(1, 16, 0xfffffff0), 0), Tags{}, false},
+		{"value length past the maximum", testJPEG(hostileEntry(1, 0xffffffff, 8), 0), Tags{}, false},
+		{"exif pointer past the tiff", testJPEG(append([]byte("Exif\x00\x00"), testTIFF([]tiffEntry{{exifIFDPointer, 4, "", 0xffffffff}}, nil)...), 0), Tags{}, false},
+		{"exif pointer to itself", testJPEG(append([]byte("Exif\x00\x00"), testTIFF([]tiffEntry{{exifIFDPointer, 4, "", 8}}, nil)...), 0), Tags{}, false},
+		{"no exif", testJPEG([]byte("http://ns.adobe.com/xap/1.0/\x00"), 0), Tags{}, false},
+		{"only the start of image", []byte{0xff, 0xd8}, Tags{}, false},
+		{"not a jpeg", []byte("GIF89a"), nil, true},
+		{"empty", []byte{}, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := exifTags(bytes.NewReader(test.data), int64(len(test.data)))
+
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected an error %t, got %v", test.wantErr, err)
+			}
+
+			if !maps.Equal(got, test.want) {
+				t.Fatalf("expected %q, got %q", test.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+// Package extract reads the tags embedded in photos, music and documents, like the EXIF camera of a photo or the ID3 artist of a song.
+package extract
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Tags holds the tags of a file with one of the Keys as the key. Tags a file doesn't have are left out
+type Tags map[string]string
+
+// extractor reads the Tags of a single file format. It may only read the parts of the file it needs, as files can be large
+type extractor func(file io.ReaderAt, size int64) (Tags, error)
+
+// Keys are the tags we extract, which are also the names of the search flags for them
+var Keys = []string{"album", "artist", "author", "camera", "date", "title"}
+
+var extractors = map[string]extractor{
+	".flac": flacTags,
+	".jpeg": exifTags,
+	".jpg":  exifTags,
+	".mp3":  id3Tags,
+	".pdf":  pdfTags,
+}
+
+// Supported checks, if we can extract Tags from files with the extension
+func Supported(extension string) bool {
+	_, ok := extractors[strings.ToLower(extension)]
+	return ok
+}
+
+// File extracts the Tags of the file at filePath based on its extension. Files without any Tags return an empty map
+func File(filePath string, extension string) (Tags, error) {
+	extract, ok := extractors[strings.ToLower(extension)]
+	if !ok {
+		return nil, fmt.Errorf("File: no extractor for %s", extension)
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("File: couldn't open %s:\n--> %w", filePath, err)
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("File: couldn't stat %s:\n--> %w", filePath, err)
+	}
+
+	tags, err := extract(file, fileInfo.Size())
+	if err != nil {
+		return nil, fmt.Errorf("File: couldn't extract tags of %s:\n--> %w", filePath, err)
+	}
+
+	return tags, nil
+}
+
+// set adds the value to the Tags under the key, after trimming it. Empty values are left out
+func (tags Tags) set(key string, value string) {
+	value = strings.TrimSpace(strings.TrimRight(value, "\x00"))
+
+	if value != "" {
+		tags[key] = value
+	}
+}
+
+// readAt reads length bytes at offset, it's shorter if the file ends before
+func readAt(file io.ReaderAt, offset int64, length int) ([]byte, error) {
+	buffer := make([]byte, length)
+
+	n, err := file.ReadAt(buffer, offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return buffer[:n], nil
+}
@@ -0,0 +1,122 @@
+// Package extract reads the tags embedded in photos, music and documents, like the EXIF camera of a photo or the ID3 artist of a song.
+package extract
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+)
+
+const (
+	flacVorbisComment byte = 4
+	maxFLACBlocks     int  = 128
+)
+
+// vorbisFields maps the fields of Vorbis comments to our keys
+var vorbisFields = map[string]string{
+	"TITLE":  "title",
+	"ARTIST": "artist",
+	"ALBUM":  "album",
+	"DATE":   "date",
+}
+
+// flacTags reads the title, artist, album and date of a FLAC from its Vorbis comment block
+func flacTags(file io.ReaderAt, size int64) (Tags, error) {
+	tags := Tags{}
+	offset := int64(0)
+
+	start, err := readAt(file, 0, id3HeaderSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// some taggers put an ID3v2 tag in front of the FLAC stream
+	if len(start) == id3HeaderSize && string(start[0:3]) == "ID3" {
+		offset = int64(id3HeaderSize + syncsafe(start[6:10]))
+	}
+
+	magic, err := readAt(file, offset, 4)
+	if err != nil || string(magic) != "fLaC" {
+		return nil, errors.New("flacTags: not a FLAC")
+	}
+
+	offset += 4
+
+	for range maxFLACBlocks {
+		// block header: last block bit | type 7 bits | length 24 bits
+		header, err := readAt(file, offset, 4)
+		if err != nil || len(header) < 4 {
+			return tags, nil
+		}
+
+		last, kind := header[0]&0x80 != 0, header[0]&0x7f
+		length := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+
+		if offset+4+length > size {
+			return tags, nil
+		}
+
+		if kind == flacVorbisComment {
+			block, err := readAt(file, offset+4, int(length))
+			if err != nil {
+				return nil, err
+			}
+
+			parseVorbisComment(block, tags)
+			return tags, nil
+		}
+
+		if last {
+			return tags, nil
+		}
+
+		offset += 4 + length
+	}
+
+	return tags, nil
+}
+
+// parseVorbisComment reads the fields we want from a Vorbis comment, which is laid out as (little endian):
+// vendor length uint32 | vendor | count uint32 | count * (length uint32 | "FIELD=value")
+func parseVorbisComment(block []byte, tags Tags) {
+	readUint32 := func() (int, bool) {
+		if len(block) < 4 {
+			return 0, false
+		}
+
+		value := int(binary.LittleEndian.Uint32(block[0:4]))
+		block = block[4:]
+
+		return value, true
+	}
+
+	vendorLength, ok := readUint32()
+	if !ok || vendorLength > len(block) {
+		return
+	}
+
+	block = block[vendorLength:]
+
+	count, ok := readUint32()
+	if !ok {
+		return
+	}
+
+	for range count {
+		length, ok := readUint32()
+		if !ok || length > len(block) {
+			return
+		}
+
+		field, value, found := strings.Cut(string(block[:length]), "=")
+		block = block[length:]
+
+		// fields can appear more than once, like one ARTIST per artist, we keep the first
+		if key, known := vorbisFields[strings.ToUpper(field)]; found && known {
+			if _, set := tags[key]; !set {
+				tags.set(key, value)
+			}
+		}
+	}
+}
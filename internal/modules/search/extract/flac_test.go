@@ -0,0 +1,91 @@
+// Package extract reads the tags embedded in photos, music and documents, like the EXIF camera of a photo or the ID3 artist of a song.
+package extract
+
+import (
+	"bytes"
+	"encoding/binary"
+	"maps"
+	"testing"
+)
+
+// testVorbisComment builds a Vorbis comment with the fields, like "TITLE=Song"
+func testVorbisComment(fields ...string) []byte {
+	comment := binary.LittleEndian.AppendUint32(nil, 6)
+	comment = append(comment, "vendor"...)
+	comment = binary.LittleEndian.AppendUint32(comment, uint32(len(fields)))
+
+	for _, field := range fields {
+		comment = binary.LittleEndian.AppendUint32(comment, uint32(len(field)))
+		comment = append(comment, field...)
+	}
+
+	return comment
+}
+
+// testFLACBlock builds a metadata block of the kind, a length of -1 is replaced with the actual length of the data
+func testFLACBlock(kind byte, last bool, length int, data []byte) []byte {
+	if length < 0 {
+		length = len(data)
+	}
+
+	if last {
+		kind |= 0x80
+	}
+
+	return append([]byte{kind, byte(length >> 16), byte(length >> 8), byte(length)}, data...)
+}
+
+// TestFLACTags reads FLACs with intact, truncated and hostile metadata blocks and Vorbis comments. None of them may panic
+func TestFLACTags(t *testing.T) {
+	streamInfo := testFLACBlock(0, false, -1, make([]byte, 34))
+	comment := testFLACBlock(flacVorbisComment, true, -1, testVorbisComment("TITLE=Song", "artist=First", "ARTIST=Second", "NOFIELD", "DATE=2021"))
+	valid := append(append([]byte("fLaC"), streamInfo...), comment...)
+
+	// a Vorbis comment, whose lengths and count are set below
+	hostileComment := func(vendorLength uint32, count uint32, fieldLength uint32) []byte {
+		data := binary.LittleEndian.AppendUint32(nil, vendorLength)
+		data = binary.LittleEndian.AppendUint32(data, count)
+		data = binary.LittleEndian.AppendUint32(data, fieldLength)
+		data = append(data, "TITLE=Song"...)
+
+		return append([]byte("fLaC"), testFLACBlock(flacVorbisComment, true, -1, data)...)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    Tags
+		wantErr bool
+	}{
+		{"vorbis comment", valid, Tags{"title": "Song", "artist": "First", "date": "2021"}, false},
+		{"behind an id3v2 tag", append(testID3v2(3, 0, "TIT2", "Other"), valid...), Tags{"title": "Song", "artist": "First", "date": "2021"}, false},
+		{"no vorbis comment", append([]byte("fLaC"), testFLACBlock(0, true, -1, make([]byte, 34))...), Tags{}, false},
+		{"block cut off", valid[:len(valid)-5], Tags{}, false},
+		{"block longer than the file", append([]byte("fLaC"), testFLACBlock(flacVorbisComment, true, 0xffffff, testVorbisComment("TITLE=Song"))...), Tags{}, false},
+		{"block header cut off", append([]byte("fLaC"), streamInfo[:2]...), Tags{}, false},
+		{"endless empty blocks", append([]byte("fLaC"), bytes.Repeat(testFLACBlock(1, false, 0, nil), maxFLACBlocks+8)...), Tags{}, false},
+		{"vendor past the block", hostileComment(0xffffffff, 1, 10), Tags{}, false},
+		{"count past the block", hostileComment(0, 0xffffffff, 10), Tags{"title": "Song"}, false},
+		{"field past the block", hostileComment(0, 1, 0xffffffff), Tags{}, false},
+		{"field cut off", hostileComment(0, 1, 11), Tags{}, false},
+		{"comment cut off", append([]byte("fLaC"), testFLACBlock(flacVorbisComment, true, -1, []byte{6, 0})...), Tags{}, false},
+		{"id3v2 tag past the file", append([]byte{'I', 'D', '3', 3, 0, 0, 0x7f, 0x7f, 0x7f, 0x7f}, valid...), nil, true},
+		{"only the magic", []byte("fLaC"), Tags{}, false},
+		{"not a flac", []byte("OggS"), nil, true},
+		{"empty", []byte{}, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := flacTags(bytes.NewReader(test.data), int64(len(test.data)))
+
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected an error %t, got %v", test.wantErr, err)
+			}
+
+			if !maps.Equal(got, test.want) {
+				t.Fatalf("expected %q, got %q", test.want, got)
+			}
+		})
+	}
+}
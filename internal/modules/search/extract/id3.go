@@ -0,0 +1,187 @@
+// Package extract reads the tags embedded in photos, music and documents, like the EXIF camera of a photo or the ID3 artist of a song.
+package extract
+
+import (
+	"encoding/binary"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	id3HeaderSize int = 10
+	id3v1Size     int = 128
+	maxID3Size    int = 1 << 20 // large tags are mostly embedded cover art, which comes after the text frames we want
+)
+
+// id3Frames maps the IDs of the text frames of ID3v2.3/4 and the shorter ones of ID3v2.2 to our keys
+var id3Frames = map[string]string{
+	"TIT2": "title",
+	"TPE1": "artist",
+	"TALB": "album",
+	"TYER": "date",
+	"TDRC": "date",
+	"TT2":  "title",
+	"TP1":  "artist",
+	"TAL":  "album",
+	"TYE":  "date",
+}
+
+// id3Tags reads the title, artist, album and date of an MP3 from its ID3v2 tag, or from the ID3v1 tag at the end, if it doesn't have one
+func id3Tags(file io.ReaderAt, size int64) (Tags, error) {
+	tags := Tags{}
+
+	header, err := readAt(file, 0, id3HeaderSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(header) == id3HeaderSize && string(header[0:3]) == "ID3" {
+		tagSize := min(syncsafe(header[6:10]), maxID3Size)
+
+		body, err := readAt(file, int64(id3HeaderSize), tagSize)
+		if err != nil {
+			return nil, err
+		}
+
+		parseID3v2(body, header[3], header[5], tags)
+	}
+
+	if len(tags) > 0 || size < int64(id3v1Size) {
+		return tags, nil
+	}
+
+	footer, err := readAt(file, size-int64(id3v1Size), id3v1Size)
+	if err != nil {
+		return nil, err
+	}
+
+	// ID3v1: "TAG" | title [30]byte | artist [30]byte | album [30]byte | year [4]byte | ...
+	if len(footer) == id3v1Size && string(footer[0:3]) == "TAG" {
+		tags.set("title", latin1(footer[3:33]))
+		tags.set("artist", latin1(footer[33:63]))
+		tags.set("album", latin1(footer[63:93]))
+		tags.set("date", latin1(footer[93:97]))
+	}
+
+	return tags, nil
+}
+
+// parseID3v2 reads the text frames we want from the body of an ID3v2 tag
+func parseID3v2(body []byte, version byte, flags byte, tags Tags) {
+	idLength, headerLength := 4, 10
+	if version == 2 {
+		idLength, headerLength = 3, 6
+	}
+
+	offset := 0
+
+	// the extended header only exists in 2.3 and 2.4, its size doesn't include itself in 2.3
+	if flags&0x40 != 0 && version >= 3 && len(body) >= 4 {
+		if version == 3 {
+			offset = int(binary.BigEndian.Uint32(body[0:4])) + 4
+		} else {
+			offset = syncsafe(body[0:4])
+		}
+	}
+
+	for offset >= 0 && offset+headerLength <= len(body) {
+		frame := body[offset : offset+headerLength]
+
+		// the rest of the tag is padding
+		if frame[0] == 0 {
+			return
+		}
+
+		id := string(frame[:idLength])
+
+		frameSize := 0
+		switch version {
+		case 2:
+			frameSize = int(frame[3])<<16 | int(frame[4])<<8 | int(frame[5])
+		case 3:
+			frameSize = int(binary.BigEndian.Uint32(frame[4:8]))
+		default:
+			frameSize = syncsafe(frame[4:8])
+		}
+
+		start := offset + headerLength
+		if frameSize < 0 || start+frameSize > len(body) {
+			return
+		}
+
+		if key, ok := id3Frames[id]; ok && frameSize > 1 {
+			value := id3Text(body[start : start+frameSize])
+
+			// TDRC holds a full timestamp, but the year is what people search for
+			if key == "date" && len(value) > 10 {
+				value = value[:10]
+			}
+
+			tags.set(key, value)
+		}
+
+		offset = start + frameSize
+	}
+}
+
+// id3Text decodes the value of a text frame, whose first byte tells us the encoding. Frames with multiple values have them separated by NUL, we only keep the first
+func id3Text(frame []byte) string {
+	encoding, text := frame[0], frame[1:]
+
+	switch encoding {
+	case 0:
+		return firstValue(latin1(text))
+	case 1, 2:
+		return firstValue(utf16Text(text, encoding == 2))
+	default:
+		return firstValue(string(text))
+	}
+}
+
+// utf16Text decodes UTF-16 text. Without a BOM it's read as big endian, which is what bigEndian tells us the frame uses
+func utf16Text(text []byte, bigEndian bool) string {
+	if len(text) >= 2 {
+		switch {
+		case text[0] == 0xff && text[1] == 0xfe:
+			bigEndian, text = false, text[2:]
+		case text[0] == 0xfe && text[1] == 0xff:
+			bigEndian, text = true, text[2:]
+		}
+	}
+
+	units := make([]uint16, 0, len(text)/2)
+
+	for index := 0; index+1 < len(text); index += 2 {
+		if bigEndian {
+			units = append(units, binary.BigEndian.Uint16(text[index:]))
+		} else {
+			units = append(units, binary.LittleEndian.Uint16(text[index:]))
+		}
+	}
+
+	return string(utf16.Decode(units))
+}
+
+// latin1 decodes ISO-8859-1 text, where every byte is the rune with the same number
+func latin1(text []byte) string {
+	output := strings.Builder{}
+	output.Grow(len(text))
+
+	for _, char := range text {
+		output.WriteRune(rune(char))
+	}
+
+	return output.String()
+}
+
+// firstValue cuts the text at the first NUL
+func firstValue(text string) string {
+	value, _, _ := strings.Cut(text, "\x00")
+	return value
+}
+
+// syncsafe decodes the 28 bit integers of ID3v2, which only use the lower 7 bits of every byte
+func syncsafe(input []byte) int {
+	return int(input[0]&0x7f)<<21 | int(input[1]&0x7f)<<14 | int(input[2]&0x7f)<<7 | int(input[3]&0x7f)
+}
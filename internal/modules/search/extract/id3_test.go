@@ -0,0 +1,108 @@
+// Package extract reads the tags embedded in photos, music and documents, like the EXIF camera of a photo or the ID3 artist of a song.
+package extract
+
+import (
+	"bytes"
+	"encoding/binary"
+	"maps"
+	"strings"
+	"testing"
+)
+
+// testID3v2 builds an ID3v2 tag of the version with the frames, which are id and text pairs. The text is stored as latin1 and the size of the tag is taken from the frames
+func testID3v2(version byte, flags byte, frames ...string) []byte {
+	body := []byte{}
+
+	for index := 0; index+1 < len(frames); index += 2 {
+		text := append([]byte{0}, frames[index+1]...)
+		body = append(body, frames[index]...)
+
+		switch version {
+		case 2:
+			body = append(body, byte(len(text)>>16), byte(len(text)>>8), byte(len(text)))
+		case 3:
+			body = binary.BigEndian.AppendUint32(body, uint32(len(text)))
+			body = append(body, 0, 0)
+		default:
+			body = append(body, syncsafeBytes(len(text))...)
+			body = append(body, 0, 0)
+		}
+
+		body = append(body, text...)
+	}
+
+	header := append([]byte{'I', 'D', '3', version, 0, flags}, syncsafeBytes(len(body))...)
+
+	return append(header, body...)
+}
+
+// syncsafeBytes encodes value as a 28 bit integer of ID3v2
+func syncsafeBytes(value int) []byte {
+	return []byte{byte(value>>21) & 0x7f, byte(value>>14) & 0x7f, byte(value>>7) & 0x7f, byte(value) & 0x7f}
+}
+
+// testID3v1 builds an ID3v1 footer, the fields are padded with NULs
+func testID3v1(title string, artist string, album string, year string) []byte {
+	pad := func(field string, length int) string {
+		return (field + strings.Repeat("\x00", length))[:length]
+	}
+
+	return []byte("TAG" + pad(title, 30) + pad(artist, 30) + pad(album, 30) + pad(year, 4) + strings.Repeat("\x00", 31))
+}
+
+// TestID3Tags reads MP3s with intact, truncated and hostile ID3 tags. None of them may panic
+func TestID3Tags(t *testing.T) {
+	audio := bytes.Repeat([]byte{0xff, 0xfb, 0x90, 0x00}, 64)
+	v3 := testID3v2(3, 0, "TIT2", "Song", "TPE1", "Artist", "TALB", "Album")
+	utf16Frame := append([]byte("TIT2\x00\x00\x00\x09\x00\x00\x01\xff\xfe"), 'H', 0, 'i', 0, 0, 0)
+
+	// a 2.3 frame, whose size is set below
+	hostileFrame := func(size uint32) []byte {
+		frame := binary.BigEndian.AppendUint32([]byte("TIT2"), size)
+		frame = append(frame, 0, 0, 0, 'S', 'o', 'n', 'g')
+
+		return append(append([]byte{'I', 'D', '3', 3, 0, 0}, syncsafeBytes(len(frame))...), frame...)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    Tags
+		wantErr bool
+	}{
+		{"id3v2.3", append(v3, audio...), Tags{"title": "Song", "artist": "Artist", "album": "Album"}, false},
+		{"id3v2.4", testID3v2(4, 0, "TIT2", "Song", "TDRC", "2021-07-04T13:37:00"), Tags{"title": "Song", "date": "2021-07-04"}, false},
+		{"id3v2.2", testID3v2(2, 0, "TT2", "Song", "TP1", "Artist"), Tags{"title": "Song", "artist": "Artist"}, false},
+		{"utf16 with a bom", append(append([]byte{'I', 'D', '3', 3, 0, 0}, syncsafeBytes(len(utf16Frame))...), utf16Frame...), Tags{"title": "Hi"}, false},
+		{"multiple values", testID3v2(3, 0, "TPE1", "First\x00Second"), Tags{"artist": "First"}, false},
+		{"id3v1", append(audio, testID3v1("Song", "Artist", "Album", "2021")...), Tags{"title": "Song", "artist": "Artist", "album": "Album", "date": "2021"}, false},
+		{"id3v2 wins over id3v1", append(append(v3, audio...), testID3v1("Other", "", "", "")...), Tags{"title": "Song", "artist": "Artist", "album": "Album"}, false},
+		{"tag cut off", v3[:len(v3)-3], Tags{"title": "Song", "artist": "Artist"}, false},
+		{"header cut off", v3[:6], Tags{}, false},
+		{"tag size past the file", append([]byte{'I', 'D', '3', 3, 0, 0, 0x7f, 0x7f, 0x7f, 0x7f}, v3[id3HeaderSize:]...), Tags{"title": "Song", "artist": "Artist", "album": "Album"}, false},
+		{"frame size past the tag", hostileFrame(1 << 20), Tags{}, false},
+		{"frame size past the maximum int32", hostileFrame(0xffffffff), Tags{}, false},
+		{"empty frame", hostileFrame(0), Tags{}, false},
+		{"frame with only the encoding", hostileFrame(1), Tags{}, false},
+		{"extended header past the tag", append([]byte{'I', 'D', '3', 3, 0, 0x40, 0, 0, 0, 4}, 0xff, 0xff, 0xff, 0xff), Tags{}, false},
+		{"extended header past the maximum syncsafe", append([]byte{'I', 'D', '3', 4, 0, 0x40, 0, 0, 0, 4}, 0x7f, 0x7f, 0x7f, 0x7f), Tags{}, false},
+		{"extended header cut off", append([]byte{'I', 'D', '3', 3, 0, 0x40, 0, 0, 0, 2}, 0x00, 0x00), Tags{}, false},
+		{"footer cut off", append(audio, testID3v1("Song", "", "", "")[:100]...), Tags{}, false},
+		{"no tags", audio, Tags{}, false},
+		{"empty", []byte{}, Tags{}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := id3Tags(bytes.NewReader(test.data), int64(len(test.data)))
+
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected an error %t, got %v", test.wantErr, err)
+			}
+
+			if !maps.Equal(got, test.want) {
+				t.Fatalf("expected %q, got %q", test.want, got)
+			}
+		})
+	}
+}
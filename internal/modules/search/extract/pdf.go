@@ -0,0 +1,300 @@
+// Package extract reads the tags embedded in photos, music and documents, like the EXIF camera of a photo or the ID3 artist of a song.
+package extract
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+const (
+	pdfTailSize    int   = 64 << 10 // the trailer with the reference to the Info dictionary is at the end of the file
+	pdfObjectSize  int   = 16 << 10 // the Info dictionary is small, we read at most this much of it
+	pdfChunkSize   int   = 1 << 20
+	maxPDFScanSize int64 = 16 << 20 // how much of a file without a classic xref table we scan for the Info dictionary
+	maxXrefHops    int   = 8        // files that were edited have a chain of xref tables
+)
+
+var (
+	pdfInfoRegex      = regexp.MustCompile(`/Info\s+(\d+)\s+(\d+)\s+R`)
+	pdfStartXrefRegex = regexp.MustCompile(`startxref\s+(\d+)`)
+	pdfPrevRegex      = regexp.MustCompile(`/Prev\s+(\d+)`)
+	pdfSectionRegex   = regexp.MustCompile(`^\s*(\d+)\s+(\d+)\s*$`)
+)
+
+// pdfTags reads the title and author of a PDF from its Info dictionary. Encrypted PDFs have their Info encrypted as well, so they return no Tags
+func pdfTags(file io.ReaderAt, size int64) (Tags, error) {
+	tags := Tags{}
+
+	start, err := readAt(file, 0, 5)
+	if err != nil || string(start) != "%PDF-" {
+		return nil, errors.New("pdfTags: not a PDF")
+	}
+
+	tailStart := max(size-int64(pdfTailSize), 0)
+
+	tail, err := readAt(file, tailStart, int(size-tailStart))
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Contains(tail, []byte("/Encrypt")) {
+		return tags, nil
+	}
+
+	// the last /Info belongs to the newest trailer
+	infoRefs := pdfInfoRegex.FindAllSubmatch(tail, -1)
+	if len(infoRefs) == 0 {
+		return tags, nil
+	}
+
+	infoRef := infoRefs[len(infoRefs)-1]
+	objectNumber, _ := strconv.Atoi(string(infoRef[1]))
+
+	offset, ok := pdfXrefOffset(file, tail, objectNumber)
+	if !ok {
+		offset, ok = pdfScanObject(file, size, infoRef[1], infoRef[2])
+	}
+
+	if !ok {
+		return tags, nil
+	}
+
+	object, err := readAt(file, offset, pdfObjectSize)
+	if err != nil {
+		return nil, err
+	}
+
+	dictStart := bytes.Index(object, []byte("<<"))
+	if dictStart < 0 {
+		return tags, nil
+	}
+
+	object = object[dictStart:]
+	if dictEnd := bytes.Index(object, []byte(">>")); dictEnd >= 0 {
+		object = object[:dictEnd]
+	}
+
+	tags.set("title", pdfValue(object, "/Title"))
+	tags.set("author", pdfValue(object, "/Author"))
+
+	return tags, nil
+}
+
+// pdfXrefOffset looks the object up in the classic xref tables, starting at the one startxref points to and following their /Prev
+func pdfXrefOffset(file io.ReaderAt, tail []byte, objectNumber int) (int64, bool) {
+	startXrefs := pdfStartXrefRegex.FindAllSubmatch(tail, -1)
+	if len(startXrefs) == 0 {
+		return 0, false
+	}
+
+	xrefOffset, _ := strconv.ParseInt(string(startXrefs[len(startXrefs)-1][1]), 10, 64)
+
+	for range maxXrefHops {
+		table, err := readAt(file, xrefOffset, pdfObjectSize)
+		// PDFs from 1.5 on can use compressed xref streams instead, which we don't read
+		if err != nil || !bytes.HasPrefix(bytes.TrimLeft(table, " \r\n"), []byte("xref")) {
+			return 0, false
+		}
+
+		if offset, ok := pdfXrefLookup(table, objectNumber); ok {
+			return offset, true
+		}
+
+		previous := pdfPrevRegex.FindSubmatch(table)
+		if previous == nil {
+			return 0, false
+		}
+
+		xrefOffset, _ = strconv.ParseInt(string(previous[1]), 10, 64)
+	}
+
+	return 0, false
+}
+
+// pdfXrefLookup finds the offset of the object in an xref table, whose sections are a "<first object> <count>" line followed by count entries like "0000012345 00000 n"
+func pdfXrefLookup(table []byte, objectNumber int) (int64, bool) {
+	lines := bytes.FieldsFunc(table, func(char rune) bool {
+		return char == '\r' || char == '\n'
+	})
+
+	for index := 1; index < len(lines); index++ {
+		section := pdfSectionRegex.FindSubmatch(lines[index])
+		if section == nil {
+			return 0, false
+		}
+
+		first, _ := strconv.Atoi(string(section[1]))
+		count, _ := strconv.Atoi(string(section[2]))
+
+		if objectNumber >= first && objectNumber-first < count {
+			// the object number comes from the file as well, so it's compared with the lines left before it's added
+			if objectNumber-first >= len(lines)-index-1 {
+				return 0, false
+			}
+
+			entry := bytes.Fields(lines[index+1+objectNumber-first])
+			if len(entry) != 3 || string(entry[2]) != "n" {
+				return 0, false
+			}
+
+			offset, err := strconv.ParseInt(string(entry[0]), 10, 64)
+			return offset, err == nil
+		}
+
+		// the counts come from the file, a section that claims more entries than there are lines left doesn't lead to another section we could read
+		if count > len(lines)-index-1 {
+			return 0, false
+		}
+
+		index += count
+	}
+
+	return 0, false
+}
+
+// pdfScanObject searches the start of the file for "<number> <generation> obj", for the PDFs we can't look the object up for
+func pdfScanObject(file io.ReaderAt, size int64, number []byte, generation []byte) (int64, bool) {
+	pattern := regexp.MustCompile(`(?:^|\s)` + string(number) + `\s+` + string(generation) + `\s+obj\b`)
+	overlap := int64(64)
+
+	for offset := int64(0); offset < min(size, maxPDFScanSize); offset += int64(pdfChunkSize) - overlap {
+		chunk, err := readAt(file, offset, pdfChunkSize)
+		if err != nil {
+			return 0, false
+		}
+
+		if match := pattern.FindIndex(chunk); match != nil {
+			return offset + int64(match[0]), true
+		}
+
+		if len(chunk) < pdfChunkSize {
+			return 0, false
+		}
+	}
+
+	return 0, false
+}
+
+// pdfValue returns the string value of the key in the dictionary, which is either a literal string in parentheses or a hex string in angle brackets
+func pdfValue(dict []byte, key string) string {
+	index := bytes.Index(dict, []byte(key))
+	if index < 0 {
+		return ""
+	}
+
+	value := bytes.TrimLeft(dict[index+len(key):], " \t\r\n")
+	if len(value) == 0 {
+		return ""
+	}
+
+	switch value[0] {
+	case '(':
+		return pdfText(pdfLiteralString(value[1:]))
+	case '<':
+		end := bytes.IndexByte(value, '>')
+		if end < 0 {
+			return ""
+		}
+
+		return pdfText(pdfHexString(value[1:end]))
+	default:
+		// indirect values like "/Title 12 0 R" aren't worth another lookup
+		return ""
+	}
+}
+
+// pdfLiteralString decodes a literal string up to its closing parenthesis, the opening one is already cut off. Balanced parentheses inside of it don't need escaping
+func pdfLiteralString(input []byte) []byte {
+	output := []byte{}
+	depth := 0
+
+	for index := 0; index < len(input); index++ {
+		char := input[index]
+
+		switch {
+		case char == '(':
+			depth++
+		case char == ')':
+			if depth == 0 {
+				return output
+			}
+
+			depth--
+		case char == '\\' && index+1 < len(input):
+			index++
+			escaped := input[index]
+
+			switch escaped {
+			case 'n':
+				char = '\n'
+			case 'r':
+				char = '\r'
+			case 't':
+				char = '\t'
+			case 'b':
+				char = '\b'
+			case 'f':
+				char = '\f'
+			case '\r', '\n':
+				// a backslash at the end of a line continues the string on the next one
+				if escaped == '\r' && index+1 < len(input) && input[index+1] == '\n' {
+					index++
+				}
+
+				continue
+			default:
+				if escaped >= '0' && escaped <= '7' {
+					end := index
+					for end < len(input) && end < index+3 && input[end] >= '0' && input[end] <= '7' {
+						end++
+					}
+
+					octal, _ := strconv.ParseUint(string(input[index:end]), 8, 8)
+					char = byte(octal)
+					index = end - 1
+				} else {
+					char = escaped
+				}
+			}
+		}
+
+		output = append(output, char)
+	}
+
+	return output
+}
+
+// pdfHexString decodes a hex string, where whitespace is ignored and a missing last digit counts as 0
+func pdfHexString(input []byte) []byte {
+	digits := []byte{}
+	for _, char := range input {
+		if _, err := strconv.ParseUint(string(char), 16, 8); err == nil {
+			digits = append(digits, char)
+		}
+	}
+
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+
+	output := make([]byte, 0, len(digits)/2)
+
+	for index := 0; index < len(digits); index += 2 {
+		value, _ := strconv.ParseUint(string(digits[index:index+2]), 16, 8)
+		output = append(output, byte(value))
+	}
+
+	return output
+}
+
+// pdfText decodes the bytes of a PDF string, which are UTF-16 with a BOM or otherwise close enough to latin1
+func pdfText(input []byte) string {
+	if len(input) >= 2 && input[0] == 0xfe && input[1] == 0xff {
+		return firstValue(utf16Text(input, true))
+	}
+
+	return firstValue(latin1(input))
+}
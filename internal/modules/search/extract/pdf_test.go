@@ -0,0 +1,105 @@
+// Package extract reads the tags embedded in photos, music and documents, like the EXIF camera of a photo or the ID3 artist of a song.
+package extract
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"strings"
+	"testing"
+)
+
+// testPDF builds a PDF with the info object as object 1. The xref table is left out, if xref is empty, otherwise %d in it is replaced with the offset of the object
+func testPDF(info string, xref string, trailer string) []byte {
+	header := "%PDF-1.4\n"
+	object := fmt.Sprintf("1 0 obj\n%s\nendobj\n", info)
+	body := header + object
+
+	if xref != "" {
+		if strings.Contains(xref, "%") {
+			xref = fmt.Sprintf(xref, len(header))
+		}
+
+		trailer = fmt.Sprintf("%sstartxref\n%d\n", trailer, len(body))
+		body += xref
+	}
+
+	return []byte(body + trailer + "%%EOF\n")
+}
+
+// TestPDFTags reads PDFs with intact, broken and hostile xref tables and trailers. None of them may panic
+func TestPDFTags(t *testing.T) {
+	info := "<< /Title (Hello \\(World\\)) /Author <FEFF00410042> >>"
+	tags := Tags{"title": "Hello (World)", "author": "AB"}
+	trailer := "trailer\n<< /Size 2 /Info 1 0 R >>\n"
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    Tags
+		wantErr bool
+	}{
+		{"xref table", testPDF(info, "xref\n0 2\n0000000000 65535 f\n%010d 00000 n\n", trailer), tags, false},
+		{"no xref table", testPDF(info, "", trailer), tags, false},
+		{"xref section larger than the file", testPDF(info, "xref\n10 9223372036854775807\n0000000000 65535 f\n", trailer), tags, false},
+		{"xref section past the maximum int", testPDF(info, "xref\n0 99999999999999999999999\n0000000000 65535 f\n", trailer), tags, false},
+		{"object number past the maximum int", testPDF(info, "xref\n0 2\n0000000000 65535 f\n%010d 00000 n\n", "trailer\n<< /Info 99999999999999999999999 0 R >>\n"), Tags{}, false},
+		{"xref entry pointing nowhere", testPDF(info, "xref\n0 2\n0000000000 65535 f\n9999999999 00000 n\n", trailer), Tags{}, false},
+		{"xref entry that isn't in use", testPDF(info, "xref\n0 2\n0000000000 65535 f\n%010d 00000 f\n", trailer), tags, false},
+		{"startxref pointing nowhere", []byte("%PDF-1.4\n1 0 obj\n" + info + "\nendobj\ntrailer\n<< /Info 1 0 R >>\nstartxref\n99999999999\n%%EOF"), tags, false},
+		{"prev pointing back at itself", testPDF(info, "xref\n5 1\n0000000000 65535 f\ntrailer\n<< /Prev 9 >>\n", trailer), tags, false},
+		{"unterminated strings", testPDF("<< /Title (Hello /Author <FEFF", "", trailer), Tags{"title": "Hello /Author <FEFF\nendobj\ntrailer\n<< /Size 2 /Info 1 0 R"}, false},
+		{"encrypted", testPDF(info, "", "trailer\n<< /Info 1 0 R /Encrypt 2 0 R >>\n"), Tags{}, false},
+		{"no info", testPDF(info, "", "trailer\n<< /Size 2 >>\n"), Tags{}, false},
+		{"only the header", []byte("%PDF-"), Tags{}, false},
+		{"truncated header", []byte("%PD"), nil, true},
+		{"empty", []byte{}, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := pdfTags(bytes.NewReader(test.data), int64(len(test.data)))
+
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected an error %t, got %v", test.wantErr, err)
+			}
+
+			if !maps.Equal(got, test.want) {
+				t.Fatalf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+// TestPDFXrefLookup looks objects up in xref tables, whose counts and object numbers can't be trusted
+func TestPDFXrefLookup(t *testing.T) {
+	tests := []struct {
+		name   string
+		table  string
+		object int
+		want   int64
+		wantOk bool
+	}{
+		{"first section", "xref\n0 3\n0000000000 65535 f\n0000000015 00000 n\n0000000042 00000 n\n", 2, 42, true},
+		{"second section", "xref\n0 1\n0000000000 65535 f\n4 2\n0000000100 00000 n\n0000000200 00000 n\n", 5, 200, true},
+		{"section cut off by the read", "xref\n0 1000\n0000000000 65535 f\n0000000015 00000 n\n", 1, 15, true},
+		{"object in the cut off part", "xref\n0 1000\n0000000000 65535 f\n0000000015 00000 n\n", 500, 0, false},
+		{"count past the lines", "xref\n10 9223372036854775807\n0000000000 65535 f\n", 5, 0, false},
+		{"count past the maximum int", "xref\n10 99999999999999999999\n0000000000 65535 f\n", 5, 0, false},
+		{"first past the maximum int", "xref\n99999999999999999999 1\n0000000000 65535 f\n", 5, 0, false},
+		{"object and count past the maximum int", "xref\n0 9223372036854775807\n0000000000 65535 f\n", 9223372036854775806, 0, false},
+		{"broken section", "xref\nnot a section\n", 0, 0, false},
+		{"broken entry", "xref\n0 1\n0000000015 n\n", 0, 0, false},
+		{"only the keyword", "xref", 0, 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := pdfXrefLookup([]byte(test.table), test.object)
+
+			if got != test.want || ok != test.wantOk {
+				t.Fatalf("expected %d %t, got %d %t", test.want, test.wantOk, got, ok)
+			}
+		})
+	}
+}
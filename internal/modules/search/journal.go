@@ -0,0 +1,93 @@
+// Package search handles the search, aswell as ranking and sorting of the results.
+package search
+
+import (
+	"cmp"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/skillptm/Bolt/internal/modules/search/cache"
+)
+
+// StartJournal searches the journals of the provided scopes for the files that changed after since, newest first. Without deleted these are the added, modified and renamed files, with it the deleted ones and the old paths of the renamed ones.
+// If there is a searchInput, the file name has to contain it. Like with Start, the forceStopChan makes it yield no results and of the first verifyCount results only the ones that (still or no longer) exist on the disk are kept
+func StartJournal(searchInput string, deleted bool, since time.Time, fs *cache.Filesystem, forceStopChan chan bool, scopes []*cache.Dirs, fileExtensions []string, verifyCount int) []string {
+	output := []string{}
+	pattern := newSearchString(searchInput, fileExtensions, fs.IgnoreDiacritics)
+
+	kinds := []cache.ChangeKind{cache.ChangeAdded, cache.ChangeModified, cache.ChangeRenamed}
+	if deleted {
+		kinds = []cache.ChangeKind{cache.ChangeDeleted, cache.ChangeRenamed}
+	}
+
+	changes := []cache.Change{}
+
+	for _, dirs := range scopes {
+		if len(forceStopChan) > 0 {
+			return output
+		}
+
+		for _, change := range dirs.Changes(kinds, since) {
+			// for deleted files the old path of a renamed one is what's gone
+			if deleted && change.Kind == cache.ChangeRenamed {
+				change.Path = change.OldPath
+			}
+
+			if pattern.matchesPath(change.Path) {
+				changes = append(changes, change)
+			}
+		}
+	}
+
+	if len(forceStopChan) > 0 {
+		return output
+	}
+
+	slices.SortStableFunc(changes, func(a cache.Change, b cache.Change) int {
+		return cmp.Compare(b.Time, a.Time)
+	})
+
+	listed := make(map[string]bool)
+
+	for _, change := range changes {
+		// a file that changed more than once, or is in more than one scope, is only listed once
+		if listed[change.Path] {
+			continue
+		}
+
+		listed[change.Path] = true
+
+		if len(output) < verifyCount {
+			// new files that are gone again and deleted ones that came back are skipped
+			if _, err := os.Lstat(change.Path); (err == nil) == deleted {
+				continue
+			}
+		}
+
+		output = append(output, change.Path)
+	}
+
+	return output
+}
+
+// matchesPath checks, if the file name of the path contains the searchString and has one of its extensions
+func (sStr *searchString) matchesPath(filePath string) bool {
+	isFolder := strings.HasSuffix(filePath, string(filepath.Separator))
+	name := filepath.Base(filePath)
+
+	if len(sStr.extensions) > 0 {
+		extension := strings.ToLower(filepath.Ext(name))
+		if isFolder {
+			extension = "folder"
+		}
+
+		if !slices.Contains(sStr.extensions, extension) {
+			return false
+		}
+	}
+
+	return strings.Contains(cache.Normalize(name, sStr.ignoreDiacritics), sStr.name)
+}
@@ -2,8 +2,6 @@
 package search
 
 import (
-	"io/fs"
-	"strconv"
 	"strings"
 	"time"
 )
@@ -28,32 +26,30 @@ type rankedFile struct {
 }
 
 // newRankedFile constructor for rankedFile
-func newRankedFile(fileInfo fs.FileInfo, file []string, filePath string, pattern *searchString, defaultDirs map[string]bool) *rankedFile {
-	newFile := rankedFile{filePath, 0}
+func newRankedFile(file *foundFile, pattern *searchString, defaultDirs map[string]bool) *rankedFile {
+	newFile := rankedFile{file.fullPath(), 0}
 
-	if file[1] == pattern.name {
+	if file.normalizedName == pattern.name {
 		newFile.points += exactMatch
 	}
 
-	if index, err := strconv.Atoi(file[3]); err == nil {
-		newFile.points += subStringEarlyMax - (10 * index)
-	}
+	newFile.points += subStringEarlyMax - (10 * file.index)
 
-	modifiedSecondsAgo := min(time.Now().UTC().Unix()-fileInfo.ModTime().UTC().Unix(), int64(fourYearsInSeconds))
+	modifiedSecondsAgo := min(time.Now().UTC().Unix()-time.Unix(0, file.metadata.ModTime).UTC().Unix(), int64(fourYearsInSeconds))
 	newFile.points += int(recentlyModifiedMax * (1 - float64(modifiedSecondsAgo)/float64(fourYearsInSeconds)))
 
-	newFile.points += notDeeplyNestedMax + (-10 * strings.Count(file[0], "/"))
+	newFile.points += notDeeplyNestedMax + (-10 * strings.Count(file.path, "/"))
 
-	newFile.points += int(lengthDifferenceMax * float64(len(pattern.name)) / float64(len(file[1])))
+	newFile.points += int(lengthDifferenceMax * float64(len(pattern.name)) / float64(len(file.normalizedName)))
 
 	for dir := range defaultDirs {
-		if strings.HasPrefix(file[0], dir) {
+		if strings.HasPrefix(file.path, dir) {
 			newFile.points += inDefaultDirs
 			break
 		}
 	}
 
-	if fileInfo.Size() > minimumSizeAmount {
+	if file.metadata.Size > minimumSizeAmount {
 		newFile.points += minimumSize
 	}
 
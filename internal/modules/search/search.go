@@ -3,25 +3,37 @@ package search
 
 import (
 	"fmt"
-	"maps"
-	"os"
-	"slices"
-	"strconv"
 	"strings"
 	"sync"
+	"unicode/utf8"
 
 	"github.com/skillptm/Bolt/internal/modules/search/cache"
 )
 
+// foundFilesSize is the amount of found files that may wait for the ranking, before the searchFS goroutines have to wait for it
+const foundFilesSize int = 4096
+
+// foundFile holds a file found by searchFS, with everything the ranking needs to know about it
+type foundFile struct {
+	extension      string
+	index          int
+	metadata       cache.Metadata
+	name           string
+	normalizedName string
+	path           string
+}
+
 // searchString holds all the data releated to the searchString input, so we only have to calculate them once
 type searchString struct {
-	encoded    [8]byte
-	extensions []string
-	name       string
+	ascii            bool
+	encoded          [8]byte
+	extensions       []string
+	ignoreDiacritics bool
+	name             string
 }
 
 // NewSearchString returns a pointer to a searchString struct based on the string input
-func newSearchString(searchInput string, fileExtensions []string) *searchString {
+func newSearchString(searchInput string, fileExtensions []string, ignoreDiacritics bool) *searchString {
 	properExtensions := []string{}
 
 	// make sure all extensions begin with a period, unless it's "folder"
@@ -37,31 +49,50 @@ func newSearchString(searchInput string, fileExtensions []string) *searchString
 		properExtensions = append(properExtensions, element)
 	}
 
+	name := cache.Normalize(searchInput, ignoreDiacritics)
+
 	return &searchString{
-		encoded:    cache.Encode(searchInput),
-		extensions: properExtensions,
-		name:       strings.ToLower(searchInput),
+		ascii:            isASCII(name),
+		encoded:          cache.Encode(name),
+		extensions:       properExtensions,
+		ignoreDiacritics: ignoreDiacritics,
+		name:             name,
 	}
 }
 
-// Start wraps around searchFS and then also sorts and ranks the results. The forceStopChan can search it to end it's search early. This will make it yield no results.
-func Start(searchInput string, fs *cache.Filesystem, forceStopChan chan bool, literalSearch bool, extendedSearch bool, fileExtensions []string) []string {
+// Start wraps around searchFS and then also sorts and ranks the results of all the provided scopes. The forceStopChan can search it to end it's search early. This will make it yield no results.
+// Ranking is based on the Metadata from the index, only the first verifyCount results are checked to still exist on the disk, as those are the ones that will be displayed
+func Start(searchInput string, fs *cache.Filesystem, forceStopChan chan bool, literalSearch bool, scopes []*cache.Dirs, fileExtensions []string, verifyCount int) []string {
 	if len(searchInput) < 1 {
 		return []string{}
 	}
 
 	output := []string{}
-	pattern := newSearchString(searchInput, fileExtensions)
-	foundFilesChan := make(chan *[]string, 10000000)
+	pattern := newSearchString(searchInput, fileExtensions, fs.IgnoreDiacritics)
+	foundFilesChan := make(chan *foundFile, foundFilesSize)
+	defaultBaseDirs := fs.DefaultBaseDirs()
 	rankedFiles := []rankedFile{}
 	wg := sync.WaitGroup{}
 
-	wg.Add(1)
-	go pattern.searchFS(literalSearch, &fs.DefaultDirs, foundFilesChan, forceStopChan, &wg)
+	// updates and the watcher change the index while we search, so every scope is searched as it was when we started
+	indexes := []cache.Index{}
+
+	for _, dirs := range scopes {
+		if index := dirs.Index(); index != nil {
+			indexes = append(indexes, index)
+		}
+	}
 
-	if extendedSearch {
+	// the volumes, that would be in the scopes, are searched with them, even while they're unplugged
+	for _, volume := range fs.VolumesOf(scopes) {
+		if index := volume.Index(); index != nil {
+			indexes = append(indexes, index)
+		}
+	}
+
+	for _, index := range indexes {
 		wg.Add(1)
-		go pattern.searchFS(literalSearch, &fs.ExtendedDirs, foundFilesChan, forceStopChan, &wg)
+		go pattern.searchFS(literalSearch, index, foundFilesChan, forceStopChan, &wg)
 	}
 
 	go func() {
@@ -71,23 +102,16 @@ func Start(searchInput string, fs *cache.Filesystem, forceStopChan chan bool, li
 
 	for foundFile := range foundFilesChan {
 		if len(forceStopChan) > 0 {
-			return output
-		}
-		fullPath := ""
-
-		if (*foundFile)[2] != "folder" {
-			fullPath = fmt.Sprintf("%s%s%s", (*foundFile)[0], (*foundFile)[1], (*foundFile)[2])
-		} else {
-			fullPath = (*foundFile)[0]
-		}
+			// the searchFS goroutines might be waiting to send, so they need someone to take their last results
+			go func() {
+				for range foundFilesChan {
+				}
+			}()
 
-		fileInfo, err := os.Stat(fullPath)
-		// if we error, it's most likely the file doesn't exist anymore, so we skip it
-		if err != nil {
-			continue
+			return output
 		}
 
-		rankedFiles = append(rankedFiles, *newRankedFile(fileInfo, *foundFile, fullPath, pattern, fs.DefaultDirs.BaseDirs))
+		rankedFiles = append(rankedFiles, *newRankedFile(foundFile, pattern, defaultBaseDirs))
 	}
 
 	if len(forceStopChan) > 0 {
@@ -97,50 +121,72 @@ func Start(searchInput string, fs *cache.Filesystem, forceStopChan chan bool, li
 	quickSort(rankedFiles)
 
 	for _, rankedFile := range rankedFiles {
+		// files on unplugged volumes can't be checked, so they're shown as they were last seen
+		if _, _, offline := cache.SplitOfflinePath(rankedFile.path); len(output) < verifyCount && !offline {
+			// if we error, it's most likely the file doesn't exist anymore, so we skip it. Members of archives are checked through their archive
+			if _, err := cache.Lstat(rankedFile.path); err != nil {
+				continue
+			}
+		}
+
 		output = append(output, rankedFile.path)
 	}
 
 	return output
 }
 
-// searchFS searches one of the provided FileSystem maps, while skiping files for wrong extensions and ecoded values
-func (sStr *searchString) searchFS(literalSearch bool, dirs *cache.Dirs, foundFilesChan chan<- *[]string, forceStopChan chan bool, wg *sync.WaitGroup) {
+// searchFS searches the Index of one of the provided scopes, while skiping files for wrong extensions and ecoded values
+func (sStr *searchString) searchFS(literalSearch bool, index cache.Index, foundFilesChan chan<- *foundFile, forceStopChan chan bool, wg *sync.WaitGroup) {
 	defer wg.Done()
-	extensionsToCheck := []string{}
+	defer index.Release()
 
-	if len(sStr.extensions) > 0 {
-		for _, searchExt := range sStr.extensions {
-			if _, ok := dirs.DirMap[searchExt]; ok {
-				extensionsToCheck = append(extensionsToCheck, searchExt)
-			}
-		}
-	} else {
-		extensionsToCheck = slices.Collect(maps.Keys(dirs.DirMap))
+	// normalizing never makes ascii longer, but names in other scripts can grow or shrink, so for those we can't rule out any length
+	minLength := 0
+	if sStr.ascii {
+		minLength = len(sStr.name)
 	}
 
-	for _, extension := range extensionsToCheck {
-		for length, files := range dirs.DirMap[extension] {
-			if literalSearch && length != len(sStr.name) {
-				continue
-			}
+	// without any extensions every one of them is searched, an Index we can't read any further is treated like it has no more matches
+	index.Query(sStr.extensions, minLength, sStr.encoded, sStr.name, func(extension string, file cache.File) bool {
+		if len(forceStopChan) > 0 {
+			return false
+		}
 
-			if length < len(sStr.name) {
-				continue
-			}
+		sStr.matchFile(file, extension, literalSearch, index, foundFilesChan)
 
-			for _, file := range files {
-				if len(forceStopChan) > 0 {
-					return
-				}
+		return true
+	})
+}
 
-				if !cache.CompareEncoding(sStr.encoded, file.EncodedName) {
-					continue
-				}
+// matchFile sends the file to the foundFilesChan, if its normalized name contains the searchString (or is it, for a literalSearch)
+func (sStr *searchString) matchFile(file cache.File, extension string, literalSearch bool, index cache.Index, foundFilesChan chan<- *foundFile) {
+	normalizedName := cache.Normalize(file.Name, sStr.ignoreDiacritics)
 
-				if index := strings.Index(strings.ToLower(file.Name), sStr.name); index >= 0 {
-					foundFilesChan <- &[]string{dirs.Paths[file.PathKey], file.Name, extension, strconv.Itoa(index)}
-				}
-			}
+	if literalSearch && normalizedName != sStr.name {
+		return
+	}
+
+	if position := strings.Index(normalizedName, sStr.name); position >= 0 {
+		foundFilesChan <- &foundFile{extension, position, file.Metadata, file.Name, normalizedName, index.Path(file.PathKey)}
+	}
+}
+
+// isASCII checks, if the input only consists of ascii characters
+func isASCII(input string) bool {
+	for _, char := range input {
+		if char >= utf8.RuneSelf {
+			return false
 		}
 	}
+
+	return true
+}
+
+// fullPath returns the absolute path of the foundFile, folders already have it as their path
+func (file *foundFile) fullPath() string {
+	if file.extension == "folder" {
+		return file.path
+	}
+
+	return fmt.Sprintf("%s%s%s", file.path, file.name, file.extension)
 }
@@ -0,0 +1,149 @@
+// Package search handles the search, aswell as ranking and sorting of the results.
+package search
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/skillptm/Bolt/internal/config"
+	"github.com/skillptm/Bolt/internal/modules/search/cache"
+)
+
+// stableCount is how many files named stable-<n>.txt TestConcurrentSearchAndUpdate creates, they're never changed while it runs
+const stableCount int = 30
+
+// TestConcurrentSearchAndUpdate runs searches in a loop, while updates, the watcher and imports change the index underneath them. Run it with -race, every search has to see a whole Generation of the index
+func TestConcurrentSearchAndUpdate(t *testing.T) {
+	for _, storage := range []string{config.StorageMemory, config.StorageSQLite} {
+		t.Run(storage, func(t *testing.T) {
+			testConcurrentSearchAndUpdate(t, storage)
+		})
+	}
+}
+
+// testConcurrentSearchAndUpdate is TestConcurrentSearchAndUpdate for a single Storage
+func testConcurrentSearchAndUpdate(t *testing.T, storage string) {
+	baseDir := t.TempDir() + string(filepath.Separator)
+
+	for index := range stableCount {
+		folder := filepath.Join(baseDir, fmt.Sprintf("folder-%d", index))
+
+		if err := os.MkdirAll(filepath.Join(folder, "sub"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(filepath.Join(folder, fmt.Sprintf("stable-%d.txt", index)), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conf := &config.Config{
+		MaxCPUThreads: 4,
+		TrigramIndex:  true,
+		Scopes:        []config.Scope{{Name: "test", Default: true, UpdateTime: 3600, Dirs: []string{baseDir}, Storage: storage}},
+		Paths:         map[string]string{"cache": t.TempDir()},
+	}
+
+	fs, err := cache.NewFilesystem(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	dirs := fs.Scopes[0]
+	dirs.Import()
+
+	// while the cache is cleared a search finds nothing, otherwise it has to find every stable file
+	var clearing atomic.Bool
+	stop := make(chan struct{})
+	searchers := sync.WaitGroup{}
+
+	for range 4 {
+		searchers.Add(1)
+
+		go func() {
+			defer searchers.Done()
+
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				results := Start("stable", fs, make(chan bool, 1), false, fs.Scopes, nil, 0)
+
+				if len(results) < stableCount && !(clearing.Load() && len(results) == 0) {
+					t.Errorf("a search found %d of the %d stable files", len(results), stableCount)
+					return
+				}
+
+				for _, result := range results {
+					if !strings.HasPrefix(result, baseDir) {
+						t.Errorf("a search found %s, which isn't in %s", result, baseDir)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	// the watcher applies these as batches, while the updates below publish whole Generations
+	churn := sync.WaitGroup{}
+	churn.Add(1)
+
+	go func() {
+		defer churn.Done()
+
+		for index := range 60 {
+			folder := filepath.Join(baseDir, fmt.Sprintf("folder-%d", index%stableCount))
+
+			os.WriteFile(filepath.Join(folder, fmt.Sprintf("churn-%d.txt", index)), nil, 0o644)
+			os.MkdirAll(filepath.Join(baseDir, fmt.Sprintf("churn-%d", index), "deep"), 0o755)
+
+			if index%3 == 0 && index >= 3 {
+				os.RemoveAll(filepath.Join(baseDir, fmt.Sprintf("churn-%d", index-3)))
+			}
+
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+
+	updates := sync.WaitGroup{}
+
+	for range 3 {
+		updates.Add(2)
+
+		go func() {
+			defer updates.Done()
+			fs.Update(dirs, false)
+		}()
+
+		go func() {
+			defer updates.Done()
+			fs.Update(dirs, true)
+		}()
+	}
+
+	updates.Wait()
+	churn.Wait()
+
+	clearing.Store(true)
+
+	for range 3 {
+		dirs.Clear()
+
+		if err := dirs.Import(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	close(stop)
+	searchers.Wait()
+}
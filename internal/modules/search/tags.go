@@ -0,0 +1,71 @@
+// Package search handles the search, aswell as ranking and sorting of the results.
+package search
+
+import (
+	"cmp"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/skillptm/Bolt/internal/modules/search/cache"
+)
+
+// StartTags searches the tag indexes of the provided scopes for the files, whose tags contain all the values of the filters. If there is a searchInput, the file name or title has to contain it as well.
+// Files where more filters are the whole tag come first, the rest is sorted by path, which keeps albums and photo folders together. Like with Start, the forceStopChan makes it yield no results and only the first verifyCount results are checked to still exist on the disk
+func StartTags(searchInput string, filters map[string]string, fs *cache.Filesystem, forceStopChan chan bool, scopes []*cache.Dirs, fileExtensions []string, verifyCount int) []string {
+	output := []string{}
+	pattern := newSearchString(searchInput, fileExtensions, fs.IgnoreDiacritics)
+
+	normalizedFilters := make(map[string]string, len(filters))
+	for key, value := range filters {
+		normalizedFilters[key] = cache.Normalize(value, fs.IgnoreDiacritics)
+	}
+
+	matches := make(map[string]cache.TagMatch)
+
+	for _, dirs := range scopes {
+		if len(forceStopChan) > 0 {
+			return output
+		}
+
+		for _, match := range dirs.SearchTags(normalizedFilters) {
+			if len(pattern.extensions) > 0 && !slices.Contains(pattern.extensions, strings.ToLower(filepath.Ext(match.Path))) {
+				continue
+			}
+
+			if pattern.name != "" && !strings.Contains(cache.Normalize(filepath.Base(match.Path), fs.IgnoreDiacritics), pattern.name) && !strings.Contains(cache.Normalize(match.Tags["title"], fs.IgnoreDiacritics), pattern.name) {
+				continue
+			}
+
+			// a file in more than one scope is only listed once
+			matches[match.Path] = match
+		}
+	}
+
+	if len(forceStopChan) > 0 {
+		return output
+	}
+
+	rankedMatches := make([]cache.TagMatch, 0, len(matches))
+	for _, match := range matches {
+		rankedMatches = append(rankedMatches, match)
+	}
+
+	slices.SortFunc(rankedMatches, func(a cache.TagMatch, b cache.TagMatch) int {
+		return cmp.Or(cmp.Compare(b.Exact, a.Exact), cmp.Compare(a.Path, b.Path))
+	})
+
+	for _, match := range rankedMatches {
+		if len(output) < verifyCount {
+			// if we error, it's most likely the file doesn't exist anymore, so we skip it
+			if _, err := os.Lstat(match.Path); err != nil {
+				continue
+			}
+		}
+
+		output = append(output, match.Path)
+	}
+
+	return output
+}
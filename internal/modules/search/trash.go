@@ -0,0 +1,36 @@
+// Package search handles the search, aswell as ranking and sorting of the results.
+package search
+
+import (
+	"os"
+
+	"github.com/skillptm/Bolt/internal/modules/search/cache"
+)
+
+// StartTrash searches the trashes for the files that were deleted into them, the most recently deleted ones first. The results are the paths of the TrashEntries, which are the paths the files were deleted from behind "trash (<deletion date>)", so they can be found and restored with them.
+// If there is a searchInput, the file name has to contain it. Like with Start, the forceStopChan makes it yield no results and of the first verifyCount results only the ones that are still in the trash are kept
+func StartTrash(searchInput string, fs *cache.Filesystem, forceStopChan chan bool, fileExtensions []string, verifyCount int) []string {
+	output := []string{}
+	pattern := newSearchString(searchInput, fileExtensions, fs.IgnoreDiacritics)
+
+	for _, entry := range fs.Trash() {
+		if len(forceStopChan) > 0 {
+			return []string{}
+		}
+
+		if !pattern.matchesPath(entry.OriginalPath) {
+			continue
+		}
+
+		if len(output) < verifyCount {
+			// if we error, it's most likely the trash was emptied in the meantime, so we skip it
+			if _, err := os.Lstat(entry.TrashedPath); err != nil {
+				continue
+			}
+		}
+
+		output = append(output, entry.Path())
+	}
+
+	return output
+}
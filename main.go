@@ -2,12 +2,14 @@ package main
 
 import (
 	"embed"
+	"os"
 
 	"github.com/wailsapp/wails/v2"
 	"github.com/wailsapp/wails/v2/pkg/options"
 	"github.com/wailsapp/wails/v2/pkg/options/assetserver"
 
 	"github.com/skillptm/Bolt/internal/app"
+	"github.com/skillptm/Bolt/internal/cli"
 	"github.com/skillptm/Bolt/internal/logger"
 )
 
@@ -21,6 +23,11 @@ var (
 )
 
 func main() {
+	// subcommands like "bolt stats" are handled without ever opening the app, any other arguments are left to the app
+	if len(os.Args) > 1 && cli.IsSubcommand(os.Args[1]) {
+		os.Exit(cli.Run(os.Args[1:], icon))
+	}
+
 	lg := &logger.Logger{}
 
 	appInstance, err := app.NewApp(lg, images, icon)